@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/dbfile"
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/spf13/cobra"
+)
+
+var (
+	filePath string
+	walPath  string
+	keyPath  string
+	basePath string
+)
+
+func Command() *cobra.Command {
+	var backupCmd = &cobra.Command{
+		Use:   "backup <backup-file>",
+		Short: "Back up a database",
+		Long: `Write a consistent copy of the current version of a database into a backup file. With
+--base, an incremental backup is written, which only holds the pages changed since the given
+previous backup. Restore the backups with the restore command. The database must not be in use by
+another process while it is backed up, which is refused otherwise.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var opts []pager.Option
+			if keyPath != "" {
+				key, err := dbfile.ReadKey(keyPath)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, pager.Encryption(key))
+			}
+			return backup(cmd, args[0], opts...)
+		},
+	}
+
+	backupCmd.Flags().StringVarP(&filePath, "file", "f", "/var/lib/pavosql/pavosql.db", "")
+	backupCmd.Flags().StringVar(&walPath, "wal", "", "write-ahead log of the database")
+	backupCmd.Flags().StringVar(&keyPath, "key-file", "", "file holding the key of the database")
+	backupCmd.Flags().StringVar(&basePath, "base", "", "previous backup to base an incremental backup on")
+
+	return backupCmd
+}
+
+func backup(cmd *cobra.Command, name string, opts ...pager.Option) error {
+	var base *pager.Manifest
+	if basePath != "" {
+		var err error
+		if base, err = readManifest(basePath); err != nil {
+			return err
+		}
+	}
+
+	db, err := dbfile.Open(filePath, walPath, opts...)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := db.BackupFile(name, base)
+	if err != nil {
+		return err
+	}
+	if base != nil {
+		cmd.Printf("backed up version %d of %s based on %d to %s\n", m.TxID, filePath, m.BaseTxID,
+			name)
+	} else {
+		cmd.Printf("backed up version %d of %s to %s\n", m.TxID, filePath, name)
+	}
+	return nil
+}
+
+func readManifest(name string) (*pager.Manifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open base backup: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat base backup: %w", err)
+	}
+	return pager.ReadManifest(f, info.Size())
+}
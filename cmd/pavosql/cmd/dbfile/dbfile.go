@@ -0,0 +1,83 @@
+package dbfile
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gkits/pavosql/internal/db"
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+// Reads a hex encoded key from the file called name.
+func ReadKey(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key of %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// Opens the existing database stored in the file called name with opts through db.OpenWAL, which
+// would create a missing one. Fails if the database is in use by another process, since opening it
+// discards the uncommitted writes of that process (see atomic.OpenFile).
+func Open(name, walName string, opts ...pager.Option) (*db.DB, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	d, err := db.OpenWAL(name, walName, opts...)
+	if errors.Is(err, atomic.ErrLocked) {
+		return nil, fmt.Errorf("database is in use, stop the processes using it first: %w", err)
+	}
+	return d, err
+}
+
+// Writes a new database by write into a temporary file next to the file called name, which is
+// written atomically and renamed to name with the permissions perm once it is complete.
+func Replace(name string, perm fs.FileMode, write func(atomic.ReadWriterAt) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	// The lock file of the temporary file is never used again.
+	defer os.Remove(tmp.Name() + ".lock")
+	err = tmp.Chmod(perm)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set mode of temporary file: %w", err)
+	}
+
+	f, err := atomic.OpenFile(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to move database into place: %w", err)
+	}
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return fmt.Errorf("failed to open directory of database: %w", err)
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
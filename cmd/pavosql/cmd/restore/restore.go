@@ -0,0 +1,72 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/dbfile"
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/pkg/atomic"
+	"github.com/spf13/cobra"
+)
+
+var (
+	filePath string
+	keyPath  string
+)
+
+func Command() *cobra.Command {
+	var restoreCmd = &cobra.Command{
+		Use:   "restore <full-backup> [incremental-backup...]",
+		Short: "Restore a database from backups",
+		Long: `Restore a database from a full backup followed by the incremental backups based on it,
+in the order they were written. The database is written into a new file, which must not exist yet.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var opts []pager.Option
+			if keyPath != "" {
+				key, err := dbfile.ReadKey(keyPath)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, pager.Encryption(key))
+			}
+			if err := restore(filePath, args, opts...); err != nil {
+				return err
+			}
+			cmd.Printf("restored %s from %d backups\n", filePath, len(args))
+			return nil
+		},
+	}
+
+	restoreCmd.Flags().StringVarP(&filePath, "file", "f", "/var/lib/pavosql/pavosql.db", "")
+	restoreCmd.Flags().StringVar(&keyPath, "key-file", "", "file holding the key of the database")
+
+	return restoreCmd
+}
+
+// Restores the backups called names into a temporary file next to the database called name, which
+// is renamed to name once it is durable.
+func restore(name string, names []string, opts ...pager.Option) error {
+	if _, err := os.Lstat(name); err == nil {
+		return fmt.Errorf("database %s already exists", name)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	var backups []io.Reader
+	for _, n := range names {
+		f, err := os.Open(n)
+		if err != nil {
+			return fmt.Errorf("failed to open backup: %w", err)
+		}
+		defer f.Close()
+		backups = append(backups, f)
+	}
+	return dbfile.Replace(name, 0o600, func(dst atomic.ReadWriterAt) error {
+		return pager.Restore(dst, backups, opts...)
+	})
+}
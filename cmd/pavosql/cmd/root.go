@@ -3,7 +3,11 @@ package cmd
 import (
 	"os"
 
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/backup"
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/restore"
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/rotatekey"
 	"github.com/gkits/pavosql/cmd/pavosql/cmd/serve"
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/vacuum"
 	"github.com/gkits/pavosql/cmd/pavosql/cmd/version"
 	"github.com/spf13/cobra"
 )
@@ -34,4 +38,8 @@ func init() {
 
 	rootCmd.AddCommand(version.Command())
 	rootCmd.AddCommand(serve.Command())
+	rootCmd.AddCommand(rotatekey.Command())
+	rootCmd.AddCommand(vacuum.Command())
+	rootCmd.AddCommand(backup.Command())
+	rootCmd.AddCommand(restore.Command())
 }
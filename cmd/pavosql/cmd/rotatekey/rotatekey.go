@@ -0,0 +1,71 @@
+package rotatekey
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/dbfile"
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/pkg/atomic"
+	"github.com/spf13/cobra"
+)
+
+var (
+	filePath   string
+	walPath    string
+	keyPath    string
+	newKeyPath string
+)
+
+func Command() *cobra.Command {
+	var rotateKeyCmd = &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Re-encrypt a database with a new key",
+		Long: `Re-encrypt a database with a new key by rewriting it into a new file, which replaces
+the database once it is complete. Keys are read hex encoded from files. The database must not be
+in use while its key is rotated.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := dbfile.ReadKey(keyPath)
+			if err != nil {
+				return err
+			}
+			newKey, err := dbfile.ReadKey(newKeyPath)
+			if err != nil {
+				return err
+			}
+			return rotateKey(filePath, walPath, key, newKey)
+		},
+	}
+
+	rotateKeyCmd.Flags().StringVarP(&filePath, "file", "f", "/var/lib/pavosql/pavosql.db", "")
+	rotateKeyCmd.Flags().StringVar(&walPath, "wal", "", "write-ahead log of the database")
+	rotateKeyCmd.Flags().StringVar(&keyPath, "key-file", "", "file holding the current key")
+	rotateKeyCmd.Flags().StringVar(&newKeyPath, "new-key-file", "", "file holding the new key")
+	_ = rotateKeyCmd.MarkFlagRequired("key-file")
+	_ = rotateKeyCmd.MarkFlagRequired("new-key-file")
+
+	return rotateKeyCmd
+}
+
+// Rewrites the database at name encrypted with key into a temporary file next to it, which is
+// encrypted with newKey and renamed over the database once it is durable. The log is checkpointed
+// first, so that the rewritten database doesn't depend on it.
+func rotateKey(name, walName string, key, newKey []byte) error {
+	db, err := dbfile.Open(name, walName, pager.Encryption(key))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Checkpoint(); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+	return dbfile.Replace(name, info.Mode().Perm(), func(dst atomic.ReadWriterAt) error {
+		return db.Rewrite(dst, pager.Encryption(newKey))
+	})
+}
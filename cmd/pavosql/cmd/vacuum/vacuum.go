@@ -0,0 +1,70 @@
+package vacuum
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gkits/pavosql/cmd/pavosql/cmd/dbfile"
+	"github.com/gkits/pavosql/internal/db"
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/spf13/cobra"
+)
+
+var (
+	filePath string
+	walPath  string
+	keyPath  string
+	step     int
+)
+
+func Command() *cobra.Command {
+	var vacuumCmd = &cobra.Command{
+		Use:   "vacuum",
+		Short: "Return the free space of a database to the file system",
+		Long: `Shrink a database by relocating the pages at its end into free pages in front of them
+and truncating the file behind the last page in use. The pages are relocated in steps of their own
+transactions. The database must not be in use by another process while it is vacuumed, which is
+refused otherwise.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var opts []pager.Option
+			if keyPath != "" {
+				key, err := dbfile.ReadKey(keyPath)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, pager.Encryption(key))
+			}
+			return vacuum(cmd, filePath, walPath, opts...)
+		},
+	}
+
+	vacuumCmd.Flags().StringVarP(&filePath, "file", "f", "/var/lib/pavosql/pavosql.db", "")
+	vacuumCmd.Flags().StringVar(&walPath, "wal", "", "write-ahead log of the database")
+	vacuumCmd.Flags().StringVar(&keyPath, "key-file", "", "file holding the key of the database")
+	vacuumCmd.Flags().IntVar(&step, "step", db.DefaultVacuumStep, "pages relocated per transaction")
+
+	return vacuumCmd
+}
+
+func vacuum(cmd *cobra.Command, name, walName string, opts ...pager.Option) error {
+	d, err := dbfile.Open(name, walName, opts...)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	before, err := os.Stat(name)
+	if err != nil {
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+	if err := db.Vacuum(d.Pager, step); err != nil {
+		return err
+	}
+	after, err := os.Stat(name)
+	if err != nil {
+		return fmt.Errorf("failed to stat database: %w", err)
+	}
+	cmd.Printf("shrank %s from %d to %d bytes\n", name, before.Size(), after.Size())
+	return nil
+}
@@ -1,3 +1,106 @@
 package db
 
-type DB struct{}
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+// The name under which Open opens an in-memory database, which never touches the disk and is lost
+// once it is closed.
+const Memory = ":memory:"
+
+// A DB is an open database together with the storage it is kept in.
+type DB struct {
+	*pager.Pager
+	rw  atomic.ReadWriterAt
+	log atomic.ReadWriterAt
+}
+
+// Opens the database stored in the file called name with opts, which is created if it doesn't
+// exist. If name is Memory, a new in-memory database is opened instead. Databases memory-mapped by
+// pager.MMap are updated through shadow pages, whose target is the file mapped (see
+// atomic.ShadowPages).
+//
+// Returns an error wrapping pager.ErrMMapUnsupported if opts contain pager.MMap for an in-memory
+// database, which has no file to be mapped.
+func Open(name string, opts ...pager.Option) (*DB, error) {
+	return OpenWAL(name, "", opts...)
+}
+
+// Opens the database stored in the file called name like Open. If walName is not empty, the
+// database is opened in WAL mode with its write-ahead log stored in the file called walName, which
+// is created if it doesn't exist as well. In-memory databases have no write-ahead log.
+func OpenWAL(name, walName string, opts ...pager.Option) (*DB, error) {
+	if name == Memory && walName != "" {
+		return nil, errors.New("db: in-memory databases have no write-ahead log")
+	}
+
+	db := &DB{}
+	var created []string
+	fail := func(err error) (*DB, error) {
+		db.closeFiles()
+		for _, name := range created {
+			os.Remove(name)
+		}
+		if errors.Is(err, pager.ErrMMapUnsupported) {
+			err = fmt.Errorf("db: in-memory databases can't be memory-mapped: %w", err)
+		}
+		return nil, err
+	}
+	openFile := func(name string, opts ...atomic.Option) (atomic.ReadWriterAt, error) {
+		if _, err := os.Stat(name); errors.Is(err, fs.ErrNotExist) {
+			created = append(created, name)
+		}
+		f, err := atomic.OpenFile(name, 0o600, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	// Clones replace the file they were opened for by every commit, which leaves nothing to map.
+	var fileOpts []atomic.Option
+	if pager.Mapped(opts...) {
+		fileOpts = append(fileOpts, atomic.ShadowPages())
+	}
+
+	var err error
+	if name == Memory {
+		db.rw = atomic.NewMemFile()
+	} else if db.rw, err = openFile(name, fileOpts...); err != nil {
+		return fail(err)
+	}
+	if walName != "" {
+		if db.log, err = openFile(walName); err != nil {
+			return fail(err)
+		}
+		opts = append(opts, pager.WAL(db.log))
+	}
+
+	if db.Pager, err = pager.Open(db.rw, opts...); err != nil {
+		return fail(err)
+	}
+	return db, nil
+}
+
+// Closes the Pager and the storage of db. The contents of an in-memory database are lost.
+func (db *DB) Close() error {
+	return errors.Join(db.Pager.Close(), db.closeFiles())
+}
+
+func (db *DB) closeFiles() error {
+	return errors.Join(closeRW(db.rw), closeRW(db.log))
+}
+
+func closeRW(rw atomic.ReadWriterAt) error {
+	if c, ok := rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package db_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/db"
+	"github.com/gkits/pavosql/internal/pager"
+)
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		dbName  string
+		durable bool
+	}{
+		{"file", filepath.Join(t.TempDir(), "test.db"), true},
+		{"memory", db.Memory, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := db.Open(tt.dbName)
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			testCreate(t, d.Pager, 500, "a", "b")
+			r, _ := d.NewReader()
+			testCheck(t, r, 500, "a", "b")
+			r.Close()
+			if err := d.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+
+			// In-memory databases start empty every time they are opened.
+			if d, err = db.Open(tt.dbName); err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			defer d.Close()
+			r, _ = d.NewReader()
+			defer r.Close()
+			if tt.durable {
+				testCheck(t, r, 500, "a", "b")
+			} else if r.Root() != 0 {
+				t.Fatalf("want empty database, got root %d", r.Root())
+			}
+		})
+	}
+}
+
+func TestOpen_mmap(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	d, err := db.Open(name, pager.MMap(), pager.CacheSize(0))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCreate(t, d.Pager, 2000, "a", "b", "c", "d")
+	r, _ := d.NewReader()
+	testCheck(t, r, 2000, "a", "b", "c", "d")
+	r.Close()
+
+	// Reads continue from a new mapping once vacuum truncated the file.
+	testDrop(t, d.Pager, "a", "c")
+	before, _ := os.Stat(name)
+	if err := db.Vacuum(d.Pager, 64); err != nil {
+		t.Fatalf("Vacuum() failed: %v", err)
+	}
+	if after, _ := os.Stat(name); after.Size() >= before.Size() {
+		t.Fatalf("want database smaller than %d bytes, got %d", before.Size(), after.Size())
+	}
+	testCreate(t, d.Pager, 2000, "e")
+	r, _ = d.NewReader()
+	testCheck(t, r, 2000, "b", "d", "e")
+	r.Close()
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	if d, err = db.Open(name, pager.MMap(), pager.CacheSize(0)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer d.Close()
+	r, _ = d.NewReader()
+	defer r.Close()
+	testCheck(t, r, 2000, "b", "d", "e")
+}
+
+func TestOpen_mmapMemory(t *testing.T) {
+	if _, err := db.Open(db.Memory, pager.MMap()); !errors.Is(err, pager.ErrMMapUnsupported) {
+		t.Fatalf("want ErrMMapUnsupported for in-memory database, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gkits/pavosql/pkg/ast"
+)
+
+var ErrUnsupportedStmt = errors.New("db: statement is not supported")
+
+// Executes the statement stmt on db. VACUUM shrinks the database by Vacuum in steps of
+// DefaultVacuumStep pages.
+//
+// Returns ErrUnsupportedStmt for all statements that can't be executed yet.
+func (db *DB) Exec(stmt ast.Stmnt) error {
+	switch stmt.(type) {
+	case ast.VacuumStmt:
+		return Vacuum(db.Pager, DefaultVacuumStep)
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedStmt, stmt)
+	}
+}
@@ -0,0 +1,46 @@
+package db_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/db"
+	"github.com/gkits/pavosql/pkg/ast"
+	"github.com/gkits/pavosql/pkg/parse"
+)
+
+func TestDB_Exec(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	d, err := db.Open(name)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer d.Close()
+	testCreate(t, d.Pager, 2000, "a", "b", "c", "d")
+	testDrop(t, d.Pager, "a", "c")
+	before, _ := os.Stat(name)
+
+	stmts, err := parse.Parse(strings.NewReader("VACUUM;"))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	for _, stmt := range stmts {
+		if err := d.Exec(stmt); err != nil {
+			t.Fatalf("Exec(%T) failed: %v", stmt, err)
+		}
+	}
+	after, _ := os.Stat(name)
+	if after.Size() > before.Size()*6/10 {
+		t.Fatalf("want database of at most %d bytes, got %d", before.Size()*6/10, after.Size())
+	}
+	r, _ := d.NewReader()
+	defer r.Close()
+	testCheck(t, r, 2000, "b", "d")
+
+	if err := d.Exec(ast.SelectStmt{}); !errors.Is(err, db.ErrUnsupportedStmt) {
+		t.Fatalf("want ErrUnsupportedStmt, got %v", err)
+	}
+}
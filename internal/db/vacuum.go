@@ -0,0 +1,54 @@
+package db
+
+import (
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// The number of pages relocated by a single step of Vacuum.
+const DefaultVacuumStep = 1024
+
+// Runs a single step of a vacuum of the database managed by p, whose root page is the directory of
+// its catalog. Up to about n pages stored behind the vacuum limit are relocated in front of it and
+// the database is shrunk by the free pages at its end. Returns the number of pages relocated, which
+// is 0 once there is nothing left to relocate.
+func VacuumStep(p *pager.Pager, n int) (int, error) {
+	w, err := p.NewWriter()
+	if err != nil {
+		return 0, err
+	}
+
+	c := tree.OpenCatalog(w, w.Root())
+	moved, err := c.Relocate(w.VacuumLimit(), n)
+	if err != nil {
+		_ = w.Abort()
+		return 0, err
+	}
+	w.SetRoot(c.Root())
+	w.Shrink()
+	if err := w.Commit(); err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
+
+// Shrinks the database managed by p as far as possible by running steps of up to n relocated pages
+// until there is nothing left to relocate. Since every step is a transaction of its own, Readers
+// and Writers continue between steps. In WAL mode, the database is checkpointed in the end, which
+// truncates the file.
+func Vacuum(p *pager.Pager, n int) error {
+	for {
+		moved, err := VacuumStep(p, n)
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			break
+		}
+	}
+	// The pages freed by the last step are trimmed by another one.
+	if _, err := VacuumStep(p, n); err != nil {
+		return err
+	}
+	return p.Checkpoint()
+}
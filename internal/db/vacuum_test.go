@@ -0,0 +1,177 @@
+package db_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/db"
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+type memFile struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := int(off) + len(b); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	return copy(f.data[off:], b), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = f.data[:min(size, int64(len(f.data)))]
+	return nil
+}
+
+func (f *memFile) Commit() error { return nil }
+
+func (f *memFile) Abort() error { return nil }
+
+func (f *memFile) size() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.data)
+}
+
+func testKey(i int) []byte { return fmt.Appendf(nil, "key%06d", i) }
+
+func testVal(name string, i int) []byte {
+	return bytes.Repeat([]byte(name), 1+i%100)
+}
+
+// Creates the trees called names holding n keys each.
+func testCreate(t *testing.T, p *pager.Pager, n int, names ...string) {
+	t.Helper()
+	w, _ := p.NewWriter()
+	c := tree.OpenCatalog(w, w.Root())
+	for _, name := range names {
+		tr, err := c.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%q) failed: %v", name, err)
+		}
+		for i := range n {
+			if err := tr.Set(testKey(i), testVal(name, i)); err != nil {
+				t.Fatalf("Set(%q) failed: %v", testKey(i), err)
+			}
+		}
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	w.SetRoot(c.Root())
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+}
+
+func testDrop(t *testing.T, p *pager.Pager, names ...string) {
+	t.Helper()
+	w, _ := p.NewWriter()
+	c := tree.OpenCatalog(w, w.Root())
+	for _, name := range names {
+		if err := c.Drop(name); err != nil {
+			t.Fatalf("Drop(%q) failed: %v", name, err)
+		}
+	}
+	w.SetRoot(c.Root())
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+}
+
+// Checks that the trees called names hold n keys each.
+func testCheck(t *testing.T, r *pager.Reader, n int, names ...string) {
+	t.Helper()
+	c := tree.OpenCatalog(r, r.Root())
+	for _, name := range names {
+		tr, err := c.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%q) failed: %v", name, err)
+		}
+		if rep := tr.Check(); !rep.OK() || rep.Keys != n {
+			t.Fatalf("want %d keys in %q without violations, got %s", n, name, rep)
+		}
+		for i := range n {
+			if v, err := tr.Get(testKey(i)); err != nil || !bytes.Equal(v, testVal(name, i)) {
+				t.Fatalf("Get(%q) from %q = %q, %v", testKey(i), name, v, err)
+			}
+		}
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCreate(t, p, 2000, "a", "b", "c", "d")
+	size := f.size()
+
+	// Readers continue reading their version while the database is vacuumed.
+	r, _ := p.NewReader()
+	testDrop(t, p, "a", "c")
+	if err := db.Vacuum(p, 16); err != nil {
+		t.Fatalf("Vacuum() failed: %v", err)
+	}
+	testCheck(t, r, 2000, "a", "b", "c", "d")
+	r.Close()
+
+	if err := db.Vacuum(p, 16); err != nil {
+		t.Fatalf("Vacuum() failed: %v", err)
+	}
+	if f.size() > size*6/10 {
+		t.Fatalf("want database of at most %d bytes, got %d", size*6/10, f.size())
+	}
+
+	if p, err = pager.Open(f); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	r, _ = p.NewReader()
+	defer r.Close()
+	testCheck(t, r, 2000, "b", "d")
+}
+
+func TestVacuumStep(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCreate(t, p, 2000, "a", "b")
+	testDrop(t, p, "a")
+
+	moved, err := db.VacuumStep(p, 10)
+	if err != nil {
+		t.Fatalf("VacuumStep() failed: %v", err)
+	}
+	if moved == 0 || moved > 20 {
+		t.Fatalf("want about 10 pages relocated, got %d", moved)
+	}
+	r, _ := p.NewReader()
+	defer r.Close()
+	testCheck(t, r, 2000, "b")
+}
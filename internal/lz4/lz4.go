@@ -0,0 +1,156 @@
+/*
+Package lz4 implements the LZ4 block format, which compresses data by replacing repeated sequences
+of bytes with references to previous occurrences.
+
+A block is a series of sequences, each of them consisting of literals copied as is followed by a
+match copied from the already decompressed data:
+
+	Description | Token | LitLen | Literals | Offset | MatchLen
+	------------+-------+--------+----------+--------+---------
+	Size in B   | 1     | n      | LitLen   | 2      | n
+
+The upper four bits of Token hold the number of literals and the lower four bits the length of the
+match minus minMatch. A value of 15 is continued by LitLen and MatchLen respectively, which are
+sequences of bytes added to it up to and including the first byte that is not 255. Offset is the
+distance of the match from the current position. The last sequence only consists of literals.
+*/
+package lz4
+
+import "errors"
+
+var ErrCorrupt = errors.New("lz4: corrupt block")
+
+const (
+	minMatch = 4
+	// The last match has to start this many bytes before the end of the input, and the last
+	// lastLiterals bytes are always literals.
+	mfLimit      = 12
+	lastLiterals = 5
+
+	maxOffset = 1<<16 - 1
+	hashLog   = 12
+)
+
+// Returns the maximum size of the compressed form of n bytes.
+func CompressBound(n int) int {
+	return n + n/255 + 16
+}
+
+// Appends the compressed form of src to dst and returns the resulting slice.
+func Compress(dst, src []byte) []byte {
+	var table [1 << hashLog]int32
+	anchor, i := 0, 0
+	for limit := len(src) - mfLimit; i < limit; {
+		h := hash(load32(src, i))
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1) // #nosec G115 // blocks are far smaller than 2 GiB
+		if ref < 0 || i-ref > maxOffset || load32(src, ref) != load32(src, i) {
+			i++
+			continue
+		}
+
+		// Extend the match backwards into the pending literals and forwards up to the literals
+		// at the end.
+		for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+			i--
+			ref--
+		}
+		n := minMatch
+		for end := len(src) - lastLiterals; i+n < end && src[i+n] == src[ref+n]; n++ {
+		}
+
+		dst = appendSequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// Appends a sequence of literals followed by a match at offset of length n or no match if n is 0.
+func appendSequence(dst, literals []byte, offset, n int) []byte {
+	token := byte(min(len(literals), 15)) << 4
+	if n > 0 {
+		token |= byte(min(n-minMatch, 15))
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = appendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if n == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if n-minMatch >= 15 {
+		dst = appendLength(dst, n-minMatch-15)
+	}
+	return dst
+}
+
+func appendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// Decompresses src into dst and returns the number of decompressed bytes. Returns ErrCorrupt if src
+// is not a valid block or decompresses to more than len(dst) bytes.
+func Decompress(dst, src []byte) (int, error) {
+	d, s := 0, 0
+	for s < len(src) {
+		token := src[s]
+		s++
+
+		n, ok := readLength(src, &s, int(token>>4))
+		if !ok || n > len(src)-s || n > len(dst)-d {
+			return 0, ErrCorrupt
+		}
+		d += copy(dst[d:], src[s:s+n])
+		s += n
+		if s == len(src) {
+			return d, nil
+		}
+
+		if len(src)-s < 2 {
+			return 0, ErrCorrupt
+		}
+		offset := int(src[s]) | int(src[s+1])<<8
+		s += 2
+		n, ok = readLength(src, &s, int(token&15))
+		n += minMatch
+		if !ok || offset == 0 || offset > d || n > len(dst)-d {
+			return 0, ErrCorrupt
+		}
+		// Matches may overlap the bytes they produce, so they are copied byte by byte.
+		for i := range n {
+			dst[d+i] = dst[d-offset+i]
+		}
+		d += n
+	}
+	return 0, ErrCorrupt
+}
+
+// Returns the length n of a token continued by the bytes at *s, which is advanced behind them.
+func readLength(src []byte, s *int, n int) (int, bool) {
+	if n != 15 {
+		return n, true
+	}
+	for *s < len(src) {
+		b := src[*s]
+		*s++
+		n += int(b)
+		if b != 255 {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func load32(b []byte, i int) uint32 {
+	return uint32(b[i]) | uint32(b[i+1])<<8 | uint32(b[i+2])<<16 | uint32(b[i+3])<<24
+}
+
+func hash(v uint32) uint32 {
+	return v * 2654435761 >> (32 - hashLog)
+}
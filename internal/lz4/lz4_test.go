@@ -0,0 +1,75 @@
+package lz4_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/lz4"
+)
+
+func TestCompress(t *testing.T) {
+	var text []byte
+	for i := range 300 {
+		text = fmt.Appendf(text, "key%06d=value%06d;", i, i%7)
+	}
+	random := make([]byte, 8192)
+	for i := range random {
+		random[i] = byte(rand.N(256))
+	}
+
+	tests := []struct {
+		name string
+		src  []byte
+		// The maximum size of the compressed data.
+		want int
+	}{
+		{"empty", nil, 1},
+		{"short", []byte("abc"), 4},
+		{"run", bytes.Repeat([]byte{'a'}, 8192), 64},
+		{"long literals", random[:300], 303},
+		{"text", text, len(text) / 3},
+		{"random", random, lz4.CompressBound(len(random))},
+		{"zeros", make([]byte, 8192), 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := lz4.Compress(nil, tt.src)
+			if len(c) > tt.want || len(c) > lz4.CompressBound(len(tt.src)) {
+				t.Fatalf("want at most %d bytes, got %d", tt.want, len(c))
+			}
+			dst := make([]byte, len(tt.src))
+			n, err := lz4.Decompress(dst, c)
+			if err != nil || !bytes.Equal(dst[:n], tt.src) {
+				t.Fatalf("Decompress() = %d, %v, want input of %d bytes", n, err, len(tt.src))
+			}
+		})
+	}
+}
+
+func TestDecompress_corrupt(t *testing.T) {
+	src := bytes.Repeat([]byte("pavosql "), 100)
+	c := lz4.Compress(nil, src)
+
+	tests := []struct {
+		name string
+		src  []byte
+		dst  int
+	}{
+		{"empty", nil, len(src)},
+		{"truncated", c[:len(c)-1], len(src)},
+		{"short dst", c, len(src) - 1},
+		{"offset", []byte{0x10, 'a', 0x02, 0x00}, len(src)},
+		{"zero offset", []byte{0x10, 'a', 0x00, 0x00, 0x00}, len(src)},
+		{"length", []byte{0xf0, 0xff}, len(src)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := lz4.Decompress(make([]byte, tt.dst), tt.src); !errors.Is(err, lz4.ErrCorrupt) {
+				t.Fatalf("want ErrCorrupt, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,544 @@
+package pager
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+var (
+	ErrNotBackup     = errors.New("pager: file is not a backup")
+	ErrCorruptBackup = errors.New("pager: corrupt backup")
+	ErrBackupChain   = errors.New("pager: backup does not continue the previous backup")
+)
+
+/*
+A backup is a stream holding a copy of the pages of a single version of a database, which is written
+while Readers and Writers continue. It starts with a header describing the version:
+
+	Description | Magic | Version | Flags | KeyCheck | TxID | Base | Root | Pages
+	------------+-------+---------+-------+----------+------+------+------+------
+	Size in B   | 8     | 2       | 4     | 16       | 8    | 8    | 8    | 8
+
+Flags and KeyCheck are the ones of the database, TxID, Root and Pages the ones of the meta page of
+the version. Base is the TxID of the backup an incremental backup is based on and 0 for full backups.
+The header is followed by the pages of the version in ascending order of their offset:
+
+	Description | Offset | Page | Checksum
+	------------+--------+------+---------
+	Size in B   | 8      | ?    | 4
+
+Page is sealed like the slot of the page if the database is encrypted (see crypt.go) and Checksum is
+the CRC-32C of Offset and Page. The pages end with an Offset of 0 and are followed by the manifest:
+
+	Description | Kinds | Sums      | Checksum | N
+	------------+-------+-----------+----------+--
+	Size in B   | N     | 8 * N     | 4        | 8
+
+The manifest holds the kind and the sum of all N pages behind the reserved ones, which tells free
+pages and the pages of the free list apart from the pages in use. Sums are the first 8 bytes of the
+SHA-256 of a page in use, which is keyed as HMAC for encrypted databases. Checksum is the CRC-32C of
+Kinds and Sums.
+
+Full backups hold all pages in use. Since pages don't record the transaction that wrote them, an
+incremental backup compares the sums of all pages in use with the ones of the manifest of the backup
+it is based on and only holds the pages that changed. Its manifest still describes all pages, so
+that the next incremental backup can be based on it.
+
+Free pages and the pages of the free list are never copied, since Writers may reuse them while the
+backup is written. Restore zeroes free pages and writes a new free list instead.
+*/
+
+const (
+	backupMagic   = "pavobak\x00"
+	backupVersion = 1
+
+	bakMagicOff    = 0
+	bakVersionOff  = bakMagicOff + 8
+	bakFlagsOff    = bakVersionOff + 2
+	bakKeyCheckOff = bakFlagsOff + 4
+	bakTxIDOff     = bakKeyCheckOff + keyCheckSize
+	bakBaseOff     = bakTxIDOff + 8
+	bakRootOff     = bakBaseOff + 8
+	bakPagesOff    = bakRootOff + 8
+	bakHeaderSize  = bakPagesOff + 8
+
+	// The size of the checksum and N of the manifest.
+	bakTrailerSize = 4 + 8
+)
+
+const (
+	pageFree byte = iota
+	pageFreeList
+	pageInUse
+)
+
+// A Manifest describes the version of a database copied by a backup. It is the base of incremental
+// backups.
+type Manifest struct {
+	// The TxID of the version copied by the backup and of the version copied by the backup it is
+	// based on, which is 0 for full backups.
+	TxID, BaseTxID uint64
+
+	flags    uint32
+	keyCheck [keyCheckSize]byte
+	root     int64
+	pages    int64
+	// The kind and sum of every page behind the reserved ones.
+	kinds []byte
+	sums  []uint64
+}
+
+// Returns the offset behind the last page of the version.
+func (m *Manifest) end() int64 {
+	return m.pages * PageSize
+}
+
+// Writes a backup of the current version of the database to w and returns its manifest. If base is
+// not nil, the backup is an incremental backup based on the backup base is the manifest of, which
+// only holds the pages that changed since then.
+//
+// Backup only holds the version it copies while Readers and Writers continue. Its pages are read
+// past the page cache.
+//
+// Returns ErrBackupChain if base describes a newer version or a database with other features.
+func (p *Pager) Backup(w io.Writer, base *Manifest) (*Manifest, error) {
+	r, kinds := p.backupSnapshot()
+	defer r.Close()
+
+	m := &Manifest{
+		TxID:  r.meta.txid,
+		flags: p.flags,
+		root:  r.meta.root,
+		pages: r.meta.pages,
+		kinds: kinds,
+		sums:  make([]uint64, len(kinds)),
+	}
+	if p.cipher != nil {
+		m.keyCheck = p.cipher.keyCheck()
+	}
+	if base != nil {
+		if base.TxID > m.TxID || base.flags != m.flags || base.keyCheck != m.keyCheck {
+			return nil, fmt.Errorf("%w: base %d of version %d", ErrBackupChain, base.TxID, m.TxID)
+		}
+		m.BaseTxID = base.TxID
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(m.encodeHeader()); err != nil {
+		return nil, fmt.Errorf("pager: failed to write backup: %w", err)
+	}
+	key := p.sumKey()
+	frame := make([]byte, 8+PageSize+p.cipher.overhead()+4)
+	for i, kind := range kinds {
+		if kind != pageInUse {
+			continue
+		}
+		off := firstPage + int64(i)*PageSize
+		page, err := r.read(off)
+		if err != nil {
+			return nil, err
+		}
+		m.sums[i] = pageSum(key, &page)
+		if base != nil && i < len(base.kinds) && base.kinds[i] == pageInUse &&
+			base.sums[i] == m.sums[i] {
+			continue
+		}
+
+		n := len(frame) - 4
+		binary.LittleEndian.PutUint64(frame, uint64(off)) // #nosec G115
+		if p.cipher != nil {
+			p.cipher.seal(frame[8:n], off, page[:])
+		} else {
+			copy(frame[8:], page[:])
+		}
+		binary.LittleEndian.PutUint32(frame[n:], crc32.Checksum(frame[:n], castagnoli))
+		if _, err := bw.Write(frame); err != nil {
+			return nil, fmt.Errorf("pager: failed to write backup: %w", err)
+		}
+	}
+
+	if _, err := bw.Write(make([]byte, 8)); err != nil {
+		return nil, fmt.Errorf("pager: failed to write backup: %w", err)
+	}
+	if _, err := bw.Write(m.encodeTrailer()); err != nil {
+		return nil, fmt.Errorf("pager: failed to write backup: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("pager: failed to write backup: %w", err)
+	}
+	return m, nil
+}
+
+// Writes a backup like Backup into a temporary file next to the file called name, which replaces it
+// once the backup is durable.
+func (p *Pager) BackupFile(name string, base *Manifest) (*Manifest, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("pager: failed to create backup: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	m, err := p.Backup(tmp, base)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, fmt.Errorf("pager: failed to sync backup: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return nil, fmt.Errorf("pager: failed to replace backup: %w", err)
+	}
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return nil, fmt.Errorf("pager: failed to sync backup directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return nil, fmt.Errorf("pager: failed to sync backup directory: %w", err)
+	}
+	return m, nil
+}
+
+// Returns a Reader of the current version of the database together with the kinds of all pages of
+// the version behind the reserved ones.
+func (p *Pager) backupSnapshot() (*Reader, []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r := p.newReader()
+	kinds := make([]byte, p.meta.pages-firstPage/PageSize)
+	for i := range kinds {
+		kinds[i] = pageInUse
+	}
+	mark := func(offs []int64, kind byte) {
+		for _, off := range offs {
+			kinds[(off-firstPage)/PageSize] = kind
+		}
+	}
+	// The pages freed by committed transactions are free in the current version, even if they are
+	// still pending.
+	mark(p.free, pageFree)
+	for _, pp := range p.pending {
+		mark(pp.offs, pageFree)
+	}
+	mark(p.freeListPages, pageFreeList)
+	return r, kinds
+}
+
+// Returns the key the sums of the pages of p are keyed with, which is nil for databases without
+// encryption.
+func (p *Pager) sumKey() []byte {
+	if p.cipher == nil {
+		return nil
+	}
+	return p.key
+}
+
+func pageSum(key []byte, page *[PageSize]byte) uint64 {
+	var h hash.Hash
+	if key != nil {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(page[:])
+	return binary.LittleEndian.Uint64(h.Sum(nil))
+}
+
+func (m *Manifest) encodeHeader() []byte {
+	b := make([]byte, bakHeaderSize)
+	copy(b[bakMagicOff:], backupMagic)
+	binary.LittleEndian.PutUint16(b[bakVersionOff:], backupVersion)
+	binary.LittleEndian.PutUint32(b[bakFlagsOff:], m.flags)
+	copy(b[bakKeyCheckOff:], m.keyCheck[:])
+	binary.LittleEndian.PutUint64(b[bakTxIDOff:], m.TxID)
+	binary.LittleEndian.PutUint64(b[bakBaseOff:], m.BaseTxID)
+	binary.LittleEndian.PutUint64(b[bakRootOff:], uint64(m.root))   // #nosec G115
+	binary.LittleEndian.PutUint64(b[bakPagesOff:], uint64(m.pages)) // #nosec G115
+	return b
+}
+
+// Returns the manifest described by the backup header b without its kinds and sums.
+func decodeBackupHeader(b []byte) (*Manifest, error) {
+	if string(b[bakMagicOff:bakVersionOff]) != backupMagic {
+		return nil, ErrNotBackup
+	}
+	if v := binary.LittleEndian.Uint16(b[bakVersionOff:]); v != backupVersion {
+		return nil, fmt.Errorf("%w: backup version %d", ErrUnsupportedVersion, v)
+	}
+
+	m := &Manifest{
+		TxID:     binary.LittleEndian.Uint64(b[bakTxIDOff:]),
+		BaseTxID: binary.LittleEndian.Uint64(b[bakBaseOff:]),
+		flags:    binary.LittleEndian.Uint32(b[bakFlagsOff:]),
+		root:     int64(binary.LittleEndian.Uint64(b[bakRootOff:])),  // #nosec G115
+		pages:    int64(binary.LittleEndian.Uint64(b[bakPagesOff:])), // #nosec G115
+	}
+	copy(m.keyCheck[:], b[bakKeyCheckOff:])
+	if m.flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("%w: unknown flags %#x", ErrUnsupportedVersion, m.flags)
+	}
+	if m.pages < firstPage/PageSize || m.root < 0 || m.root >= m.end() ||
+		m.BaseTxID > m.TxID {
+		return nil, fmt.Errorf("%w: invalid header", ErrCorruptBackup)
+	}
+	return m, nil
+}
+
+func (m *Manifest) encodeTrailer() []byte {
+	n := len(m.kinds)
+	b := make([]byte, 9*n+bakTrailerSize)
+	copy(b, m.kinds)
+	for i, sum := range m.sums {
+		binary.LittleEndian.PutUint64(b[n+8*i:], sum)
+	}
+	binary.LittleEndian.PutUint32(b[9*n:], crc32.Checksum(b[:9*n], castagnoli))
+	binary.LittleEndian.PutUint64(b[9*n+4:], uint64(n)) // #nosec G115
+	return b
+}
+
+// Sets the kinds and sums of m from the manifest b.
+func (m *Manifest) decodeTrailer(b []byte) error {
+	n := len(b) - bakTrailerSize
+	if n < 0 || n%9 != 0 || binary.LittleEndian.Uint64(b[n+4:]) != uint64(n/9) ||
+		binary.LittleEndian.Uint32(b[n:]) != crc32.Checksum(b[:n], castagnoli) {
+		return fmt.Errorf("%w: invalid manifest", ErrCorruptBackup)
+	}
+	n /= 9
+	m.kinds = slices.Clone(b[:n])
+	m.sums = make([]uint64, n)
+	for i := range m.sums {
+		m.sums[i] = binary.LittleEndian.Uint64(b[n+8*i:])
+	}
+	for _, kind := range m.kinds {
+		if kind > pageInUse {
+			return fmt.Errorf("%w: invalid page kind %d", ErrCorruptBackup, kind)
+		}
+	}
+	return nil
+}
+
+// Returns the size of the manifest of m in bytes.
+func (m *Manifest) trailerSize() int64 {
+	return 9*(m.pages-firstPage/PageSize) + bakTrailerSize
+}
+
+// Reads the manifest of the backup stored in r, which is size bytes long, to base an incremental
+// backup on it.
+//
+// Returns ErrNotBackup if r does not hold a backup and ErrCorruptBackup if its manifest is invalid.
+func ReadManifest(r io.ReaderAt, size int64) (*Manifest, error) {
+	hdr := make([]byte, bakHeaderSize)
+	if _, err := r.ReadAt(hdr, 0); errors.Is(err, io.EOF) {
+		return nil, ErrNotBackup
+	} else if err != nil {
+		return nil, fmt.Errorf("pager: failed to read backup: %w", err)
+	}
+	m, err := decodeBackupHeader(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := m.trailerSize()
+	if size-n < bakHeaderSize+8 {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptBackup)
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, size-n); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("pager: failed to read backup: %w", err)
+	}
+	if err := m.decodeTrailer(b); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Restores the version of a database copied by a full backup followed by any number of incremental
+// backups, each based on the previous one, into the empty dst. The database is created with the
+// features of the backups and the ones requested by opts, like for Rewrite. Encrypted backups have
+// to be restored with the key of their database.
+//
+// Returns ErrBackupChain if the first backup is not a full backup or a backup is not based on the
+// previous one and ErrCorruptBackup if a backup is damaged or misses pages.
+func Restore(dst atomic.ReadWriterAt, backups []io.Reader, opts ...Option) error {
+	var (
+		q    *Pager
+		prev *Manifest
+		// The keys of the sums of the pages and the cipher of the backups.
+		key    []byte
+		cipher *pageCipher
+		// The sums of the pages written into dst.
+		sums = make(map[int64]uint64)
+	)
+	for _, r := range backups {
+		br := bufio.NewReader(r)
+		hdr := make([]byte, bakHeaderSize)
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			return fmt.Errorf("%w: %w", ErrNotBackup, err)
+		}
+		m, err := decodeBackupHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case prev == nil && m.BaseTxID != 0:
+			return fmt.Errorf("%w: first backup is based on %d", ErrBackupChain, m.BaseTxID)
+		case prev != nil && (m.BaseTxID != prev.TxID || m.flags != prev.flags ||
+			m.keyCheck != prev.keyCheck):
+			return fmt.Errorf("%w: backup based on %d follows %d", ErrBackupChain, m.BaseTxID,
+				prev.TxID)
+		}
+		if q == nil {
+			q = &Pager{rw: dst, features: m.flags, log: slog.Default()}
+			for _, opt := range opts {
+				opt(q)
+			}
+			if m.flags&flagEncrypted != 0 {
+				if q.key == nil {
+					return fmt.Errorf("%w: backup is encrypted", ErrInvalidKey)
+				}
+				if cipher, err = newPageCipher(q.key); err != nil {
+					return err
+				}
+				if !cipher.checkKey(m.keyCheck) {
+					return ErrInvalidKey
+				}
+				key = q.key
+			}
+			if err := q.setFlags(q.features); err != nil {
+				return err
+			}
+			if err := q.write(0, q.header()); err != nil {
+				return err
+			}
+		}
+
+		if err := q.restorePages(br, m, key, cipher, sums); err != nil {
+			return err
+		}
+		b := make([]byte, m.trailerSize())
+		if _, err := io.ReadFull(br, b); err != nil {
+			return fmt.Errorf("%w: %w", ErrCorruptBackup, err)
+		}
+		if err := m.decodeTrailer(b); err != nil {
+			return err
+		}
+		prev = m
+	}
+	if prev == nil {
+		return ErrNotBackup
+	}
+	return q.finishRestore(prev, sums)
+}
+
+// Writes the pages of the backup described by m read from r into q and records their sums keyed
+// with key. The pages are opened with cipher if the backup is encrypted.
+func (q *Pager) restorePages(r io.Reader, m *Manifest, key []byte, cipher *pageCipher,
+	sums map[int64]uint64,
+) error {
+	frame := make([]byte, 8+PageSize+cipher.overhead()+4)
+	n := len(frame) - 4
+	for {
+		if _, err := io.ReadFull(r, frame[:8]); err != nil {
+			return fmt.Errorf("%w: %w", ErrCorruptBackup, err)
+		}
+		off := int64(binary.LittleEndian.Uint64(frame)) // #nosec G115
+		if off == 0 {
+			return nil
+		}
+		if _, err := io.ReadFull(r, frame[8:]); err != nil {
+			return fmt.Errorf("%w: %w", ErrCorruptBackup, err)
+		}
+		if binary.LittleEndian.Uint32(frame[n:]) != crc32.Checksum(frame[:n], castagnoli) {
+			return fmt.Errorf("%w: checksum mismatch of page %d", ErrCorruptBackup, off)
+		}
+		if err := checkOffset("restore", off, m.end()); err != nil {
+			return fmt.Errorf("%w: %w", ErrCorruptBackup, err)
+		}
+
+		var page [PageSize]byte
+		if cipher != nil {
+			var err error
+			if page, err = cipher.open(off, frame[8:n]); err != nil {
+				return err
+			}
+		} else {
+			copy(page[:], frame[8:n])
+		}
+		sums[off] = pageSum(key, &page)
+		if err := q.write(off, page); err != nil {
+			return err
+		}
+	}
+}
+
+// Completes restoring the version described by m into q, whose pages in use have been written with
+// the sums in sums, by zeroing its free pages and writing a new free list and the meta pages.
+func (q *Pager) finishRestore(m *Manifest, sums map[int64]uint64) error {
+	var free, freeListPages []int64
+	for i, kind := range m.kinds {
+		off := firstPage + int64(i)*PageSize
+		switch kind {
+		case pageInUse:
+			if sum, ok := sums[off]; !ok || sum != m.sums[i] {
+				return fmt.Errorf("%w: page %d is missing", ErrCorruptBackup, off)
+			}
+			continue
+		case pageFree:
+			free = append(free, off)
+		case pageFreeList:
+			freeListPages = append(freeListPages, off)
+		}
+		if err := q.write(off, [PageSize]byte{}); err != nil {
+			return err
+		}
+	}
+	if len(freeListPages)*freeListCap < len(free) {
+		return fmt.Errorf("%w: free list of %d pages", ErrCorruptBackup, len(freeListPages))
+	}
+	slices.SortFunc(free, descending)
+
+	q.meta = meta{txid: m.TxID, root: m.root, pages: m.pages}
+	if len(freeListPages) > 0 {
+		q.meta.freeList = freeListPages[0]
+	}
+	for off, page := range encodeFreeList(free, freeListPages) {
+		if err := q.write(off, page); err != nil {
+			return err
+		}
+	}
+	if err := q.writeMap(m.pages); err != nil {
+		return err
+	}
+	if err := q.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to restore database: %w", err)
+	}
+
+	for _, off := range []int64{PageSize, 2 * PageSize} {
+		if err := q.write(off, q.encodeMeta(q.meta)); err != nil {
+			return err
+		}
+	}
+	if err := q.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to restore meta pages: %w", err)
+	}
+	q.pages.commit()
+	// Pages of previous backups may have been written behind the end of the version.
+	q.truncate()
+	return nil
+}
@@ -0,0 +1,221 @@
+package pager_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+)
+
+// A hookWriter calls hook before the first write.
+type hookWriter struct {
+	io.Writer
+	hook func()
+}
+
+func (w *hookWriter) Write(b []byte) (int, error) {
+	if w.hook != nil {
+		w.hook()
+		w.hook = nil
+	}
+	return w.Writer.Write(b)
+}
+
+func testBackup(t *testing.T, p *pager.Pager, base *pager.Manifest) (*bytes.Buffer, *pager.Manifest) {
+	t.Helper()
+	var buf bytes.Buffer
+	m, err := p.Backup(&buf, base)
+	if err != nil {
+		t.Fatalf("Backup() failed: %v", err)
+	}
+	return &buf, m
+}
+
+func testRestore(t *testing.T, backups []*bytes.Buffer, opts ...pager.Option) *pager.Pager {
+	t.Helper()
+	var rs []io.Reader
+	for _, b := range backups {
+		rs = append(rs, bytes.NewReader(b.Bytes()))
+	}
+	f := &memFile{}
+	if err := pager.Restore(f, rs, opts...); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	p, err := pager.Open(f, opts...)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	return p
+}
+
+func TestBackup(t *testing.T) {
+	configs := []struct {
+		name string
+		opts []pager.Option
+	}{
+		{"plain", nil},
+		{"sealed", []pager.Option{pager.Checksums(), pager.Encryption(testKey)}},
+		{"compressed", []pager.Option{pager.Compression()}},
+	}
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := pager.Open(&memFile{}, c.opts...)
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			testSetRound(t, p, 0, 2000)
+
+			// Writers continue while the backup is written, which only holds its own version.
+			var full bytes.Buffer
+			w := &hookWriter{Writer: &full, hook: func() { testSetRound(t, p, 1, 2000) }}
+			m, err := p.Backup(w, nil)
+			if err != nil {
+				t.Fatalf("Backup() failed: %v", err)
+			}
+			if m.BaseTxID != 0 {
+				t.Fatalf("want full backup, got one based on %d", m.BaseTxID)
+			}
+			if len(c.opts) > 1 && hasPlaintext(full.Bytes()) {
+				t.Fatal("want backup of encrypted database without plaintext")
+			}
+			testCheckRound(t, testRestore(t, []*bytes.Buffer{&full}, c.opts...), 0, 2000)
+
+			incr, n := testBackup(t, p, m)
+			if n.BaseTxID != m.TxID || n.TxID <= m.TxID {
+				t.Fatalf("want backup of a newer version based on %d, got %d based on %d", m.TxID,
+					n.TxID, n.BaseTxID)
+			}
+			// An incremental backup only holds the pages changed since its base.
+			if unchanged, _ := testBackup(t, p, n); unchanged.Len() >= pager.PageSize {
+				t.Fatalf("want incremental backup of unchanged database of less than a page, got %d",
+					unchanged.Len())
+			}
+			q := testRestore(t, []*bytes.Buffer{&full, incr}, c.opts...)
+			r, _ := q.NewReader()
+			if r.TxID() != n.TxID {
+				t.Fatalf("want restored version %d, got %d", n.TxID, r.TxID())
+			}
+			r.Close()
+			testCheckRound(t, q, 1, 2000)
+			testSetRound(t, q, 2, 2000)
+			testCheckRound(t, q, 2, 2000)
+		})
+	}
+}
+
+func TestBackup_chain(t *testing.T) {
+	p, _ := newTestPager(t)
+	testSetRound(t, p, 0, 1000)
+	full, m := testBackup(t, p, nil)
+	testSetRound(t, p, 1, 1000)
+	incr, n := testBackup(t, p, m)
+	testSetRound(t, p, 2, 1000)
+	last, _ := testBackup(t, p, n)
+	testCheckRound(t, testRestore(t, []*bytes.Buffer{full, incr, last}), 2, 1000)
+
+	tests := []struct {
+		name    string
+		backups []*bytes.Buffer
+	}{
+		{"incremental only", []*bytes.Buffer{incr}},
+		{"missing backup", []*bytes.Buffer{full, last}},
+		{"wrong order", []*bytes.Buffer{full, last, incr}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rs []io.Reader
+			for _, b := range tt.backups {
+				rs = append(rs, bytes.NewReader(b.Bytes()))
+			}
+			if err := pager.Restore(&memFile{}, rs); !errors.Is(err, pager.ErrBackupChain) {
+				t.Fatalf("want ErrBackupChain, got %v", err)
+			}
+		})
+	}
+
+	// An incremental backup can't be based on a newer version.
+	q, _ := newTestPager(t)
+	if _, err := q.Backup(io.Discard, n); !errors.Is(err, pager.ErrBackupChain) {
+		t.Fatalf("want ErrBackupChain, got %v", err)
+	}
+}
+
+func TestBackup_corrupt(t *testing.T) {
+	p, _ := newTestPager(t)
+	testSetRound(t, p, 0, 1000)
+	full, _ := testBackup(t, p, nil)
+
+	b := bytes.Clone(full.Bytes())
+	b[len(b)/2] ^= 0xff
+	if err := pager.Restore(&memFile{}, []io.Reader{bytes.NewReader(b)}); !errors.Is(err,
+		pager.ErrCorruptBackup) {
+		t.Fatalf("want ErrCorruptBackup, got %v", err)
+	}
+	b = full.Bytes()[:full.Len()-100]
+	if err := pager.Restore(&memFile{}, []io.Reader{bytes.NewReader(b)}); !errors.Is(err,
+		pager.ErrCorruptBackup) {
+		t.Fatalf("want ErrCorruptBackup for truncated backup, got %v", err)
+	}
+	if err := pager.Restore(&memFile{}, []io.Reader{bytes.NewReader(make([]byte, 100))}); !errors.Is(
+		err, pager.ErrNotBackup) {
+		t.Fatalf("want ErrNotBackup, got %v", err)
+	}
+}
+
+func TestBackup_encryptedKey(t *testing.T) {
+	p, err := pager.Open(&memFile{}, pager.Encryption(testKey))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 100)
+	full, _ := testBackup(t, p, nil)
+
+	for _, opts := range [][]pager.Option{nil, {pager.Encryption(otherKey)}} {
+		err := pager.Restore(&memFile{}, []io.Reader{bytes.NewReader(full.Bytes())}, opts...)
+		if !errors.Is(err, pager.ErrInvalidKey) {
+			t.Fatalf("want ErrInvalidKey, got %v", err)
+		}
+	}
+}
+
+func TestPager_BackupFile(t *testing.T) {
+	p, _ := newTestPager(t)
+	testSetRound(t, p, 0, 1000)
+
+	name := filepath.Join(t.TempDir(), "full.bak")
+	m, err := p.BackupFile(name, nil)
+	if err != nil {
+		t.Fatalf("BackupFile() failed: %v", err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	read, err := pager.ReadManifest(f, info.Size())
+	if err != nil {
+		t.Fatalf("ReadManifest() failed: %v", err)
+	}
+	if read.TxID != m.TxID {
+		t.Fatalf("want manifest of version %d, got %d", m.TxID, read.TxID)
+	}
+
+	// The manifest read from the file is the base of incremental backups like the returned one.
+	testSetRound(t, p, 1, 1000)
+	incr, _ := testBackup(t, p, read)
+	full, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+	testCheckRound(t, testRestore(t, []*bytes.Buffer{bytes.NewBuffer(full), incr}), 1, 1000)
+
+	entries, _ := os.ReadDir(filepath.Dir(name))
+	if len(entries) != 1 {
+		t.Fatalf("want only the backup in its directory, got %d files", len(entries))
+	}
+}
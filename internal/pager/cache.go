@@ -0,0 +1,202 @@
+package pager
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrCacheFull = errors.New("pager: all pages of the cache are pinned")
+
+// The default memory budget of the page cache in bytes.
+const defaultCacheSize = 8 << 20
+
+/*
+A cache is the page cache shared by all Readers and Writers of a Pager. It holds up to a fixed
+number of pages, which are evicted using the CLOCK algorithm: Every cached page has a reference bit,
+which is set whenever the page is read. When a page has to be evicted, the hand of the clock sweeps
+over the pages and evicts the first one without reference bit, clearing the bits of the pages it
+passes. Pinned pages are skipped by the hand and never evicted.
+
+Pages are cached by their offset only. This is sufficient for all snapshots, since a page reachable
+by an open Reader is never written again (see Pager). Committed pages are invalidated by the commit
+writing them. A page invalidated while it is pinned is detached from its slot and stays with whoever
+pinned it, which is why pages are unpinned by their address rather than their offset.
+*/
+type cache struct {
+	mu    sync.Mutex
+	slots []cacheSlot
+	index map[int64]int
+	size  int
+	hand  int
+	// The remaining pins of the pages detached by invalidate.
+	detached map[*[PageSize]byte]int
+
+	hits, misses, evictions uint64
+}
+
+type cacheSlot struct {
+	// The offset of the cached page or 0 if the slot is empty.
+	off  int64
+	page *[PageSize]byte
+	pins int
+	ref  bool
+}
+
+// CacheStats holds the counters of the page cache of a Pager.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// The number of cached and pinned pages.
+	Pages  int
+	Pinned int
+}
+
+// Returns a cache of up to size pages. A size of 0 disables caching.
+func newCache(size int) *cache {
+	return &cache{index: make(map[int64]int), size: size, detached: make(map[*[PageSize]byte]int)}
+}
+
+// Returns a copy of the page at off, which is loaded through load if it isn't cached.
+func (c *cache) read(off int64, load readFn) ([PageSize]byte, error) {
+	page, err := c.pin(off, load)
+	if errors.Is(err, ErrCacheFull) {
+		return *page, nil
+	} else if err != nil {
+		return [PageSize]byte{}, err
+	}
+	defer c.unpin(off, page)
+	return *page, nil
+}
+
+// Returns the cached page at off, which is loaded through load if it isn't cached, and pins it.
+//
+// Returns ErrCacheFull together with the loaded page, which isn't cached, if all pages of c are
+// pinned.
+func (c *cache) pin(off int64, load readFn) (*[PageSize]byte, error) {
+	c.mu.Lock()
+	if page, ok := c.get(off); ok {
+		c.hits++
+		c.mu.Unlock()
+		return page, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// The page is loaded without holding c.mu, which may result in concurrent loads of the same
+	// page, of which only the first one is cached.
+	page, err := load(off)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.get(off); ok {
+		return cached, nil
+	}
+	i, ok := c.victim()
+	if !ok {
+		return &page, ErrCacheFull
+	}
+	s := &c.slots[i]
+	s.off, s.pins, s.ref = off, 1, true
+	*s.page = page
+	c.index[off] = i
+	return s.page, nil
+}
+
+// Pins the cached page at off and returns it. c.mu has to be held.
+func (c *cache) get(off int64) (*[PageSize]byte, bool) {
+	i, ok := c.index[off]
+	if !ok {
+		return nil, false
+	}
+	s := &c.slots[i]
+	s.pins++
+	s.ref = true
+	return s.page, true
+}
+
+// Returns the index of an empty slot, evicting a page if c is full. c.mu has to be held.
+func (c *cache) victim() (int, bool) {
+	if len(c.slots) < c.size {
+		c.slots = append(c.slots, cacheSlot{page: new([PageSize]byte)})
+		return len(c.slots) - 1, true
+	}
+
+	// After a full sweep, all reference bits of unpinned pages are cleared.
+	for range 2 * len(c.slots) {
+		i := c.hand
+		c.hand = (c.hand + 1) % len(c.slots)
+
+		s := &c.slots[i]
+		switch {
+		case s.pins > 0:
+			continue
+		case s.ref:
+			s.ref = false
+			continue
+		}
+		if s.off != 0 {
+			delete(c.index, s.off)
+			c.evictions++
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+// Unpins the page at off, which was returned by pin.
+func (c *cache) unpin(off int64, page *[PageSize]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if i, ok := c.index[off]; ok && c.slots[i].page == page {
+		if c.slots[i].pins > 0 {
+			c.slots[i].pins--
+		}
+		return
+	}
+	// The page has been invalidated since it was pinned.
+	if n, ok := c.detached[page]; ok {
+		if n > 1 {
+			c.detached[page] = n - 1
+		} else {
+			delete(c.detached, page)
+		}
+	}
+}
+
+// Removes the pages at offs from c.
+func (c *cache) invalidate(offs []int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, off := range offs {
+		if i, ok := c.index[off]; ok {
+			delete(c.index, off)
+			// The page of a pinned slot stays with whoever pinned it together with its pins.
+			page := c.slots[i].page
+			if pins := c.slots[i].pins; pins > 0 {
+				c.detached[page] = pins
+				page = new([PageSize]byte)
+			}
+			c.slots[i] = cacheSlot{page: page}
+		}
+	}
+}
+
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{Hits: c.hits, Misses: c.misses, Evictions: c.evictions, Pages: len(c.index)}
+	for _, s := range c.slots {
+		if s.pins > 0 {
+			stats.Pinned++
+		}
+	}
+	return stats
+}
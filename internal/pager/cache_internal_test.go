@@ -0,0 +1,45 @@
+package pager
+
+import (
+	"errors"
+	"testing"
+)
+
+func testLoad(b byte) readFn {
+	return func(int64) ([PageSize]byte, error) {
+		var page [PageSize]byte
+		page[0] = b
+		return page, nil
+	}
+}
+
+// A page invalidated while it is pinned keeps its pins, which must not be taken from the page cached
+// at the same offset afterwards.
+func TestCache_invalidatePinned(t *testing.T) {
+	c := newCache(1)
+	old, err := c.pin(PageSize, testLoad('a'))
+	if err != nil {
+		t.Fatalf("pin() failed: %v", err)
+	}
+	c.invalidate([]int64{PageSize})
+
+	cur, err := c.pin(PageSize, testLoad('b'))
+	if err != nil {
+		t.Fatalf("pin() failed: %v", err)
+	}
+	if old[0] != 'a' || cur[0] != 'b' {
+		t.Fatalf("want detached page a and cached page b, got %q and %q", old[0], cur[0])
+	}
+
+	c.unpin(PageSize, old)
+	if stats := c.stats(); stats.Pinned != 1 {
+		t.Fatalf("want cached page still pinned, got %+v", stats)
+	}
+	if _, err := c.pin(2*PageSize, testLoad('c')); !errors.Is(err, ErrCacheFull) {
+		t.Fatalf("want pinned page not to be evicted, got %v", err)
+	}
+	c.unpin(PageSize, cur)
+	if stats := c.stats(); stats.Pinned != 0 || len(c.detached) != 0 {
+		t.Fatalf("want no pinned pages, got %+v and %d detached", stats, len(c.detached))
+	}
+}
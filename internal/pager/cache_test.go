@@ -0,0 +1,185 @@
+package pager_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// Returns a Pager with a cache of size pages holding n committed pages and their offsets.
+func newTestCachePager(t *testing.T, size, n int) (*pager.Pager, []int64) {
+	t.Helper()
+	p, err := pager.Open(&memFile{}, pager.CacheSize(size*pager.PageSize))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	return p, testAllocPages(t, p, n)
+}
+
+func testReadPages(t *testing.T, r *pager.Reader, offs ...int64) {
+	t.Helper()
+	for _, off := range offs {
+		if _, err := r.ReadPage(off); err != nil {
+			t.Fatalf("ReadPage(%d) failed: %v", off, err)
+		}
+	}
+}
+
+func TestCache_clock(t *testing.T) {
+	p, offs := newTestCachePager(t, 3, 5)
+	a, b, c, d, e := offs[0], offs[1], offs[2], offs[3], offs[4]
+
+	r, _ := p.NewReader()
+	defer r.Close()
+	testReadPages(t, r, a, b, c, a)
+	if want := (pager.CacheStats{Hits: 1, Misses: 3, Pages: 3}); p.CacheStats() != want {
+		t.Fatalf("want stats %+v, got %+v", want, p.CacheStats())
+	}
+
+	// All pages are referenced, so the hand clears all bits before evicting a.
+	testReadPages(t, r, d)
+	// b gets a second chance, while c is evicted.
+	testReadPages(t, r, b, e, b, d, e)
+	want := pager.CacheStats{Hits: 5, Misses: 5, Evictions: 2, Pages: 3}
+	if p.CacheStats() != want {
+		t.Fatalf("want stats %+v, got %+v", want, p.CacheStats())
+	}
+	testReadPages(t, r, c)
+	if stats := p.CacheStats(); stats.Misses != 6 {
+		t.Fatalf("want c to be evicted, got stats %+v", stats)
+	}
+}
+
+func TestCache_shared(t *testing.T) {
+	p, offs := newTestCachePager(t, 4, 1)
+
+	for range 3 {
+		r, _ := p.NewReader()
+		testReadPages(t, r, offs[0])
+		r.Close()
+	}
+	if stats := p.CacheStats(); stats.Misses != 1 || stats.Hits != 2 {
+		t.Fatalf("want page cached across readers, got stats %+v", stats)
+	}
+}
+
+func TestCache_pin(t *testing.T) {
+	p, offs := newTestCachePager(t, 2, 3)
+
+	r, _ := p.NewReader()
+	a, err := r.Pin(offs[0])
+	if err != nil {
+		t.Fatalf("Pin() failed: %v", err)
+	}
+	if _, err := r.Pin(offs[1]); err != nil {
+		t.Fatalf("Pin() failed: %v", err)
+	}
+	if _, err := r.Pin(offs[2]); !errors.Is(err, pager.ErrCacheFull) {
+		t.Fatalf("want ErrCacheFull with all pages pinned, got %v", err)
+	}
+	if page, err := r.ReadPage(offs[2]); err != nil || page != testPage("2") {
+		t.Fatalf("want uncached read with all pages pinned, got %q, %v", page[:1], err)
+	}
+	if *a != testPage("0") {
+		t.Fatalf("want pinned page untouched, got %q", a[:1])
+	}
+	if stats := p.CacheStats(); stats.Pinned != 2 {
+		t.Fatalf("want 2 pinned pages, got %+v", stats)
+	}
+
+	r.Unpin(offs[1])
+	if c, err := r.Pin(offs[2]); err != nil || *c != testPage("2") {
+		t.Fatalf("want page to be pinned after unpinning another one, got %v", err)
+	}
+	if *a != testPage("0") {
+		t.Fatalf("want pinned page not to be evicted, got %q", a[:1])
+	}
+
+	// Closing a reader unpins all of its pages.
+	r.Close()
+	if stats := p.CacheStats(); stats.Pinned != 0 {
+		t.Fatalf("want no pinned pages after close, got %+v", stats)
+	}
+}
+
+// Writers release their pins once they are committed or aborted.
+func TestCache_pinWriter(t *testing.T) {
+	p, offs := newTestCachePager(t, 4, 2)
+	for _, commit := range []bool{true, false} {
+		w, _ := p.NewWriter()
+		for _, off := range offs {
+			if _, err := w.Pin(off); err != nil {
+				t.Fatalf("Pin() failed: %v", err)
+			}
+		}
+		if stats := p.CacheStats(); stats.Pinned != 2 {
+			t.Fatalf("want 2 pinned pages, got %+v", stats)
+		}
+		var err error
+		if commit {
+			err = w.Commit()
+		} else {
+			err = w.Abort()
+		}
+		if err != nil {
+			t.Fatalf("want Writer to be done, got %v", err)
+		}
+		if stats := p.CacheStats(); stats.Pinned != 0 {
+			t.Fatalf("want no pinned pages after commit %v, got %+v", commit, stats)
+		}
+	}
+}
+
+func TestCache_invalidate(t *testing.T) {
+	p, offs := newTestCachePager(t, 4, 1)
+	r, _ := p.NewReader()
+	testReadPages(t, r, offs[0])
+	r.Close()
+
+	testFreePages(t, p, offs)
+	w, _ := p.NewWriter()
+	if off, _ := w.Alloc(testPage("new")); off != offs[0] {
+		t.Fatalf("want freed page %d to be reused, got %d", offs[0], off)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	r, _ = p.NewReader()
+	defer r.Close()
+	if page, _ := r.ReadPage(offs[0]); page != testPage("new") {
+		t.Fatalf("want committed page to replace the cached one, got %q", page[:3])
+	}
+}
+
+func TestCache_bounded(t *testing.T) {
+	p, err := pager.Open(&memFile{}, pager.CacheSize(16*pager.PageSize))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	w, _ := p.NewWriter()
+	tr := tree.New(w, w.Root())
+	for i := range 20000 {
+		k := fmt.Appendf(nil, "key%06d", i)
+		if err := tr.Set(k, k); err != nil {
+			t.Fatalf("Set(%q) failed: %v", k, err)
+		}
+	}
+	w.SetRoot(tr.Root())
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	r, _ := p.NewReader()
+	defer r.Close()
+	rep := tree.New(r, r.Root()).Check()
+	if !rep.OK() || rep.Pages <= 16 {
+		t.Fatalf("want more than 16 pages without violations, got %s", rep)
+	}
+	if stats := p.CacheStats(); stats.Pages > 16 || stats.Evictions == 0 {
+		t.Fatalf("want at most 16 cached pages, got %+v", stats)
+	}
+}
@@ -0,0 +1,526 @@
+package pager
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/gkits/pavosql/internal/lz4"
+)
+
+var (
+	ErrCorruptPage    = errors.New("pager: corrupt compressed page")
+	ErrCorruptPageMap = errors.New("pager: corrupt page map")
+)
+
+/*
+Compressed databases store every page behind the meta pages in a record of variable size instead of
+a slot:
+
+	Description | Length | Data   | Seal | Checksum
+	------------+--------+--------+------+---------
+	Size in B   | 2      | Length | 28   | 4
+
+Data is the page compressed in the LZ4 block format (see internal/lz4) or the page itself if it
+doesn't compress, in which case Length is PageSize. Like in a slot, Seal and Checksum are only
+present if the database is encrypted or has the checksums flag set. Data is sealed as a whole and
+Checksum covers the offset of the page followed by all other fields of the record.
+
+Records start at multiples of recordUnit behind the slots of the header and the meta pages. Where
+the record of a page is stored is recorded by an extent, which holds the position of the record in
+units in its upper 48 bits and its size in bytes in its lower 16 bits. The extent 0 is no record.
+
+The extents of all pages are stored in the page map, a tree of map nodes, which are stored in records
+themselves:
+
+	Description | Level | N | Extents
+	------------+-------+---+--------
+	Size in B   | 2     | 2 | N * 8
+
+The node with index i of level 0 holds the extents of the pages with the page numbers from i *
+mapNodeCap on, while the nodes of every following level hold the extents of the nodes of the
+previous one. The depth of the tree follows from the number of pages of the database and its root
+is the only node of the highest level, whose extent is stored in the Map field of the meta page.
+Nodes are identified by negative offsets instead of the offset of a page, which are authenticated
+with all of their 64 bits when a node is sealed, so that a node can't be mistaken for a page or a
+node of another level. Nonces don't depend on offsets at all (see crypt.go).
+
+Like pages, records are never overwritten while they are part of a committed version of the
+database. Writing a page stores it in a new record and rewrites all nodes on the path from it to the
+root, while the replaced records are only reused once the meta page of the new version is durable.
+Everything not covered by a record reachable from the root is free space, which is why it isn't
+stored but derived from the page map on open.
+*/
+
+const (
+	// The alignment and granularity of the space occupied by records.
+	recordUnit = 256
+
+	recordHeaderSize = 2
+
+	mapLevelOff  = 0
+	mapNOff      = mapLevelOff + 2
+	mapDataOff   = mapNOff + 2
+	mapNodeCap   = (PageSize - mapDataOff) / 8
+	maxMapLevels = 8
+)
+
+// Stores the pages of a new database compressed in records of variable size, which is worth it for
+// pages holding text or other repetitive data. Existing databases keep their features, see Rewrite
+// for compressing them.
+func Compression() Option {
+	return func(p *Pager) { p.features |= flagCompressed }
+}
+
+// An extent is the location of a record.
+type extent uint64
+
+func newExtent(pos int64, size int) extent {
+	return extent(uint64(pos)<<16 | uint64(size)) // #nosec G115 // pos and size are positive
+}
+
+// Returns the position of the first unit of the record.
+func (e extent) pos() int64 {
+	return int64(e >> 16) // #nosec G115 // positions have at most 48 bits
+}
+
+// Returns the size of the record in bytes.
+func (e extent) size() int {
+	return int(e & 0xffff)
+}
+
+// Returns the number of units occupied by the record.
+func (e extent) units() int64 {
+	return (int64(e.size()) + recordUnit - 1) / recordUnit
+}
+
+func (e extent) end() int64 {
+	return e.pos() + e.units()
+}
+
+// A run is a range of n free units starting at pos.
+type run struct {
+	pos, n int64
+}
+
+// A pageMap is the page map of a compressed database together with its free space. Changes of the
+// page map are staged until they are committed or rolled back. The nil pageMap is the one of a
+// database without compression.
+type pageMap struct {
+	mu sync.RWMutex
+	// levels[0] holds the extents of the pages by their page number and levels[l+1] the extents of
+	// the nodes of level l by their index.
+	levels [][]extent
+	// The free runs of units in ascending order of their position.
+	free []run
+	// The first unit records may be stored at and the unit behind the last record.
+	start, end int64
+	// The previous extents of the entries replaced since the last commit or rollback.
+	staged map[mapEntry]extent
+}
+
+type mapEntry struct {
+	level, i int
+}
+
+func newPageMap(start int64) *pageMap {
+	start = (start + recordUnit - 1) / recordUnit
+	return &pageMap{
+		levels: make([][]extent, 1),
+		start:  start,
+		end:    start,
+		staged: make(map[mapEntry]extent),
+	}
+}
+
+// Returns the number of levels of nodes of the page map of a database with pages pages.
+func mapDepth(pages int64) int {
+	depth := 1
+	for n := int64(mapNodeCap); n < pages; n *= mapNodeCap {
+		depth++
+	}
+	return depth
+}
+
+// Returns the offset identifying the node with index i of level l.
+func nodeOff(l, i int) int64 {
+	return -(int64(l)<<32 | int64(i) + 1) * PageSize
+}
+
+// Returns the extent of the record of the page with the page number n or 0 if it has none.
+func (pm *pageMap) lookup(n int64) extent {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if n >= int64(len(pm.levels[0])) {
+		return 0
+	}
+	return pm.levels[0][n]
+}
+
+// Returns the extent of the root of the page map of a database with pages pages.
+func (pm *pageMap) root(pages int64) extent {
+	if pm == nil {
+		return 0
+	}
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	return pm.get(mapDepth(pages), 0)
+}
+
+// Returns the extent of entry i of level l or 0 if there is none. pm.mu has to be held.
+func (pm *pageMap) get(l, i int) extent {
+	if l >= len(pm.levels) || i >= len(pm.levels[l]) {
+		return 0
+	}
+	return pm.levels[l][i]
+}
+
+// Returns a copy of the entries from i to j of level l.
+func (pm *pageMap) entries(l, i, j int) []extent {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	entries := make([]extent, j-i)
+	for k := range entries {
+		entries[k] = pm.get(l, i+k)
+	}
+	return entries
+}
+
+// Allocates an extent for a record of size bytes, which replaces entry i of level l.
+func (pm *pageMap) replace(l, i, size int) extent {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	e := newExtent(pm.alloc((int64(size)+recordUnit-1)/recordUnit), size)
+	pm.set(l, i, e)
+	return e
+}
+
+// Replaces entry i of level l by e. The previous extent is kept until the change is committed,
+// unless it was staged itself. pm.mu has to be held.
+func (pm *pageMap) set(l, i int, e extent) {
+	for len(pm.levels) <= l {
+		pm.levels = append(pm.levels, nil)
+	}
+	if n := i + 1 - len(pm.levels[l]); n > 0 {
+		pm.levels[l] = append(pm.levels[l], make([]extent, n)...)
+	}
+
+	key := mapEntry{l, i}
+	if _, ok := pm.staged[key]; ok {
+		pm.release(pm.levels[l][i])
+	} else {
+		pm.staged[key] = pm.levels[l][i]
+	}
+	pm.levels[l][i] = e
+}
+
+// Clears all entries of level l from index n on.
+func (pm *pageMap) truncate(l, n int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for i := n; l < len(pm.levels) && i < len(pm.levels[l]); i++ {
+		if pm.levels[l][i] != 0 {
+			pm.set(l, i, 0)
+		}
+	}
+}
+
+// Returns the indices of the nodes of level l, of which there are n, in ascending order that have to
+// be written, since one of their entries changed or they have never been written.
+func (pm *pageMap) dirty(l, n int) []int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var nodes []int
+	for key := range pm.staged {
+		if key.level == l && key.i/mapNodeCap < n {
+			nodes = append(nodes, key.i/mapNodeCap)
+		}
+	}
+	for i := range n {
+		if pm.get(l+1, i) == 0 {
+			nodes = append(nodes, i)
+		}
+	}
+	slices.Sort(nodes)
+	return slices.Compact(nodes)
+}
+
+// Returns the number of bytes up to the end of the last record.
+func (pm *pageMap) size() int64 {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	return pm.end * recordUnit
+}
+
+// Makes the staged changes part of the page map and frees the records they replaced.
+func (pm *pageMap) commit() {
+	if pm == nil {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for key, e := range pm.staged {
+		pm.release(e)
+		delete(pm.staged, key)
+	}
+}
+
+// Restores the entries replaced by the staged changes and frees the records written since.
+func (pm *pageMap) rollback() {
+	if pm == nil {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for key, e := range pm.staged {
+		pm.release(pm.levels[key.level][key.i])
+		pm.levels[key.level][key.i] = e
+		delete(pm.staged, key)
+	}
+}
+
+// Returns the position of n free units, which are taken from the first free run large enough or
+// appended behind the last record. pm.mu has to be held.
+func (pm *pageMap) alloc(n int64) int64 {
+	for i, r := range pm.free {
+		if r.n < n {
+			continue
+		}
+		if r.n == n {
+			pm.free = slices.Delete(pm.free, i, i+1)
+		} else {
+			pm.free[i] = run{r.pos + n, r.n - n}
+		}
+		return r.pos
+	}
+	pos := pm.end
+	pm.end += n
+	return pos
+}
+
+// Frees the units of the record at e, which are merged with adjacent free runs. pm.mu has to be
+// held.
+func (pm *pageMap) release(e extent) {
+	if e == 0 {
+		return
+	}
+	r := run{e.pos(), e.units()}
+	i, _ := slices.BinarySearchFunc(pm.free, r.pos, func(r run, pos int64) int {
+		return cmp.Compare(r.pos, pos)
+	})
+	if i < len(pm.free) && r.pos+r.n == pm.free[i].pos {
+		r.n += pm.free[i].n
+		pm.free = slices.Delete(pm.free, i, i+1)
+	}
+	if i > 0 && pm.free[i-1].pos+pm.free[i-1].n == r.pos {
+		i--
+		r = run{pm.free[i].pos, pm.free[i].n + r.n}
+		pm.free = slices.Delete(pm.free, i, i+1)
+	}
+	if r.pos+r.n == pm.end {
+		pm.end = r.pos
+		return
+	}
+	pm.free = slices.Insert(pm.free, i, r)
+}
+
+// Derives the free space from the extents of all records of the page map. Returns
+// ErrCorruptPageMap if records overlap or lie in front of the first unit.
+func (pm *pageMap) init() error {
+	var extents []extent
+	for _, level := range pm.levels {
+		for _, e := range level {
+			if e != 0 {
+				extents = append(extents, e)
+			}
+		}
+	}
+	slices.Sort(extents)
+
+	pm.free, pm.end = nil, pm.start
+	for _, e := range extents {
+		if e.pos() < pm.end {
+			return fmt.Errorf("%w: record at unit %d overlaps another one", ErrCorruptPageMap,
+				e.pos())
+		}
+		if e.pos() > pm.end {
+			pm.free = append(pm.free, run{pm.end, e.pos() - pm.end})
+		}
+		pm.end = e.end()
+	}
+	return nil
+}
+
+func encodeMapNode(level int, entries []extent) [PageSize]byte {
+	var page [PageSize]byte
+	binary.LittleEndian.PutUint16(page[mapLevelOff:], uint16(level))    // #nosec G115
+	binary.LittleEndian.PutUint16(page[mapNOff:], uint16(len(entries))) // #nosec G115
+	for i, e := range entries {
+		binary.LittleEndian.PutUint64(page[mapDataOff+8*i:], uint64(e))
+	}
+	return page
+}
+
+// Returns the level and the entries of the map node stored in page and weither it is valid.
+func decodeMapNode(page [PageSize]byte) (int, []extent, bool) {
+	level := int(binary.LittleEndian.Uint16(page[mapLevelOff:]))
+	n := int(binary.LittleEndian.Uint16(page[mapNOff:]))
+	if n > mapNodeCap {
+		return 0, nil, false
+	}
+	entries := make([]extent, n)
+	for i := range entries {
+		entries[i] = extent(binary.LittleEndian.Uint64(page[mapDataOff+8*i:]))
+	}
+	return level, entries, true
+}
+
+// Reads the page map of a database with pages pages starting at root and derives the free space
+// from it.
+func (p *Pager) loadMap(root extent, pages int64) error {
+	depth := mapDepth(pages)
+	if depth > maxMapLevels {
+		return fmt.Errorf("%w: %d levels", ErrCorruptPageMap, depth)
+	}
+	// The number of entries of every level.
+	counts := []int{int(pages)}
+	for l := range depth {
+		counts = append(counts, (counts[l]+mapNodeCap-1)/mapNodeCap)
+	}
+
+	pm := p.pages
+	pm.levels = make([][]extent, depth+1)
+	pm.levels[depth] = []extent{root}
+	for l := depth - 1; l >= 0; l-- {
+		pm.levels[l] = make([]extent, 0, counts[l])
+		for i, e := range pm.levels[l+1] {
+			page, err := p.readRecord(nodeOff(l, i), e)
+			if err != nil {
+				return fmt.Errorf("%w: node %d of level %d: %w", ErrCorruptPageMap, i, l, err)
+			}
+			level, entries, ok := decodeMapNode(page)
+			if !ok || level != l || len(entries) != min(mapNodeCap, counts[l]-i*mapNodeCap) {
+				return fmt.Errorf("%w: invalid node %d of level %d", ErrCorruptPageMap, i, l)
+			}
+			pm.levels[l] = append(pm.levels[l], entries...)
+		}
+	}
+	return pm.init()
+}
+
+// Writes the nodes of the page map of a database with pages pages that changed since the last
+// commit or rollback of the map from the bottom up, so that the path from every changed page to the
+// root is written. The nodes and pages that are no longer part of the
+// database are freed.
+func (p *Pager) writeMap(pages int64) error {
+	pm := p.pages
+	if pm == nil {
+		return nil
+	}
+
+	n, depth := int(pages), mapDepth(pages)
+	pm.truncate(0, n)
+	for l := range depth {
+		nodes := (n + mapNodeCap - 1) / mapNodeCap
+		for _, i := range pm.dirty(l, nodes) {
+			entries := pm.entries(l, i*mapNodeCap, min((i+1)*mapNodeCap, n))
+			if err := p.writeRecord(l+1, i, nodeOff(l, i), encodeMapNode(l, entries)); err != nil {
+				return err
+			}
+		}
+		pm.truncate(l+1, nodes)
+		n = nodes
+	}
+	for l := depth + 1; l < len(pm.levels); l++ {
+		pm.truncate(l, 0)
+	}
+	return nil
+}
+
+// Reads the page identified by off from the record at e, verifies its checksum, decrypts and
+// decompresses it.
+func (p *Pager) readRecord(off int64, e extent) ([PageSize]byte, error) {
+	var page [PageSize]byte
+	if e == 0 {
+		return page, &PageError{Op: "read", Off: off, Err: ErrShortRead}
+	}
+	record := make([]byte, e.size())
+	if err := p.readAt(off, record, e.pos()*recordUnit); err != nil {
+		return page, err
+	}
+
+	if p.flags&flagChecksums != 0 {
+		n := len(record) - slotChecksumSize
+		if n < 0 || binary.LittleEndian.Uint32(record[n:]) != slotChecksum(off, record[:n]) {
+			return page, &PageError{Op: "read", Off: off, Err: ErrChecksumMismatch}
+		}
+		record = record[:n]
+	}
+	if len(record) < recordHeaderSize {
+		return page, &PageError{Op: "read", Off: off, Err: ErrCorruptPage}
+	}
+	n := int(binary.LittleEndian.Uint16(record))
+	data := record[recordHeaderSize:]
+	if n > PageSize || int64(len(data)) != int64(n)+p.cipher.overhead() {
+		return page, &PageError{Op: "read", Off: off, Err: ErrCorruptPage}
+	}
+	if p.cipher != nil {
+		var err error
+		if data, err = p.cipher.openData(nil, off, data); err != nil {
+			return page, err
+		}
+	}
+
+	if n == PageSize {
+		copy(page[:], data)
+		return page, nil
+	}
+	if m, err := lz4.Decompress(page[:], data); err != nil || m != PageSize {
+		return page, &PageError{Op: "read", Off: off, Err: ErrCorruptPage}
+	}
+	return page, nil
+}
+
+// Writes page, which is identified by off, compressed into a new record, which replaces entry i of
+// level l of the page map.
+func (p *Pager) writeRecord(l, i int, off int64, page [PageSize]byte) error {
+	data := lz4.Compress(make([]byte, 0, lz4.CompressBound(PageSize)), page[:])
+	if len(data) >= PageSize {
+		data = page[:]
+	}
+
+	size := recordHeaderSize + len(data) + int(p.cipher.overhead())
+	if p.flags&flagChecksums != 0 {
+		size += slotChecksumSize
+	}
+	record := make([]byte, size)
+	binary.LittleEndian.PutUint16(record, uint16(len(data))) // #nosec G115 // at most PageSize
+	sealed := record[recordHeaderSize : recordHeaderSize+len(data)+int(p.cipher.overhead())]
+	if p.cipher != nil {
+		p.cipher.seal(sealed, off, data)
+	} else {
+		copy(sealed, data)
+	}
+	if p.flags&flagChecksums != 0 {
+		n := len(record) - slotChecksumSize
+		binary.LittleEndian.PutUint32(record[n:], slotChecksum(off, record[:n]))
+	}
+
+	e := p.pages.replace(l, i, size)
+	if _, err := p.rw.WriteAt(record, e.pos()*recordUnit); err != nil {
+		return &PageError{Op: "write", Off: off, Err: err}
+	}
+	return nil
+}
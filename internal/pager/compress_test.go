@@ -0,0 +1,128 @@
+package pager_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+)
+
+func TestCompression(t *testing.T) {
+	configs := []struct {
+		name string
+		opts []pager.Option
+	}{
+		{"plain", nil},
+		{"checksums", []pager.Option{pager.Checksums()}},
+		{"sealed", []pager.Option{pager.Checksums(), pager.Encryption(testKey)}},
+	}
+	for _, c := range configs {
+		t.Run(c.name, func(t *testing.T) {
+			uncompressed := &memFile{}
+			p, err := pager.Open(uncompressed, c.opts...)
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			f := &memFile{}
+			opts := append([]pager.Option{pager.Compression()}, c.opts...)
+			q, err := pager.Open(f, opts...)
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+
+			// The records of replaced pages are reused like the pages themselves.
+			for round := range 10 {
+				testSetRound(t, p, round, 2000)
+				testSetRound(t, q, round, 2000)
+				if len(f.data) >= len(uncompressed.data)/2 {
+					t.Fatalf("want compressed database of less than %d bytes in round %d, got %d",
+						len(uncompressed.data)/2, round, len(f.data))
+				}
+			}
+			testCheckRound(t, q, 9, 2000)
+			if len(c.opts) > 1 && hasPlaintext(f.data) {
+				t.Fatal("want compressed database without plaintext")
+			}
+
+			// Compression is a feature of the database rather than of the Pager.
+			if q, err = pager.Open(f, c.opts...); err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			testCheckRound(t, q, 9, 2000)
+			testSetRound(t, q, 10, 2000)
+			if q, err = pager.Open(f, c.opts...); err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			testCheckRound(t, q, 10, 2000)
+		})
+	}
+}
+
+func TestCompression_wal(t *testing.T) {
+	f, log := &memFile{}, &memFile{}
+	opts := []pager.Option{pager.Compression(), pager.Checksums(), pager.WAL(log)}
+	p, err := pager.Open(f, opts...)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	for round := range 3 {
+		testSetRound(t, p, round, 2000)
+		if err := p.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint() failed: %v", err)
+		}
+	}
+	testSetRound(t, p, 3, 2000)
+
+	if p, err = pager.Open(f, opts...); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 3, 2000)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	if p, err = pager.Open(f, opts...); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 3, 2000)
+}
+
+func TestCompression_corruptMap(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f, pager.Compression())
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 2000)
+
+	// Every record behind the header and the meta pages is damaged.
+	for i := 3 * pager.PageSize; i < len(f.data); i++ {
+		f.data[i] ^= 0xff
+	}
+	if _, err := pager.Open(f); !errors.Is(err, pager.ErrCorruptPageMap) {
+		t.Fatalf("want ErrCorruptPageMap, got %v", err)
+	}
+}
+
+func TestPager_RewriteCompress(t *testing.T) {
+	p, f := newTestPager(t)
+	testSetRound(t, p, 0, 2000)
+
+	dst := &memFile{}
+	if err := p.Rewrite(dst, pager.Compression()); err != nil {
+		t.Fatalf("Rewrite() failed: %v", err)
+	}
+	if len(dst.data) >= len(f.data)/2 {
+		t.Fatalf("want compressed copy of less than %d bytes, got %d", len(f.data)/2,
+			len(dst.data))
+	}
+	q, err := pager.Open(dst)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, q, 0, 2000)
+	testSetRound(t, q, 1, 2000)
+	if q, err = pager.Open(dst); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, q, 1, 2000)
+}
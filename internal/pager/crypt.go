@@ -0,0 +1,130 @@
+package pager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidKey     = errors.New("pager: invalid encryption key")
+	ErrNotEncrypted   = errors.New("pager: database is not encrypted")
+	ErrAuthentication = errors.New("pager: page failed authentication")
+)
+
+/*
+Encrypted databases encrypt every page except the header with AES-GCM. The sealed page is followed
+by the authentication tag and the nonce it was sealed with:
+
+	Description | Page     | Tag | Nonce
+	------------+----------+-----+------
+	Size in B   | PageSize | 16  | 12
+
+The nonce is chosen at random for every seal. Nonces derived from the page and the transaction
+writing it would repeat once the TxID of a transaction that failed to commit, for example due to a
+crash, is used again by the next one, which breaks both the confidentiality and the authenticity of
+AES-GCM. With 96 random bits, a nonce only repeats with negligible probability as long as less than
+2^32 pages are sealed with a key, which is the limit NIST SP 800-38D sets for random nonces. Keys
+sealing more pages should be rotated by Rewrite. The offset of the page is authenticated as
+additional data, so that a page can't be moved to another one.
+
+The header holds a key check value, which is the tag of an empty message sealed with a nonce of
+zeros, so that opening a database with the wrong key fails instead of every read. The header is
+never encrypted, while a page is only sealed with that nonce with negligible probability.
+*/
+
+const (
+	tagSize   = 16
+	nonceSize = 12
+
+	keyCheckSize = tagSize
+)
+
+// A pageCipher encrypts and decrypts the pages of an encrypted database. The nil pageCipher is the
+// one of a database without encryption.
+type pageCipher struct {
+	aead cipher.AEAD
+}
+
+// Encrypts a new database with AES-GCM using key, which has to be 16, 24 or 32 bytes long to select
+// AES-128, AES-192 or AES-256. Existing databases keep their features, so an encrypted database has
+// to be opened with its key, while opening one without encryption fails with ErrNotEncrypted. See
+// Rewrite for encrypting an existing database or rotating its key.
+func Encryption(key []byte) Option {
+	return func(p *Pager) {
+		p.features |= flagEncrypted
+		p.key = key
+	}
+}
+
+func newPageCipher(key []byte) (*pageCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+	return &pageCipher{aead: aead}, nil
+}
+
+// Returns the number of bytes a sealed page is longer than the page.
+func (c *pageCipher) overhead() int64 {
+	if c == nil {
+		return 0
+	}
+	return tagSize + nonceSize
+}
+
+// Returns the key check value of the key of c.
+func (c *pageCipher) keyCheck() [keyCheckSize]byte {
+	var sum [keyCheckSize]byte
+	copy(sum[:], c.aead.Seal(nil, make([]byte, nonceSize), nil, nil))
+	return sum
+}
+
+// Reports weither sum is the key check value of the key of c.
+func (c *pageCipher) checkKey(sum [keyCheckSize]byte) bool {
+	want := c.keyCheck()
+	return subtle.ConstantTimeCompare(sum[:], want[:]) == 1
+}
+
+// Seals the data of the page at off into dst, which has to be len(data) + c.overhead() bytes long.
+func (c *pageCipher) seal(dst []byte, off int64, data []byte) {
+	nonce := dst[len(data)+tagSize:]
+	_, _ = rand.Read(nonce)
+
+	c.aead.Seal(dst[:0], nonce, data, additionalData(off))
+}
+
+// Returns the page at off sealed in src, which starts with the sealed page, its tag and its nonce.
+func (c *pageCipher) open(off int64, src []byte) ([PageSize]byte, error) {
+	var page [PageSize]byte
+	b, err := c.openData(page[:0], off, src[:PageSize+tagSize+nonceSize])
+	if err != nil || len(b) != PageSize {
+		return page, &PageError{Op: "read", Off: off, Err: ErrAuthentication}
+	}
+	return page, nil
+}
+
+// Appends the data of the page at off sealed in src, which ends with its tag and nonce, to dst.
+func (c *pageCipher) openData(dst []byte, off int64, src []byte) ([]byte, error) {
+	if len(src) < tagSize+nonceSize {
+		return nil, &PageError{Op: "read", Off: off, Err: ErrAuthentication}
+	}
+	nonce := src[len(src)-nonceSize:]
+	b, err := c.aead.Open(dst, nonce, src[:len(src)-nonceSize], additionalData(off))
+	if err != nil {
+		return nil, &PageError{Op: "read", Off: off, Err: ErrAuthentication}
+	}
+	return b, nil
+}
+
+func additionalData(off int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(off)) // #nosec G115
+}
@@ -0,0 +1,35 @@
+package pager
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Map nodes of different levels share the lower 32 bits of their page numbers, but neither their
+// nonces nor their additional data.
+func TestPageCipher_nodeOffsets(t *testing.T) {
+	c, err := newPageCipher([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("newPageCipher() failed: %v", err)
+	}
+	a, b := nodeOff(0, 5), nodeOff(1, 5)
+	if uint32(a/PageSize) != uint32(b/PageSize) { // #nosec G115
+		t.Fatalf("want nodes sharing the lower 32 bits, got %d and %d", a, b)
+	}
+
+	data := []byte("map node")
+	sealedA := make([]byte, len(data)+int(c.overhead()))
+	sealedB := make([]byte, len(sealedA))
+	c.seal(sealedA, a, data)
+	c.seal(sealedB, b, data)
+	if bytes.Equal(sealedA[len(sealedA)-nonceSize:], sealedB[len(sealedB)-nonceSize:]) {
+		t.Fatal("want distinct nonces for nodes of different levels")
+	}
+	if _, err := c.openData(nil, b, sealedA); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("want node of level 0 to fail authentication as node of level 1, got %v", err)
+	}
+	if got, err := c.openData(nil, a, sealedA); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("openData() = %q, %v, want %q", got, err, data)
+	}
+}
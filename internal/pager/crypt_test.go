@@ -0,0 +1,177 @@
+package pager_test
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+)
+
+var (
+	testKey  = []byte("0123456789abcdef0123456789abcdef")
+	otherKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// The size of a slot of an encrypted database.
+const sealedSlotSize = pager.PageSize + 28
+
+// Reports weither data contains a value of any round in plaintext.
+func hasPlaintext(data []byte) bool {
+	return bytes.Contains(data, []byte("value0000"))
+}
+
+func TestEncryption(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f, pager.Encryption(testKey))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 2000)
+	if hasPlaintext(f.data) || len(f.data)%sealedSlotSize != 0 {
+		t.Fatalf("want file of sealed slots, got %d bytes with plaintext %t", len(f.data),
+			hasPlaintext(f.data))
+	}
+
+	if p, err = pager.Open(f, pager.Encryption(testKey)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 0, 2000)
+
+	tests := []struct {
+		name string
+		rw   *memFile
+		opts []pager.Option
+		want error
+	}{
+		{"wrong key", f, []pager.Option{pager.Encryption(otherKey)}, pager.ErrInvalidKey},
+		{"no key", f, nil, pager.ErrInvalidKey},
+		{"short key", &memFile{}, []pager.Option{pager.Encryption(testKey[:7])}, pager.ErrInvalidKey},
+		{"not encrypted", testMetaFile(t), []pager.Option{pager.Encryption(testKey)},
+			pager.ErrNotEncrypted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := pager.Open(tt.rw, tt.opts...); !errors.Is(err, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestEncryption_tampered(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f, pager.Encryption(testKey), pager.CacheSize(0))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	offs := testAllocPages(t, p, 2)
+	a, b := offs[0]/pager.PageSize*sealedSlotSize, offs[1]/pager.PageSize*sealedSlotSize
+	r, _ := p.NewReader()
+	defer r.Close()
+
+	tests := []struct {
+		name   string
+		tamper func()
+	}{
+		{"page", func() { f.data[a+100] ^= 0xff }},
+		{"tag", func() { f.data[a+pager.PageSize] ^= 0xff }},
+		{"nonce", func() { f.data[a+sealedSlotSize-1] ^= 0xff }},
+		{"moved", func() { copy(f.data[a:a+sealedSlotSize], f.data[b:b+sealedSlotSize]) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := slices.Clone(f.data)
+			defer func() { f.data = data }()
+			tt.tamper()
+
+			_, err := r.ReadPage(offs[0])
+			var pageErr *pager.PageError
+			if !errors.Is(err, pager.ErrAuthentication) || !errors.As(err, &pageErr) ||
+				pageErr.Off != offs[0] {
+				t.Fatalf("want *PageError wrapping ErrAuthentication, got %v", err)
+			}
+		})
+	}
+}
+
+// A TxID used again after a commit was lost seals its pages with other nonces.
+func TestEncryption_reusedTxID(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f, pager.Encryption(testKey))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	data := slices.Clone(f.data)
+
+	var nonces [][]byte
+	for range 2 {
+		off := testAllocPages(t, p, 1)[0]
+		end := off/pager.PageSize*sealedSlotSize + sealedSlotSize
+		nonces = append(nonces, slices.Clone(f.data[end-12:end]))
+
+		// The commit is lost, like after a crash before the meta page was durable.
+		f.data = slices.Clone(data)
+		if p, err = pager.Open(f, pager.Encryption(testKey)); err != nil {
+			t.Fatalf("Open() failed: %v", err)
+		}
+	}
+	if bytes.Equal(nonces[0], nonces[1]) {
+		t.Fatalf("want distinct nonces for the same page and TxID, got %x twice", nonces[0])
+	}
+}
+
+func TestEncryption_wal(t *testing.T) {
+	f, log := &memFile{}, &memFile{}
+	opts := []pager.Option{pager.Encryption(testKey), pager.WAL(log)}
+	p, err := pager.Open(f, opts...)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 500)
+	testSetRound(t, p, 1, 500)
+	if hasPlaintext(log.data) {
+		t.Fatal("want log without plaintext")
+	}
+
+	if p, err = pager.Open(f, opts...); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 1, 500)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	if hasPlaintext(f.data) {
+		t.Fatal("want database without plaintext after checkpoint")
+	}
+	if p, err = pager.Open(f, opts...); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 1, 500)
+}
+
+func TestPager_RewriteRotateKey(t *testing.T) {
+	p, err := pager.Open(&memFile{}, pager.Encryption(testKey), pager.Checksums())
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 2000)
+
+	dst := &memFile{}
+	if err := p.Rewrite(dst, pager.Encryption(otherKey)); err != nil {
+		t.Fatalf("Rewrite() failed: %v", err)
+	}
+	if hasPlaintext(dst.data) || len(dst.data)%(sealedSlotSize+4) != 0 {
+		t.Fatalf("want encrypted copy with checksums, got %d bytes with plaintext %t",
+			len(dst.data), hasPlaintext(dst.data))
+	}
+	if _, err := pager.Open(dst, pager.Encryption(testKey)); !errors.Is(err, pager.ErrInvalidKey) {
+		t.Fatalf("want old key to be rejected, got %v", err)
+	}
+	q, err := pager.Open(dst, pager.Encryption(otherKey))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, q, 0, 2000)
+}
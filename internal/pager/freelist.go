@@ -0,0 +1,88 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrCorruptFreeList = errors.New("pager: corrupt free list")
+
+/*
+The offsets of all free pages are recorded in the free list, a linked list of pages starting at the
+FreeList offset of the meta page. Every page of the free list holds up to freeListCap offsets:
+
+	Description | Next | N | Offsets
+	------------+------+---+--------
+	Size in B   | 8    | 4 | N * 8
+
+Next is the offset of the following page of the free list or 0 on the last page.
+
+Like every other page, the free list is never modified in place. Every commit writes a new free list
+onto pages that were free before the transaction started or grow the database, while the pages of
+the previous free list are still part of the previous version of the database. They are freed by the
+commit like every other page and recorded in the new free list.
+*/
+
+const (
+	flNextOff   = 0
+	flNOff      = flNextOff + 8
+	flDataOff   = flNOff + 4
+	freeListCap = (PageSize - flDataOff) / 8
+)
+
+// Returns the offsets of the free pages recorded in the free list starting at head and the offsets
+// of the pages of the free list itself.
+func (p *Pager) readFreeList(head int64) (free []int64, pages []int64, err error) {
+	read := p.snapshot(p.meta.txid)
+	seen := make(set[int64])
+	for off := head; off != 0; {
+		if err := checkOffset("read", off, p.meta.end()); err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrCorruptFreeList, err)
+		}
+		if _, ok := seen[off]; ok {
+			return nil, nil, fmt.Errorf("%w: cycle at page %d", ErrCorruptFreeList, off)
+		}
+		seen[off] = struct{}{}
+
+		page, err := read(off)
+		if err != nil {
+			return nil, nil, err
+		}
+		n := binary.LittleEndian.Uint32(page[flNOff:])
+		if n > freeListCap {
+			return nil, nil, fmt.Errorf("%w: page %d holds %d offsets", ErrCorruptFreeList, off, n)
+		}
+		for i := range int(n) {
+			free = append(free, int64(binary.LittleEndian.Uint64(page[flDataOff+8*i:]))) // #nosec G115
+		}
+		pages = append(pages, off)
+		off = int64(binary.LittleEndian.Uint64(page[flNextOff:])) // #nosec G115
+	}
+	for _, off := range free {
+		if err := checkOffset("read", off, p.meta.end()); err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrCorruptFreeList, err)
+		}
+	}
+	return free, pages, nil
+}
+
+// Returns the pages of a free list recording free stored on the pages at offs, which have to
+// provide enough space for all offsets of free.
+func encodeFreeList(free []int64, offs []int64) map[int64][PageSize]byte {
+	pages := make(map[int64][PageSize]byte, len(offs))
+	for i, off := range offs {
+		var page [PageSize]byte
+		if i+1 < len(offs) {
+			binary.LittleEndian.PutUint64(page[flNextOff:], uint64(offs[i+1])) // #nosec G115
+		}
+
+		chunk := free[min(i*freeListCap, len(free)):min((i+1)*freeListCap, len(free))]
+		binary.LittleEndian.PutUint32(page[flNOff:], uint32(len(chunk))) // #nosec G115
+		for j, f := range chunk {
+			binary.LittleEndian.PutUint64(page[flDataOff+8*j:], uint64(f)) // #nosec G115
+		}
+		pages[off] = page
+	}
+	return pages
+}
@@ -0,0 +1,144 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var (
+	ErrNotDatabase        = errors.New("pager: file is not a database")
+	ErrUnsupportedVersion = errors.New("pager: unsupported database format version")
+	ErrPageSizeMismatch   = errors.New("pager: database was created with a different page size")
+	ErrCorruptMeta        = errors.New("pager: no valid meta page")
+)
+
+/*
+The first three pages of a database are reserved for the header and two meta pages. The header is
+written once when the database is created and identifies the file as a database:
+
+	Description | Magic | Version | PageSize | Flags | KeyCheck
+	------------+-------+---------+----------+-------+---------
+	Size in B   | 8     | 2       | 4        | 4     | 16
+
+Flags are the features enabled for the database (see slot.go) and KeyCheck the key check value of
+an encrypted database (see crypt.go).
+
+The two meta pages on page 1 and 2 hold the state of the database as of a committed transaction:
+
+	Description | TxID | Root | Pages | FreeList | Map | Checksum
+	------------+------+------+-------+----------+-----+---------
+	Size in B   | 8    | 8    | 8     | 8        | 8   | 4
+
+TxID is the id of the transaction that wrote the meta page, Root the offset of the root page of the
+database, Pages the number of pages of the database including the reserved ones and FreeList the
+offset of the first page of the free list. Map is the extent of the root of the page map of a
+compressed database (see compress.go) and 0 otherwise. Checksum is the CRC-32C of all fields before it.
+
+Transactions alternately write their meta page onto page 1 and 2, depending on their TxID. On open,
+the valid meta page with the greater TxID is used. Since a meta page is only written after all pages
+of its transaction are durably written, and a torn write of it fails the checksum, the other meta
+page always describes an intact previous version of the database.
+*/
+
+const (
+	magic   = "pavosql\x00"
+	version = 1
+
+	hdrMagicOff    = 0
+	hdrVersionOff  = hdrMagicOff + 8
+	hdrPageSizeOff = hdrVersionOff + 2
+	hdrFlagsOff    = hdrPageSizeOff + 4
+	hdrKeyCheckOff = hdrFlagsOff + 4
+
+	metaTxIDOff     = 0
+	metaRootOff     = metaTxIDOff + 8
+	metaPagesOff    = metaRootOff + 8
+	metaFreeListOff = metaPagesOff + 8
+	metaMapOff      = metaFreeListOff + 8
+	metaChecksumOff = metaMapOff + 8
+
+	// The offset of the first page behind the header and the meta pages.
+	firstPage = 3 * PageSize
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type meta struct {
+	txid     uint64
+	root     int64
+	pages    int64
+	freeList int64
+	// The root of the page map of rw, which is only set when the meta page is written into rw.
+	pageMap extent
+}
+
+// Returns the offset of the meta page m is written onto.
+func (m *meta) offset() int64 {
+	return PageSize * int64(1+m.txid%2) // #nosec G115 // txid%2 is either 0 or 1
+}
+
+func (m *meta) end() int64 {
+	return m.pages * PageSize
+}
+
+func (m *meta) encode() [PageSize]byte {
+	var page [PageSize]byte
+	binary.LittleEndian.PutUint64(page[metaTxIDOff:], m.txid)
+	binary.LittleEndian.PutUint64(page[metaRootOff:], uint64(m.root))         // #nosec G115
+	binary.LittleEndian.PutUint64(page[metaPagesOff:], uint64(m.pages))       // #nosec G115
+	binary.LittleEndian.PutUint64(page[metaFreeListOff:], uint64(m.freeList)) // #nosec G115
+	binary.LittleEndian.PutUint64(page[metaMapOff:], uint64(m.pageMap))
+	binary.LittleEndian.PutUint32(page[metaChecksumOff:],
+		crc32.Checksum(page[:metaChecksumOff], castagnoli))
+	return page
+}
+
+// Returns the meta page stored in page and weither it is valid.
+func decodeMeta(page [PageSize]byte) (meta, bool) {
+	sum := binary.LittleEndian.Uint32(page[metaChecksumOff:])
+	if sum != crc32.Checksum(page[:metaChecksumOff], castagnoli) {
+		return meta{}, false
+	}
+
+	m := meta{
+		txid:     binary.LittleEndian.Uint64(page[metaTxIDOff:]),
+		root:     int64(binary.LittleEndian.Uint64(page[metaRootOff:])),     // #nosec G115
+		pages:    int64(binary.LittleEndian.Uint64(page[metaPagesOff:])),    // #nosec G115
+		freeList: int64(binary.LittleEndian.Uint64(page[metaFreeListOff:])), // #nosec G115
+		pageMap:  extent(binary.LittleEndian.Uint64(page[metaMapOff:])),
+	}
+	if m.pages < firstPage/PageSize || m.root < 0 || m.root >= m.end() || m.freeList < 0 ||
+		m.freeList >= m.end() {
+		return meta{}, false
+	}
+	return m, true
+}
+
+func encodeHeader(flags uint32, keyCheck [keyCheckSize]byte) [PageSize]byte {
+	var page [PageSize]byte
+	copy(page[hdrMagicOff:], magic)
+	binary.LittleEndian.PutUint16(page[hdrVersionOff:], version)
+	binary.LittleEndian.PutUint32(page[hdrPageSizeOff:], PageSize)
+	binary.LittleEndian.PutUint32(page[hdrFlagsOff:], flags)
+	copy(page[hdrKeyCheckOff:], keyCheck[:])
+	return page
+}
+
+// Returns the flags and the key check value of the database with the header page. Unknown flags
+// are treated like an unsupported version.
+func decodeHeader(page [PageSize]byte) (uint32, [keyCheckSize]byte, error) {
+	var keyCheck [keyCheckSize]byte
+	flags := binary.LittleEndian.Uint32(page[hdrFlagsOff:])
+	switch {
+	case !bytes.Equal(page[hdrMagicOff:hdrMagicOff+len(magic)], []byte(magic)):
+		return 0, keyCheck, ErrNotDatabase
+	case binary.LittleEndian.Uint16(page[hdrVersionOff:]) != version || flags&^knownFlags != 0:
+		return 0, keyCheck, ErrUnsupportedVersion
+	case binary.LittleEndian.Uint32(page[hdrPageSizeOff:]) != PageSize:
+		return 0, keyCheck, ErrPageSizeMismatch
+	}
+	copy(keyCheck[:], page[hdrKeyCheckOff:])
+	return flags, keyCheck, nil
+}
@@ -0,0 +1,135 @@
+package pager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrMMapUnsupported = errors.New("pager: memory mapping is not supported for this file")
+
+// Serves the reads of pages from a read-only memory mapping of the database instead of ReadAt. The
+// ReadWriterAt of the database has to provide the descriptor of the file holding its committed
+// contents through TargetFd, like an atomic.File updated through shadow pages (see
+// atomic.ShadowPages). The mapping is extended as the file grows and recreated once it shrinks.
+func MMap() Option {
+	return func(p *Pager) { p.mmap = true }
+}
+
+// Reports weither opts contain MMap.
+func Mapped(opts ...Option) bool {
+	var p Pager
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return p.mmap
+}
+
+// A mappable ReadWriterAt stores its committed contents in a file, whose descriptor is returned by
+// TargetFd.
+type mappable interface {
+	TargetFd() (uintptr, error)
+}
+
+// Returns a mapping of the committed contents of rw.
+//
+// Returns ErrMMapUnsupported if rw isn't mappable.
+func newMapping(rw any) (*mapping, error) {
+	f, ok := rw.(mappable)
+	if !ok {
+		return nil, ErrMMapUnsupported
+	}
+	fd, err := f.TargetFd()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMMapUnsupported, err)
+	}
+	return &mapping{fd: fd}, nil
+}
+
+// A mapping is a read-only memory mapping of a file, which is extended as the file grows and
+// recreated once it shrinks. Pages are copied out of the mapping while holding mu, so that it is
+// never unmapped during a read.
+//
+// Only pages of committed versions of the database are read from the mapping. Since none of them is
+// written while a Reader can reach it, readers never observe partially written pages.
+type mapping struct {
+	mu   sync.RWMutex
+	fd   uintptr
+	data []byte
+}
+
+// Copies the data at pos into b and reports weither it is part of the mapping. The mapping is
+// extended to the current size of the file if b reaches behind its end. Reading b through ReadAt
+// reports the errors of the file if it doesn't.
+func (m *mapping) read(pos int64, b []byte) bool {
+	if m.copy(pos, b) {
+		return true
+	}
+	if err := m.remap(); err != nil {
+		return false
+	}
+	return m.copy(pos, b)
+}
+
+func (m *mapping) copy(pos int64, b []byte) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if pos < 0 || pos+int64(len(b)) > int64(len(m.data)) {
+		return false
+	}
+	copy(b, m.data[pos:])
+	return true
+}
+
+// Maps the whole file if it has grown since it was last mapped.
+func (m *mapping) remap() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	size, err := fileSize(m.fd)
+	if err != nil || size <= int64(len(m.data)) {
+		return err
+	}
+	data, err := mmap(m.fd, size)
+	if err != nil {
+		return err
+	}
+	if m.data != nil {
+		if err := munmap(m.data); err != nil {
+			return err
+		}
+	}
+	m.data = data
+	return nil
+}
+
+// Unmaps the file if it shrank below the mapping, since reads behind the end of a file fault. The
+// file is mapped again by the next read.
+func (m *mapping) fit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil {
+		return nil
+	}
+	size, err := fileSize(m.fd)
+	if err != nil || size >= int64(len(m.data)) {
+		return err
+	}
+	err = munmap(m.data)
+	m.data = nil
+	return err
+}
+
+func (m *mapping) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
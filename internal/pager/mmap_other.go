@@ -0,0 +1,15 @@
+//go:build !unix
+
+package pager
+
+func mmap(uintptr, int64) ([]byte, error) {
+	return nil, ErrMMapUnsupported
+}
+
+func munmap([]byte) error {
+	return ErrMMapUnsupported
+}
+
+func fileSize(uintptr) (int64, error) {
+	return 0, ErrMMapUnsupported
+}
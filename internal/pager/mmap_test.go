@@ -0,0 +1,80 @@
+package pager_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+// Opens a database in a file updated through shadow pages, which can be memory-mapped.
+func openTestFile(t testing.TB, opts ...pager.Option) *pager.Pager {
+	t.Helper()
+	f, err := atomic.OpenFile(filepath.Join(t.TempDir(), "db"), 0o600, atomic.ShadowPages())
+	if err != nil {
+		t.Fatalf("OpenFile() failed: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	p, err := pager.Open(f, opts...)
+	if errors.Is(err, pager.ErrMMapUnsupported) {
+		t.Skip("memory mapping is not supported")
+	} else if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestMMap(t *testing.T) {
+	p := openTestFile(t, pager.MMap(), pager.CacheSize(0))
+
+	testSetRound(t, p, 0, 100)
+	old, _ := p.NewReader()
+	defer old.Close()
+	// The file grows beyond the part mapped by the first reads.
+	testCheckRound(t, p, 0, 100)
+	for round := 1; round < 5; round++ {
+		testSetRound(t, p, round, 2000*round)
+		testCheckRound(t, p, round, 2000*round)
+	}
+
+	if v, err := tree.New(old, old.Root()).Get([]byte("key000000")); err != nil ||
+		string(v) != "value000000" {
+		t.Fatalf("want snapshot to read value of round 0, got %q, %v", v, err)
+	}
+}
+
+func TestMMap_unsupported(t *testing.T) {
+	if _, err := pager.Open(&memFile{}, pager.MMap()); !errors.Is(err, pager.ErrMMapUnsupported) {
+		t.Fatalf("want ErrMMapUnsupported for a file without descriptor, got %v", err)
+	}
+}
+
+func BenchmarkReader_ReadPage(b *testing.B) {
+	for _, mode := range []struct {
+		name string
+		opts []pager.Option
+	}{
+		{"buffered", nil},
+		{"mmap", []pager.Option{pager.MMap()}},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			p := openTestFile(b, append(mode.opts, pager.CacheSize(0))...)
+			offs := testAllocPages(b, p, 1000)
+			r, _ := p.NewReader()
+			defer r.Close()
+
+			b.SetBytes(pager.PageSize)
+			b.ResetTimer()
+			for i := range b.N {
+				if _, err := r.ReadPage(offs[i%len(offs)]); err != nil {
+					b.Fatalf("ReadPage() failed: %v", err)
+				}
+			}
+		})
+	}
+}
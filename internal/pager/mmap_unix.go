@@ -0,0 +1,32 @@
+//go:build unix
+
+package pager
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func mmap(fd uintptr, size int64) ([]byte, error) {
+	// #nosec G115 // fd is a file descriptor and size the size of a file
+	data, err := syscall.Mmap(int(fd), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("pager: failed to map database: %w", err)
+	}
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	if err := syscall.Munmap(data); err != nil {
+		return fmt.Errorf("pager: failed to unmap database: %w", err)
+	}
+	return nil
+}
+
+func fileSize(fd uintptr) (int64, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(fd), &stat); err != nil { // #nosec G115
+		return 0, fmt.Errorf("pager: failed to obtain size of database: %w", err)
+	}
+	return stat.Size, nil
+}
@@ -1,56 +1,558 @@
 package pager
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
 	"sync"
 
+	"github.com/gkits/pavosql/internal/tree"
 	"github.com/gkits/pavosql/pkg/atomic"
 )
 
+// The size of a single page. The pager works in whole pages only, which have the size of a node of
+// the tree.
+const PageSize = tree.PageSize
+
+var (
+	ErrShortRead  = errors.New("pager: short read")
+	ErrUnaligned  = errors.New("pager: offset is not aligned to the page size")
+	ErrOutOfRange = errors.New("pager: offset is out of range")
+	ErrDoubleFree = errors.New("pager: page is already free")
+	ErrTxDone     = errors.New("pager: transaction has already been committed or aborted")
+	ErrClosed     = errors.New("pager: reader has already been closed")
+	ErrReadOnly   = errors.New("pager: reader can't allocate or free pages")
+)
+
+// A PageError records an error together with the operation and the offset of the page that caused
+// it.
+type PageError struct {
+	Op  string
+	Off int64
+	Err error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("pager: %s page %d: %v", e.Op, e.Off, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+/*
+A Pager manages the pages of a database stored in rw. Pages are addressed by their offset inside rw,
+which is always a multiple of PageSize. The first pages hold the header and the meta pages of the
+database (see meta.go) and are never handed out, which allows using offset 0 as a nil pointer.
+
+Pages are read through a Reader and allocated, freed and written through a Writer. There is at most
+a single Writer at a time, which only writes its pages into rw once it is committed. Pages freed by a
+Writer only become free for following Writers once it is committed, so that the pages of the
+previous version of the database stay untouched until the new version is complete.
+
+A Reader reads the snapshot of the database that was committed last when it was created, no matter
+how many Writers commit while it is open. Pages freed by a committed Writer are kept out of the free
+list until every Reader of a previous version, which may still reach them, has been closed.
+
+Commit of rw is used as a write barrier, which has to guarantee that all data written before it is
+durable once it returns.
+
+In WAL mode, committed pages are appended to a write-ahead log (see wal.go) instead, so that the
+cost of a commit only depends on the number of pages written. Readers read the newest version of a
+page within their snapshot from the log and fall back to rw for pages not in it. The log is copied
+into rw by checkpoints. A database used in WAL mode always has to be opened with its log, since rw
+alone misses all transactions committed after the last checkpoint.
+*/
 type Pager struct {
-	rw       atomic.ReadWriterAt
-	freeList int64
-	mu       sync.RWMutex
-	end      int64
+	rw   atomic.ReadWriterAt
+	mu   sync.RWMutex
+	meta meta
+	// The offsets of all free pages in descending order.
+	free []int64
+	// The offsets of the pages the free list is stored on.
+	freeListPages []int64
+	// The number of open Readers per TxID of the version they read.
+	readers map[uint64]int
+	// The pages freed by committed transactions in the order of their TxID, which are not free to
+	// be allocated as long as there are open Readers of previous versions.
+	pending []pendingPages
+	// The write-ahead log in WAL mode or nil.
+	wal   *wal
+	log   *slog.Logger
+	cache *cache
+	// The memory mapping pages are read from if mmap is set.
+	mmap    bool
+	mapping *mapping
+	// The flags of the database, the flags requested for a new one and the resulting size of slots.
+	flags, features uint32
+	slotSize        int64
+	// The encryption key and the cipher of an encrypted database or nil.
+	key    []byte
+	cipher *pageCipher
+	// The page map of a compressed database or nil.
+	pages *pageMap
+	// Set once the database shrank until rw is truncated accordingly.
+	shrink bool
+	// Held by the active Writer from its creation until it is committed or aborted.
+	writer sync.Mutex
 }
 
 type (
-	readFn   = func(int64) ([]byte, error)
-	commitFn = func(map[int64][]byte) error
+	readFn   = func(int64) ([PageSize]byte, error)
+	commitFn = func(
+		pages map[int64][PageSize]byte, free, freed, freeListPages []int64, m meta, shrink bool,
+	) error
+	abortFn = func()
 
 	set[T comparable] = map[T]struct{}
 )
 
+type pendingPages struct {
+	txid uint64
+	offs []int64
+}
+
+// An Option configures a Pager.
+type Option func(*Pager)
+
+// Opens the Pager in WAL mode with log as its write-ahead log. An empty log is initialized, while
+// the transactions committed to an existing one are recovered.
+func WAL(log atomic.ReadWriterAt) Option {
+	return func(p *Pager) { p.wal = newWAL(log) }
+}
+
+// Sets the memory budget of the page cache shared by all Readers and Writers to size bytes. Defaults
+// to 8 MiB. A size smaller than PageSize disables caching.
+func CacheSize(size int) Option {
+	return func(p *Pager) { p.cache = newCache(size / PageSize) }
+}
+
+// Sets the logger recovery on open and failures of automatic checkpoints are reported to. Defaults
+// to slog.Default.
+func Logger(l *slog.Logger) Option {
+	return func(p *Pager) { p.log = l }
+}
+
+// Opens the database stored in rw and returns a Pager managing its pages. If rw is empty, a new
+// database is created.
+//
+// Open recovers the last committed transaction if the process died while committing: A torn meta
+// page is discarded in favor of the other one, which describes the previous transaction. In WAL
+// mode, all transactions fully committed to the log are replayed, while the frames of a transaction
+// that never committed are discarded.
+//
+// Returns ErrNotDatabase, ErrUnsupportedVersion or ErrPageSizeMismatch if the header of rw does not
+// belong to a database of this version and ErrCorruptMeta if neither meta page is valid. Returns
+// ErrInvalidKey if the database is encrypted with another key than the one passed by Encryption.
+func Open(rw atomic.ReadWriterAt, opts ...Option) (*Pager, error) {
+	p := &Pager{
+		rw:      rw,
+		readers: make(map[uint64]int),
+		log:     slog.Default(),
+		cache:   newCache(defaultCacheSize / PageSize),
+
+		slotSize: PageSize,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.mmap {
+		var err error
+		if p.mapping, err = newMapping(rw); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.wal != nil {
+		p.wal.setCipher(p.cipher)
+		var rec recovery
+		if p.meta, rec, err = p.wal.recover(p.meta); err != nil {
+			return nil, err
+		}
+		p.logRecovery(rec)
+	}
+	if p.free, p.freeListPages, err = p.readFreeList(p.meta.freeList); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(p.free, descending)
+	return p, nil
+}
+
+// Reads the header and the current meta page of rw or creates a new database if rw is empty.
+func (p *Pager) load() error {
+	var hdr [PageSize]byte
+	n, err := p.rw.ReadAt(hdr[:], 0)
+	switch {
+	case n == 0 && errors.Is(err, io.EOF):
+		return p.create()
+	case n < PageSize && (err == nil || errors.Is(err, io.EOF)):
+		return ErrNotDatabase
+	case n < PageSize:
+		return fmt.Errorf("pager: failed to read header: %w", err)
+	}
+	flags, keyCheck, err := decodeHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if err := p.setFlags(flags); err != nil {
+		return err
+	}
+	if p.cipher != nil && !p.cipher.checkKey(keyCheck) {
+		return ErrInvalidKey
+	}
+	if _, err := p.read(0); err != nil {
+		return err
+	}
+
+	found := false
+	for _, off := range []int64{PageSize, 2 * PageSize} {
+		page, err := p.read(off)
+		if errors.Is(err, ErrShortRead) {
+			continue
+		} else if err != nil && !errors.Is(err, ErrChecksumMismatch) &&
+			!errors.Is(err, ErrAuthentication) {
+			return err
+		}
+		m, ok := decodeMeta(page)
+		if !ok || err != nil {
+			p.log.Warn("pager: discarded invalid meta page", "offset", off)
+			continue
+		}
+		if !found || m.txid > p.meta.txid {
+			p.meta, found = m, true
+		}
+	}
+	if !found {
+		return ErrCorruptMeta
+	}
+	if p.pages != nil {
+		return p.loadMap(p.meta.pageMap, p.meta.pages)
+	}
+	return nil
+}
+
+// Returns the header page of the database.
+func (p *Pager) header() [PageSize]byte {
+	var keyCheck [keyCheckSize]byte
+	if p.cipher != nil {
+		keyCheck = p.cipher.keyCheck()
+	}
+	return encodeHeader(p.flags, keyCheck)
+}
+
+func (p *Pager) logRecovery(rec recovery) {
+	if rec.reset {
+		p.log.Debug("pager: initialized write-ahead log")
+	}
+	if rec.to > rec.from {
+		p.log.Info("pager: recovered transactions from write-ahead log", "from", rec.from+1,
+			"to", rec.to, "frames", rec.frames)
+	}
+	if rec.discarded > 0 {
+		p.log.Warn("pager: discarded frames of uncommitted transaction from write-ahead log",
+			"frames", rec.discarded)
+	}
+}
+
+// Writes the header and both meta pages of an empty database with the requested features.
+func (p *Pager) create() error {
+	if err := p.setFlags(p.features); err != nil {
+		return err
+	}
+	if err := p.write(0, p.header()); err != nil {
+		return err
+	}
+	p.meta = meta{pages: firstPage / PageSize}
+	if err := p.writeMap(p.meta.pages); err != nil {
+		return err
+	}
+	for txid := range uint64(2) {
+		p.meta.txid = txid
+		if err := p.write(p.meta.offset(), p.encodeMeta(p.meta)); err != nil {
+			return err
+		}
+	}
+	if err := p.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to create database: %w", err)
+	}
+	p.pages.commit()
+	return nil
+}
+
+// Returns a new Reader reading the pages of the current version of the database. The Reader has to
+// be closed once it is no longer used, so that the pages of its version can be reused.
 func (p *Pager) NewReader() (*Reader, error) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.newReader(), nil
+}
 
-	reader := newReader(p.read)
+// Returns a new Reader reading the pages of the current version of the database. p.mu has to be
+// held.
+func (p *Pager) newReader() *Reader {
+	txid := p.meta.txid
+	p.readers[txid]++
+	reader := newReader(p.cache, p.snapshot(txid), p.meta)
+	reader.close = func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
 
-	return reader, nil
+		if p.readers[txid]--; p.readers[txid] == 0 {
+			delete(p.readers, txid)
+		}
+	}
+	return reader
 }
 
+// Returns a new Writer. NewWriter blocks until the previous Writer is committed or aborted.
 func (p *Pager) NewWriter() (*Writer, error) {
+	p.writer.Lock()
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	writer := newWriter(newReader(p.read), make(set[int64]), p.end, p.commit)
+	p.release()
+	var pending []int64
+	for _, pp := range p.pending {
+		pending = append(pending, pp.offs...)
+	}
+	reader := newReader(p.cache, p.snapshot(p.meta.txid), p.meta)
+	writer := newWriter(reader, slices.Clone(p.free), pending, p.freeListPages, p.commit,
+		p.writer.Unlock)
 
 	return writer, nil
 }
 
-func (p *Pager) read(off int64) ([]byte, error) {
-	page := make([]byte, 99)
-	if _, err := p.rw.ReadAt(page, int64(off)); err != nil {
-		return nil, err
+// Moves the pending pages that can't be reached by any open Reader anymore into the free list. The
+// pages freed by the transaction with TxID n are reachable by the Readers of all versions before n.
+func (p *Pager) release() {
+	oldest, open := uint64(0), len(p.readers) > 0
+	for txid := range p.readers {
+		if oldest == 0 || txid < oldest {
+			oldest = txid
+		}
+	}
+
+	i := 0
+	for ; i < len(p.pending) && (!open || p.pending[i].txid <= oldest); i++ {
+		p.free = append(p.free, p.pending[i].offs...)
+	}
+	if i > 0 {
+		p.pending = p.pending[i:]
+		slices.SortFunc(p.free, descending)
 	}
-	return page, nil
 }
 
-func (p *Pager) commit(changes map[int64][]byte) error {
-	for off, d := range changes {
-		if _, err := p.rw.WriteAt(d, off); err != nil {
+// Returns a readFn reading the pages of the version of the database committed by txid.
+func (p *Pager) snapshot(txid uint64) readFn {
+	if p.wal == nil {
+		return p.read
+	}
+	return func(off int64) ([PageSize]byte, error) {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+
+		if pos, ok := p.wal.lookup(off, txid); ok {
+			return p.wal.readPage(off, pos)
+		}
+		return p.read(off)
+	}
+}
+
+// Writes pages followed by the meta page m. Both are separated by a write barrier, which guarantees
+// that m is never durable without the pages it describes. The pages freed by the transaction are
+// kept pending until no Reader of a previous version is open anymore.
+//
+// In WAL mode, the pages and m are appended to the log instead, which is checkpointed once it holds
+// more than walCheckpointFrames frames. The transaction is committed even if the checkpoint fails.
+//
+// If shrink is set, rw is truncated behind the last page of the database once m is durable, which
+// is postponed to the next checkpoint in WAL mode.
+func (p *Pager) commit(pages map[int64][PageSize]byte, free, freed, freeListPages []int64,
+	m meta, shrink bool,
+) error {
+	offs := make([]int64, 0, len(pages))
+	for off := range pages {
+		offs = append(offs, off)
+	}
+	slices.Sort(offs)
+
+	if p.wal != nil {
+		if err := p.wal.append(pages, offs, m); err != nil {
 			return err
 		}
+		p.mu.Lock()
+		p.wal.commit(offs, m.txid)
+		p.update(offs, free, freed, freeListPages, m)
+		p.shrink = p.shrink || shrink
+		p.mu.Unlock()
+
+		// The transaction is durable already, which a failed checkpoint doesn't change. It is
+		// retried by the next commit.
+		if p.wal.frames >= walCheckpointFrames {
+			if err := p.checkpoint(); err != nil {
+				p.log.Warn("pager: failed to checkpoint write-ahead log", "error", err)
+			}
+		}
+		return nil
+	}
+
+	defer p.pages.rollback()
+	for _, off := range offs {
+		if err := p.write(off, pages[off]); err != nil {
+			return err
+		}
+	}
+	if err := p.writeMap(m.pages); err != nil {
+		return err
+	}
+	if err := p.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to commit: %w", err)
+	}
+
+	if err := p.write(m.offset(), p.encodeMeta(m)); err != nil {
+		return err
+	}
+	if err := p.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to commit meta page: %w", err)
+	}
+	p.pages.commit()
+	p.fitMapping()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.update(offs, free, freed, freeListPages, m)
+	if shrink {
+		p.shrink = true
+		p.truncate()
+	}
+	return nil
+}
+
+// Returns the meta page m as written into rw, which records the root of the page map of a
+// compressed database.
+func (p *Pager) encodeMeta(m meta) [PageSize]byte {
+	m.pageMap = p.pages.root(m.pages)
+	return m.encode()
+}
+
+// Makes the version of the database described by m the current one and removes the pages at offs
+// written by its transaction from the cache. p.mu has to be held.
+func (p *Pager) update(offs, free, freed, freeListPages []int64, m meta) {
+	p.cache.invalidate(offs)
+	p.free = free
+	if len(freed) > 0 {
+		p.pending = append(p.pending, pendingPages{m.txid, freed})
+	}
+	p.freeListPages = freeListPages
+	p.meta = m
+}
+
+// Fits the memory mapping to the file of the database after rw was committed, which truncates the
+// file if a previous commit shrank the database. Failures are only logged, since the pages of the
+// database are never read behind its end.
+func (p *Pager) fitMapping() {
+	if p.mapping == nil {
+		return
+	}
+	if err := p.mapping.fit(); err != nil {
+		p.log.Warn("pager: failed to unmap database", "error", err)
+	}
+}
+
+// Closes p and releases the memory mapping of the database. p can't be used anymore after Close
+// returned. Close doesn't close the ReadWriterAt of the database.
+func (p *Pager) Close() error {
+	if p.mapping != nil {
+		return p.mapping.close()
+	}
+	return nil
+}
+
+// Returns the counters of the page cache.
+func (p *Pager) CacheStats() CacheStats {
+	return p.cache.stats()
+}
+
+// Copies all pages committed to the write-ahead log into rw and resets the log. Checkpoint blocks
+// until the active Writer is committed or aborted and does nothing if p is not in WAL mode.
+func (p *Pager) Checkpoint() error {
+	if p.wal == nil {
+		return nil
+	}
+	p.writer.Lock()
+	defer p.writer.Unlock()
+
+	return p.checkpoint()
+}
+
+// Copies the newest version of every page in the log into rw followed by the current meta page and
+// resets the log. Pages are copied regardless of open Readers, since a page reachable by a Reader is
+// never written again while the Reader is open. The caller has to hold p.writer.
+func (p *Pager) checkpoint() error {
+	offs := make([]int64, 0, len(p.wal.index))
+	for off := range p.wal.index {
+		offs = append(offs, off)
+	}
+	slices.Sort(offs)
+
+	defer p.pages.rollback()
+	for _, off := range offs {
+		// Pages behind the end of the database were freed and trimmed by a shrinking commit.
+		if off >= p.meta.end() {
+			continue
+		}
+		frame := p.wal.index[off][len(p.wal.index[off])-1]
+		page, err := p.wal.readPage(off, frame.pos)
+		if err != nil {
+			return err
+		}
+		if err := p.write(off, page); err != nil {
+			return err
+		}
+	}
+	if err := p.writeMap(p.meta.pages); err != nil {
+		return err
+	}
+	if err := p.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to checkpoint: %w", err)
+	}
+
+	if err := p.write(p.meta.offset(), p.encodeMeta(p.meta)); err != nil {
+		return err
+	}
+	if err := p.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to checkpoint meta page: %w", err)
+	}
+	p.pages.commit()
+	p.fitMapping()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.shrink {
+		p.truncate()
+	}
+	return p.wal.reset(p.wal.salt + 1)
+}
+
+// Returns an error if off is not the offset of a page in the range from the first page behind the
+// reserved pages to end.
+func checkOffset(op string, off, end int64) error {
+	switch {
+	case off%PageSize != 0:
+		return &PageError{Op: op, Off: off, Err: ErrUnaligned}
+	case off < firstPage || off >= end:
+		return &PageError{Op: op, Off: off, Err: ErrOutOfRange}
+	default:
+		return nil
 	}
-	return p.rw.Commit()
 }
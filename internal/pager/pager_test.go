@@ -0,0 +1,655 @@
+package pager_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// An in memory atomic.ReadWriterAt logging the offsets of all writes and commits, which are logged
+// as -1.
+type memFile struct {
+	mu      sync.Mutex
+	data    []byte
+	commits int
+	log     []int64
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := int(off) + len(b); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	f.log = append(f.log, off)
+	return copy(f.data[off:], b), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < int64(len(f.data)) {
+		f.data = f.data[:size]
+	}
+	return nil
+}
+
+func (f *memFile) Commit() error {
+	f.commits++
+	f.log = append(f.log, -1)
+	return nil
+}
+
+func (f *memFile) Abort() error { return nil }
+
+func testPage(s string) [pager.PageSize]byte {
+	var page [pager.PageSize]byte
+	copy(page[:], s)
+	return page
+}
+
+func newTestPager(t *testing.T) (*pager.Pager, *memFile) {
+	t.Helper()
+	f := &memFile{}
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	f.log = nil
+	return p, f
+}
+
+func TestWriter(t *testing.T) {
+	p, f := newTestPager(t)
+
+	w, _ := p.NewWriter()
+	var offs []int64
+	for i := range 3 {
+		off, err := w.Alloc(testPage(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatalf("Alloc() failed: %v", err)
+		}
+		offs = append(offs, off)
+	}
+	want := []int64{3 * pager.PageSize, 4 * pager.PageSize, 5 * pager.PageSize}
+	if !slices.Equal(offs, want) {
+		t.Fatalf("want offsets %v, got %v", want, offs)
+	}
+	if page, err := w.ReadPage(offs[1]); err != nil || page != testPage("1") {
+		t.Fatalf("want writer to read its own pages, got %q, %v", page[:1], err)
+	}
+	if len(f.log) != 0 {
+		t.Fatalf("want no pages written before commit, got writes %v", f.log)
+	}
+	w.SetRoot(offs[0])
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	// The meta page of the second transaction goes onto page 1 and is only written after a barrier.
+	if want := append(want, -1, pager.PageSize, -1); !slices.Equal(f.log, want) {
+		t.Fatalf("want writes %v, got %v", want, f.log)
+	}
+
+	r, _ := p.NewReader()
+	if r.Root() != offs[0] || r.TxID() != 2 {
+		t.Fatalf("want root %d in transaction 2, got %d in %d", offs[0], r.Root(), r.TxID())
+	}
+	for i, off := range offs {
+		if page, err := r.ReadPage(off); err != nil || page != testPage(fmt.Sprint(i)) {
+			t.Fatalf("ReadPage(%d) = %q, %v, want %q", off, page[:1], err, fmt.Sprint(i))
+		}
+	}
+	if _, err := w.Alloc(testPage("")); !errors.Is(err, pager.ErrTxDone) {
+		t.Fatalf("want ErrTxDone after commit, got %v", err)
+	}
+}
+
+func TestWriter_Free(t *testing.T) {
+	p, _ := newTestPager(t)
+
+	w, _ := p.NewWriter()
+	a, _ := w.Alloc(testPage("a"))
+	b, _ := w.Alloc(testPage("b"))
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	w, _ = p.NewWriter()
+	if err := w.Free(a); err != nil {
+		t.Fatalf("Free() failed: %v", err)
+	}
+	if err := w.Free(a); !errors.Is(err, pager.ErrDoubleFree) {
+		t.Fatalf("want ErrDoubleFree, got %v", err)
+	}
+	// a is still part of the committed version and must not be reused before the commit.
+	c, _ := w.Alloc(testPage("c"))
+	if c == a {
+		t.Fatal("want committed page not to be reused before commit")
+	}
+	if err := w.Free(c); err != nil {
+		t.Fatalf("Free() failed: %v", err)
+	}
+	if again, _ := w.Alloc(testPage("c")); again != c {
+		t.Fatalf("want page allocated in the same transaction to be reused, got %d", again)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	w, _ = p.NewWriter()
+	if off, _ := w.Alloc(testPage("d")); off != a {
+		t.Fatalf("want freed page %d to be reused after commit, got %d", a, off)
+	}
+	if page, _ := w.ReadPage(b); page != testPage("b") {
+		t.Fatalf("want page b untouched, got %q", page[:1])
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort() failed: %v", err)
+	}
+
+	w, _ = p.NewWriter()
+	if off, _ := w.Alloc(testPage("e")); off != a {
+		t.Fatalf("want aborted allocation to be discarded, got %d", off)
+	}
+	w.Abort()
+}
+
+func TestWriter_Free_pending(t *testing.T) {
+	p, _ := newTestPager(t)
+	offs := testAllocPages(t, p, 2)
+
+	// The open Reader keeps the freed page pending.
+	r, _ := p.NewReader()
+	defer r.Close()
+	testFreePages(t, p, offs[:1])
+
+	w, _ := p.NewWriter()
+	defer w.Abort()
+	// Neither the pending page nor the free list can be reused, which is why the new page is
+	// allocated right after the free list.
+	next, _ := w.Alloc(testPage("next"))
+	for _, off := range []int64{offs[0], next - pager.PageSize} {
+		if err := w.Free(off); !errors.Is(err, pager.ErrDoubleFree) {
+			t.Fatalf("Free(%d) = %v, want ErrDoubleFree", off, err)
+		}
+	}
+	if err := w.Free(offs[1]); err != nil {
+		t.Fatalf("Free() failed: %v", err)
+	}
+}
+
+func TestPager_errors(t *testing.T) {
+	p, f := newTestPager(t)
+	w, _ := p.NewWriter()
+	off, _ := w.Alloc(testPage("a"))
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		off  int64
+		want error
+	}{
+		{"unaligned", off + 1, pager.ErrUnaligned},
+		{"header", 0, pager.ErrOutOfRange},
+		{"meta page", 2 * pager.PageSize, pager.ErrOutOfRange},
+		{"behind end", off + pager.PageSize, pager.ErrOutOfRange},
+		{"negative", -pager.PageSize, pager.ErrOutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := p.NewReader()
+			_, err := r.ReadPage(tt.off)
+			var pageErr *pager.PageError
+			if !errors.Is(err, tt.want) || !errors.As(err, &pageErr) || pageErr.Off != tt.off {
+				t.Fatalf("want *PageError wrapping %v for offset %d, got %v", tt.want, tt.off, err)
+			}
+
+			w, _ := p.NewWriter()
+			defer w.Abort()
+			if err := w.Free(tt.off); !errors.Is(err, tt.want) {
+				t.Fatalf("want Free to fail with %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	f.data = f.data[:len(f.data)-10]
+	r, _ := p.NewReader()
+	if _, err := r.ReadPage(off); !errors.Is(err, pager.ErrShortRead) {
+		t.Fatalf("want ErrShortRead for truncated page, got %v", err)
+	}
+}
+
+func TestPager_tree(t *testing.T) {
+	p, f := newTestPager(t)
+
+	for round := range 3 {
+		w, _ := p.NewWriter()
+		tr := tree.New(w, w.Root())
+		for i := range 2000 {
+			k := fmt.Appendf(nil, "key%06d", round*2000+i)
+			if err := tr.Set(k, bytes.Repeat(k, 10)); err != nil {
+				t.Fatalf("Set(%q) failed: %v", k, err)
+			}
+		}
+		for i := 0; i < 2000; i += 2 {
+			if err := tr.Delete(fmt.Appendf(nil, "key%06d", round*2000+i)); err != nil {
+				t.Fatalf("Delete() failed: %v", err)
+			}
+		}
+		w.SetRoot(tr.Root())
+		if err := w.Commit(); err != nil {
+			t.Fatalf("Commit() failed: %v", err)
+		}
+	}
+
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	w, _ := p.NewWriter()
+	defer w.Abort()
+	tr := tree.New(w, w.Root())
+	if r := tr.Check(); !r.OK() || r.Keys != 3000 {
+		t.Fatalf("want 3000 keys without violations, got %s", r)
+	}
+	for i := 1; i < 6000; i += 2 {
+		k := fmt.Appendf(nil, "key%06d", i)
+		if got, err := tr.Get(k); err != nil || !bytes.Equal(got, bytes.Repeat(k, 10)) {
+			t.Fatalf("Get(%q) = %q, %v", k, got, err)
+		}
+	}
+}
+
+// Returns a database file holding two committed transactions with the roots 3 and 4.
+func testMetaFile(t *testing.T) *memFile {
+	t.Helper()
+	p, f := newTestPager(t)
+	for _, root := range []int64{3, 4} {
+		w, _ := p.NewWriter()
+		off, _ := w.Alloc(testPage(fmt.Sprint(root)))
+		w.SetRoot(off)
+		if err := w.Commit(); err != nil {
+			t.Fatalf("Commit() failed: %v", err)
+		}
+	}
+	return f
+}
+
+func TestOpen(t *testing.T) {
+	f := testMetaFile(t)
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	r, _ := p.NewReader()
+	if r.Root() != 4*pager.PageSize || r.TxID() != 3 {
+		t.Fatalf("want root %d in transaction 3, got %d in %d", 4*pager.PageSize, r.Root(), r.TxID())
+	}
+
+	w, _ := p.NewWriter()
+	if off, _ := w.Alloc(testPage("")); off != 5*pager.PageSize {
+		t.Fatalf("want reopened database to grow behind its last page, got offset %d", off)
+	}
+	w.Abort()
+}
+
+func TestOpen_tornMeta(t *testing.T) {
+	f := testMetaFile(t)
+	// Transaction 3 wrote its meta page onto page 2.
+	f.data[2*pager.PageSize+5] ^= 0xff
+
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	r, _ := p.NewReader()
+	if r.Root() != 3*pager.PageSize || r.TxID() != 2 {
+		t.Fatalf("want previous root %d in transaction 2, got %d in %d", 3*pager.PageSize, r.Root(),
+			r.TxID())
+	}
+	if page, err := r.ReadPage(r.Root()); err != nil || page != testPage("3") {
+		t.Fatalf("want previous version intact, got %q, %v", page[:1], err)
+	}
+
+	f.data[pager.PageSize+5] ^= 0xff
+	if _, err := pager.Open(f); !errors.Is(err, pager.ErrCorruptMeta) {
+		t.Fatalf("want ErrCorruptMeta with both meta pages corrupt, got %v", err)
+	}
+}
+
+func TestOpen_header(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(d []byte) []byte
+		want    error
+	}{
+		{"magic", func(d []byte) []byte { d[0] = 'x'; return d }, pager.ErrNotDatabase},
+		{"version", func(d []byte) []byte { d[8] = 99; return d }, pager.ErrUnsupportedVersion},
+		{"page size", func(d []byte) []byte { d[11] = 0xff; return d }, pager.ErrPageSizeMismatch},
+		{"flags", func(d []byte) []byte { d[17] = 0x80; return d }, pager.ErrUnsupportedVersion},
+		{"truncated", func(d []byte) []byte { return d[:100] }, pager.ErrNotDatabase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testMetaFile(t)
+			f.data = tt.corrupt(f.data)
+			if _, err := pager.Open(f); !errors.Is(err, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// Returns the offsets of n pages allocated and committed by a single Writer.
+func testAllocPages(t testing.TB, p *pager.Pager, n int) []int64 {
+	t.Helper()
+	w, _ := p.NewWriter()
+	offs := make([]int64, n)
+	for i := range offs {
+		offs[i], _ = w.Alloc(testPage(fmt.Sprint(i)))
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	return offs
+}
+
+func testFreePages(t *testing.T, p *pager.Pager, offs []int64) {
+	t.Helper()
+	w, _ := p.NewWriter()
+	for _, off := range offs {
+		if err := w.Free(off); err != nil {
+			t.Fatalf("Free(%d) failed: %v", off, err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+}
+
+func TestPager_freeList(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{"single page", 10},
+		// More offsets than fit onto a single page of the free list.
+		{"multiple pages", 3000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, f := newTestPager(t)
+			offs := testAllocPages(t, p, tt.n)
+			testFreePages(t, p, offs)
+			size := len(f.data)
+
+			p, err := pager.Open(f)
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			w, _ := p.NewWriter()
+			defer w.Abort()
+			for i, want := range offs {
+				if off, _ := w.Alloc(testPage("")); off != want {
+					t.Fatalf("want free page %d to be reused after reopen, got %d at %d", want, off,
+						i)
+				}
+			}
+			if err := w.Commit(); err != nil {
+				t.Fatalf("Commit() failed: %v", err)
+			}
+			// The pages of the previous free list are recorded on a single new one.
+			if want := size + pager.PageSize; len(f.data) != want {
+				t.Fatalf("want file size %d, got %d", want, len(f.data))
+			}
+		})
+	}
+}
+
+func TestPager_freeListTornMeta(t *testing.T) {
+	p, f := newTestPager(t)
+	offs := testAllocPages(t, p, 10)
+	testFreePages(t, p, offs[:5])
+	testFreePages(t, p, offs[5:])
+	// The last transaction wrote its meta page onto page 1.
+	f.data[pager.PageSize+5] ^= 0xff
+
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	w, _ := p.NewWriter()
+	defer w.Abort()
+	for i, want := range offs[:5] {
+		if off, _ := w.Alloc(testPage("")); off != want {
+			t.Fatalf("want free page %d of the previous version, got %d at %d", want, off, i)
+		}
+	}
+	// offs[5:] are still in use by the previous version.
+	for i, off := range offs[5:] {
+		if page, err := w.ReadPage(off); err != nil || page != testPage(fmt.Sprint(5+i)) {
+			t.Fatalf("want page %d intact, got %q, %v", off, page[:1], err)
+		}
+	}
+}
+
+func TestPager_freeListBounded(t *testing.T) {
+	p, f := newTestPager(t)
+	var size int
+	for round := range 200 {
+		w, _ := p.NewWriter()
+		tr := tree.New(w, w.Root())
+		for i := range 50 {
+			k := fmt.Appendf(nil, "key%06d", i)
+			if err := tr.Set(k, fmt.Appendf(nil, "value%06d", round)); err != nil {
+				t.Fatalf("Set(%q) failed: %v", k, err)
+			}
+		}
+		w.SetRoot(tr.Root())
+		if err := w.Commit(); err != nil {
+			t.Fatalf("Commit() failed: %v", err)
+		}
+
+		if round == 10 {
+			size = len(f.data)
+		}
+		if round%50 == 49 {
+			var err error
+			if p, err = pager.Open(f); err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+		}
+	}
+	if len(f.data) != size {
+		t.Fatalf("want file size to stay at %d, got %d", size, len(f.data))
+	}
+}
+
+func TestOpen_corruptFreeList(t *testing.T) {
+	p, f := newTestPager(t)
+	offs := testAllocPages(t, p, 3)
+	testFreePages(t, p, offs)
+	// The free list is stored on the first page behind the freed ones.
+	head := offs[2] + pager.PageSize
+
+	tests := []struct {
+		name    string
+		corrupt func(page []byte)
+	}{
+		{"count", func(page []byte) { page[8] = 0xff; page[9] = 0xff }},
+		{"offset", func(page []byte) { page[12] = 1 }},
+		{"next", func(page []byte) { page[1] = 0xff }},
+		{"cycle", func(page []byte) { binary.LittleEndian.PutUint64(page, uint64(head)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &memFile{data: slices.Clone(f.data)}
+			tt.corrupt(f.data[head : head+pager.PageSize])
+			if _, err := pager.Open(f); !errors.Is(err, pager.ErrCorruptFreeList) {
+				t.Fatalf("want ErrCorruptFreeList, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReader_snapshot(t *testing.T) {
+	p, f := newTestPager(t)
+	a := testAllocPages(t, p, 1)[0]
+
+	r, _ := p.NewReader()
+	w, _ := p.NewWriter()
+	w.Free(a)
+	b, _ := w.Alloc(testPage("b"))
+	w.SetRoot(b)
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	if r.Root() != 0 || r.TxID() != 2 {
+		t.Fatalf("want snapshot of transaction 2, got root %d in %d", r.Root(), r.TxID())
+	}
+	if page, err := r.ReadPage(a); err != nil || page != testPage("0") {
+		t.Fatalf("want freed page readable by snapshot, got %q, %v", page[:1], err)
+	}
+	w, _ = p.NewWriter()
+	if off, _ := w.Alloc(testPage("c")); off == a {
+		t.Fatal("want page reachable by open reader not to be reused")
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	// The pages pending for open readers are free after reopening.
+	reopened, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	w, _ = reopened.NewWriter()
+	if off, _ := w.Alloc(testPage("d")); off != a {
+		t.Fatalf("want pending page %d to be free after reopen, got %d", a, off)
+	}
+	w.Abort()
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	w, _ = p.NewWriter()
+	defer w.Abort()
+	if off, _ := w.Alloc(testPage("d")); off != a {
+		t.Fatalf("want page %d to be reused after reader is closed, got %d", a, off)
+	}
+
+	if _, err := r.ReadPage(b); !errors.Is(err, pager.ErrClosed) {
+		t.Fatalf("want ErrClosed reading from closed reader, got %v", err)
+	}
+	if err := r.Close(); !errors.Is(err, pager.ErrClosed) {
+		t.Fatalf("want ErrClosed closing reader twice, got %v", err)
+	}
+}
+
+func TestReader_oldest(t *testing.T) {
+	p, _ := newTestPager(t)
+	offs := testAllocPages(t, p, 2)
+
+	old, _ := p.NewReader()
+	testFreePages(t, p, offs[:1])
+	newer, _ := p.NewReader()
+	testFreePages(t, p, offs[1:])
+
+	// offs[0] is reachable by old only and offs[1] by both readers.
+	newer.Close()
+	w, _ := p.NewWriter()
+	if off, _ := w.Alloc(testPage("")); slices.Contains(offs, off) {
+		t.Fatalf("want no page reachable by the oldest reader to be reused, got %d", off)
+	}
+	w.Abort()
+
+	old.Close()
+	w, _ = p.NewWriter()
+	defer w.Abort()
+	for _, want := range offs {
+		if off, _ := w.Alloc(testPage("")); off != want {
+			t.Fatalf("want page %d to be reused, got %d", want, off)
+		}
+	}
+}
+
+func TestReader_concurrent(t *testing.T) {
+	p, _ := newTestPager(t)
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := range rounds {
+			w, _ := p.NewWriter()
+			tr := tree.New(w, w.Root())
+			for i := range 100 {
+				k := fmt.Appendf(nil, "key%04d", i)
+				if err := tr.Set(k, fmt.Appendf(nil, "%d", round)); err != nil {
+					t.Errorf("Set(%q) failed: %v", k, err)
+					w.Abort()
+					return
+				}
+			}
+			w.SetRoot(tr.Root())
+			if err := w.Commit(); err != nil {
+				t.Errorf("Commit() failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				r, _ := p.NewReader()
+				tr := tree.New(r, r.Root())
+				// All values of a snapshot were written by the same transaction.
+				var want []byte
+				all, errf := tr.Range(nil, nil)
+				for k, v := range all {
+					if want == nil {
+						want = v
+					} else if !bytes.Equal(v, want) {
+						t.Errorf("want %q for %q in transaction %d, got %q", want, k, r.TxID(), v)
+					}
+				}
+				if err := errf(); err != nil {
+					t.Errorf("Range() failed: %v", err)
+				}
+				if rep := tr.Check(); !rep.OK() {
+					t.Errorf("want snapshot %d without violations, got %s", r.TxID(), rep)
+				}
+				r.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
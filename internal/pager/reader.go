@@ -1,23 +1,118 @@
 package pager
 
+// A Reader reads the pages of the version of the database that was current when it was created.
+// Pages are read through the page cache shared by all Readers of a Pager.
+//
+// A Reader implements the pager expected by tree.Tree for read-only trees. Committing or aborting a
+// Reader closes it.
 type Reader struct {
-	pages map[int64][]byte
-	read  readFn
+	cache  *cache
+	read   readFn
+	meta   meta
+	close  func()
+	closed bool
+	// The pages pinned by the Reader, which holds a page once for every time it is pinned.
+	pins map[int64][]*[PageSize]byte
 }
 
-func newReader(callbackRead readFn) *Reader {
-	return &Reader{make(map[int64][]byte), callbackRead}
+func newReader(c *cache, callbackRead readFn, m meta) *Reader {
+	return &Reader{cache: c, read: callbackRead, meta: m, pins: make(map[int64][]*[PageSize]byte)}
 }
 
-func (r *Reader) Read(off int64) ([]byte, error) {
-	if page, ok := r.pages[off]; ok {
-		return page, nil
+// Returns the offset of the root page of the database, which is 0 for an empty database.
+func (r *Reader) Root() int64 {
+	return r.meta.root
+}
+
+// Returns the id of the transaction that committed the version of the database read by r.
+func (r *Reader) TxID() uint64 {
+	return r.meta.txid
+}
+
+// Returns the page stored at off.
+//
+// Returns a *PageError wrapping ErrUnaligned, ErrOutOfRange or ErrShortRead if off is not the offset
+// of a page or the page can't be read completely and ErrClosed if r has been closed.
+func (r *Reader) ReadPage(off int64) ([PageSize]byte, error) {
+	if r.closed {
+		return [PageSize]byte{}, ErrClosed
+	}
+	if err := checkOffset("read", off, r.meta.end()); err != nil {
+		return [PageSize]byte{}, err
+	}
+	return r.cache.read(off, r.read)
+}
+
+// Returns the page stored at off without copying it. The page is pinned in the page cache and not
+// evicted until it is unpinned by Unpin or r is closed. The page must not be modified.
+//
+// Returns the errors of ReadPage and ErrCacheFull if all pages of the cache are pinned.
+func (r *Reader) Pin(off int64) (*[PageSize]byte, error) {
+	if r.closed {
+		return nil, ErrClosed
+	}
+	if err := checkOffset("read", off, r.meta.end()); err != nil {
+		return nil, err
 	}
 
-	page, err := r.read(off)
+	page, err := r.cache.pin(off, r.read)
 	if err != nil {
 		return nil, err
 	}
-	r.pages[off] = page
+	r.pins[off] = append(r.pins[off], page)
 	return page, nil
 }
+
+// Unpins the page at off once for every time it was pinned by Pin.
+func (r *Reader) Unpin(off int64) {
+	pages := r.pins[off]
+	if len(pages) == 0 {
+		return
+	}
+	page := pages[len(pages)-1]
+	if pages = pages[:len(pages)-1]; len(pages) == 0 {
+		delete(r.pins, off)
+	} else {
+		r.pins[off] = pages
+	}
+	r.cache.unpin(off, page)
+}
+
+// Closes r and releases its snapshot of the database, which allows the pages only reachable by it to
+// be reused. r can't be used anymore after Close returned.
+func (r *Reader) Close() error {
+	if r.closed {
+		return ErrClosed
+	}
+	r.closed = true
+	for off, pages := range r.pins {
+		for _, page := range pages {
+			r.cache.unpin(off, page)
+		}
+	}
+	r.pins = nil
+	if r.close != nil {
+		r.close()
+	}
+	return nil
+}
+
+// Returns ErrReadOnly.
+func (r *Reader) Alloc([PageSize]byte) (int64, error) {
+	return 0, ErrReadOnly
+}
+
+// Returns ErrReadOnly.
+func (r *Reader) Free(int64) error {
+	return ErrReadOnly
+}
+
+// Closes r.
+func (r *Reader) Commit() error {
+	return r.Close()
+}
+
+// Closes r.
+func (r *Reader) Abort() error {
+	return r.Close()
+}
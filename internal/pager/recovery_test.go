@@ -0,0 +1,264 @@
+package pager_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+var errCrash = errors.New("crash")
+
+// The disk shared by the files of a database, which crashes at its limit-th write or barrier. The
+// write the disk crashes at is torn and all following writes and barriers fail.
+type crashDisk struct {
+	ops, limit int
+}
+
+// Counts an operation and reports weither it happens before the crash.
+func (d *crashDisk) op() (ok, crash bool) {
+	d.ops++
+	return d.ops < d.limit, d.ops == d.limit
+}
+
+// An atomic.ReadWriterAt on a crashDisk, which keeps the state of its data as of its last barrier.
+type crashFile struct {
+	disk    *crashDisk
+	data    []byte
+	durable []byte
+}
+
+func (f *crashFile) ReadAt(b []byte, off int64) (int, error) {
+	return (&memFile{data: f.data}).ReadAt(b, off)
+}
+
+func (f *crashFile) WriteAt(b []byte, off int64) (int, error) {
+	ok, crash := f.disk.op()
+	switch {
+	case crash:
+		b = b[:len(b)/2]
+	case !ok:
+		return 0, errCrash
+	}
+
+	m := &memFile{data: f.data}
+	n, _ := m.WriteAt(b, off)
+	f.data = m.data
+	if crash {
+		return n, errCrash
+	}
+	return n, nil
+}
+
+func (f *crashFile) Commit() error {
+	if ok, _ := f.disk.op(); !ok {
+		return errCrash
+	}
+	f.durable = slices.Clone(f.data)
+	return nil
+}
+
+func (f *crashFile) Abort() error { return nil }
+
+var quietLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// Sets the values of n keys to the value of round in a single transaction.
+func setRound(p *pager.Pager, round, n int) error {
+	w, _ := p.NewWriter()
+	tr := tree.New(w, w.Root())
+	v := fmt.Appendf(nil, "value%06d", round)
+	for i := range n {
+		if err := tr.Set(fmt.Appendf(nil, "key%06d", i), v); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	w.SetRoot(tr.Root())
+	return w.Commit()
+}
+
+// Runs a workload of several transactions on a database with features crashing at the limit-th
+// operation after its setup. Returns the files of the database and the log as of the crash and as
+// of their last barrier, the last round that was committed successfully and the number of
+// operations.
+func runCrash(t *testing.T, wal bool, features []pager.Option, limit int) (written, durable [2][]byte,
+	done int, ops int,
+) {
+	t.Helper()
+	disk := &crashDisk{limit: math.MaxInt}
+	f, log := &crashFile{disk: disk}, &crashFile{disk: disk}
+	opts := append([]pager.Option{pager.Logger(quietLogger)}, features...)
+	if wal {
+		opts = append(opts, pager.WAL(log))
+	}
+
+	p, err := pager.Open(f, opts...)
+	if err == nil {
+		err = setRound(p, 0, 300)
+	}
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	setup := disk.ops
+	disk.limit = setup + limit
+
+	for round := 1; round <= 4 && err == nil; round++ {
+		if round == 3 {
+			if err = p.Checkpoint(); err != nil {
+				break
+			}
+		}
+		if err = setRound(p, round, 300); err == nil {
+			done = round
+		}
+	}
+	if err != nil && !errors.Is(err, errCrash) {
+		t.Fatalf("want crash, got %v", err)
+	}
+	return [2][]byte{f.data, log.data}, [2][]byte{f.durable, log.durable}, done, disk.ops - setup
+}
+
+func TestOpen_crash(t *testing.T) {
+	configs := []struct {
+		name     string
+		features []pager.Option
+	}{
+		{"plain", nil},
+		{"sealed", []pager.Option{pager.Checksums(), pager.Encryption(testKey)}},
+		{"compressed", []pager.Option{pager.Compression(), pager.Checksums()}},
+	}
+	for _, wal := range []bool{false, true} {
+		for _, c := range configs {
+			testCrash(t, wal, c.name, c.features)
+		}
+	}
+}
+
+func testCrash(t *testing.T, wal bool, config string, features []pager.Option) {
+	_, _, _, total := runCrash(t, wal, features, 1<<30)
+	for limit := 1; limit <= total; limit++ {
+		written, durable, done, _ := runCrash(t, wal, features, limit)
+		for name, files := range map[string][2][]byte{"written": written, "durable": durable} {
+			t.Run(fmt.Sprintf("wal=%t/%s/op=%d/%s", wal, config, limit, name), func(t *testing.T) {
+				f := &memFile{data: slices.Clone(files[0])}
+				log := &memFile{data: slices.Clone(files[1])}
+				opts := append([]pager.Option{pager.Logger(quietLogger)}, features...)
+				if wal {
+					opts = append(opts, pager.WAL(log))
+				}
+				p, err := pager.Open(f, opts...)
+				if err != nil {
+					t.Fatalf("Open() failed: %v", err)
+				}
+
+				// The transaction in progress during the crash may or may not be committed.
+				round := testRoundOf(t, p)
+				if round != done && round != done+1 {
+					t.Fatalf("want round %d or %d after crash, got %d", done, done+1, round)
+				}
+				testCheckRound(t, p, round, 300)
+
+				// The recovered database is writable.
+				if err := setRound(p, 9, 300); err != nil {
+					t.Fatalf("failed to write after recovery: %v", err)
+				}
+				if p, err = pager.Open(f, opts...); err != nil {
+					t.Fatalf("Open() failed: %v", err)
+				}
+				testCheckRound(t, p, 9, 300)
+			})
+		}
+	}
+}
+
+// Returns the round the value of the first key was set in.
+func testRoundOf(t *testing.T, p *pager.Pager) int {
+	t.Helper()
+	r, _ := p.NewReader()
+	defer r.Close()
+	v, err := tree.New(r, r.Root()).Get([]byte("key000000"))
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	var round int
+	if _, err := fmt.Sscanf(string(v), "value%06d", &round); err != nil {
+		t.Fatalf("want value of a round, got %q", v)
+	}
+	return round
+}
+
+func TestOpen_recoveryLog(t *testing.T) {
+	p, f, log := newTestWALPager(t)
+	testSetRound(t, p, 0, 500)
+	testSetRound(t, p, 1, 500)
+	testSetRound(t, p, 2, 500)
+	// Cut off the meta frame of the last transaction.
+	log.data = log.data[:len(log.data)-pager.PageSize]
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	if _, err := pager.Open(f, pager.WAL(log), pager.Logger(logger)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	for _, want := range []string{
+		`msg="pager: recovered transactions from write-ahead log" from=2 to=3`,
+		`msg="pager: discarded frames of uncommitted transaction from write-ahead log"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want log to contain %q, got %s", want, buf.String())
+		}
+	}
+}
+
+// A memFile whose write barriers fail while fail is set.
+type failingFile struct {
+	*memFile
+	fail bool
+}
+
+func (f *failingFile) Commit() error {
+	if f.fail {
+		return errors.New("barrier failed")
+	}
+	return f.memFile.Commit()
+}
+
+// A failing automatic checkpoint is logged, while the transaction triggering it is committed.
+func TestWAL_failingCheckpoint(t *testing.T) {
+	f, log := &failingFile{memFile: &memFile{}}, &memFile{}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	p, err := pager.Open(f, pager.WAL(log), pager.Logger(logger))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	f.fail = true
+	for round := range 8 {
+		testSetRound(t, p, round, 20000)
+	}
+	if want := `msg="pager: failed to checkpoint write-ahead log"`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("want log to contain %q, got %s", want, buf.String())
+	}
+	testCheckRound(t, p, 7, 20000)
+
+	// The checkpoint is retried by the following commits.
+	f.fail = false
+	commits := f.commits
+	testSetRound(t, p, 8, 20000)
+	if f.commits == commits {
+		t.Fatal("want log to be checkpointed into the database")
+	}
+	if p, err = pager.Open(f, pager.WAL(log)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 8, 20000)
+}
@@ -0,0 +1,74 @@
+package pager
+
+import (
+	"fmt"
+
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+// Copies the current version of the database into the empty dst, which is created with the features
+// of p and the ones requested by opts, like Checksums or Encryption. Encryption with another key
+// rotates the key of an encrypted database and Compression compresses its pages. All other options
+// are ignored. Free pages are not copied but zeroed.
+//
+// Rewrite blocks until the active Writer is committed or aborted and holds back new Writers until it
+// returned, while Readers continue.
+func (p *Pager) Rewrite(dst atomic.ReadWriterAt, opts ...Option) error {
+	p.writer.Lock()
+	defer p.writer.Unlock()
+
+	q := &Pager{rw: dst, features: p.flags, key: p.key}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if err := q.setFlags(q.features); err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	m := p.meta
+	p.mu.RUnlock()
+
+	free, _, err := p.readFreeList(m.freeList)
+	if err != nil {
+		return err
+	}
+	isFree := make(set[int64], len(free))
+	for _, off := range free {
+		isFree[off] = struct{}{}
+	}
+
+	if err := q.write(0, q.header()); err != nil {
+		return err
+	}
+	read := p.snapshot(m.txid)
+	for off := int64(firstPage); off < m.end(); off += PageSize {
+		var page [PageSize]byte
+		if _, ok := isFree[off]; !ok {
+			if page, err = read(off); err != nil {
+				return err
+			}
+		}
+		if err := q.write(off, page); err != nil {
+			return err
+		}
+	}
+	if err := q.writeMap(m.pages); err != nil {
+		return err
+	}
+	if err := dst.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to rewrite database: %w", err)
+	}
+
+	// Both meta pages describe the current version, which makes the copy independent of the
+	// previous one.
+	for _, off := range []int64{PageSize, 2 * PageSize} {
+		if err := q.write(off, q.encodeMeta(m)); err != nil {
+			return err
+		}
+	}
+	if err := dst.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to rewrite meta pages: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var ErrChecksumMismatch = errors.New("pager: page checksum mismatch")
+
+const (
+	// Every page carries a checksum.
+	flagChecksums uint32 = 1 << iota
+	// Every page except the header is encrypted.
+	flagEncrypted
+	// Pages behind the meta pages are compressed and stored in records (see compress.go).
+	flagCompressed
+
+	knownFlags = flagChecksums | flagEncrypted | flagCompressed
+)
+
+/*
+The offset of a page is its logical address, which is always a multiple of PageSize and used as
+pointer by the tree. Pages are stored in slots, which hold the page followed by a trailer with the
+data of the features enabled by the flags of the database:
+
+	Description | Page     | Seal | Checksum
+	------------+----------+------+---------
+	Size in B   | PageSize | 28   | 4
+
+If the database is encrypted, Page is sealed and followed by the tag and nonce it was sealed with
+(see crypt.go). Checksum is only present if the database has the checksums flag set and holds the
+CRC-32C of the offset of the page followed by all other fields of the slot, which detects torn and
+misdirected writes as well as corruption. The slot of the page at off is stored at off / PageSize *
+SlotSize, so that the slots of a database without any features are the pages themselves.
+
+The slots of the pages of a compressed database are replaced by records of variable size, which are
+located through a page map (see compress.go). Only the header and the meta pages keep their slots.
+
+The features of a database are fixed when it is created. Rewrite copies a database into a new one
+with different features.
+*/
+
+const slotChecksumSize = 4
+
+// Stores a checksum with every page of a new database, which is verified whenever the page is read.
+// Existing databases keep their features, see Rewrite for enabling checksums for them.
+func Checksums() Option {
+	return func(p *Pager) { p.features |= flagChecksums }
+}
+
+// Sets the flags of the database and derives the size of its slots from them. Returns
+// ErrInvalidKey if the database is encrypted and p has no valid key and ErrNotEncrypted if p has a
+// key for a database that isn't encrypted.
+func (p *Pager) setFlags(flags uint32) error {
+	p.flags = flags
+	p.cipher = nil
+	switch {
+	case flags&flagEncrypted != 0 && p.key == nil:
+		return fmt.Errorf("%w: database is encrypted", ErrInvalidKey)
+	case flags&flagEncrypted != 0:
+		var err error
+		if p.cipher, err = newPageCipher(p.key); err != nil {
+			return err
+		}
+	case p.key != nil:
+		return ErrNotEncrypted
+	}
+
+	p.slotSize = PageSize + p.cipher.overhead()
+	if flags&flagChecksums != 0 {
+		p.slotSize += slotChecksumSize
+	}
+	p.pages = nil
+	if flags&flagCompressed != 0 {
+		p.pages = newPageMap(p.pos(firstPage))
+	}
+	return nil
+}
+
+// Returns the position of the slot of the page at off inside rw.
+func (p *Pager) pos(off int64) int64 {
+	return off / PageSize * p.slotSize
+}
+
+// Reads the page at off from rw or its memory mapping, verifies its checksum and decrypts it.
+func (p *Pager) read(off int64) ([PageSize]byte, error) {
+	var page [PageSize]byte
+	if p.pages != nil && off >= firstPage {
+		return p.readRecord(off, p.pages.lookup(off/PageSize))
+	}
+	slot := make([]byte, p.slotSize)
+	if err := p.readAt(off, slot, p.pos(off)); err != nil {
+		return page, err
+	}
+
+	if p.flags&flagChecksums != 0 {
+		n := len(slot) - slotChecksumSize
+		if binary.LittleEndian.Uint32(slot[n:]) != slotChecksum(off, slot[:n]) {
+			return page, &PageError{Op: "read", Off: off, Err: ErrChecksumMismatch}
+		}
+	}
+	// The header is never encrypted.
+	if p.cipher != nil && off != 0 {
+		return p.cipher.open(off, slot)
+	}
+	copy(page[:], slot)
+	return page, nil
+}
+
+// Reads b, which belongs to the page at off, from pos in rw or its memory mapping.
+func (p *Pager) readAt(off int64, b []byte, pos int64) error {
+	if p.mapping != nil && p.mapping.read(pos, b) {
+		return nil
+	}
+	n, err := p.rw.ReadAt(b, pos)
+	switch {
+	case n == len(b):
+		return nil
+	case err == nil || errors.Is(err, io.EOF):
+		return &PageError{Op: "read", Off: off, Err: ErrShortRead}
+	default:
+		return &PageError{Op: "read", Off: off, Err: err}
+	}
+}
+
+// Writes page into the slot of the page at off. Pages of a compressed database are written into a
+// new record, which only replaces the previous one once the page map is committed.
+func (p *Pager) write(off int64, page [PageSize]byte) error {
+	if p.pages != nil && off >= firstPage {
+		return p.writeRecord(0, int(off/PageSize), off, page)
+	}
+	slot := make([]byte, p.slotSize)
+	if p.cipher != nil && off != 0 {
+		p.cipher.seal(slot[:PageSize+p.cipher.overhead()], off, page[:])
+	} else {
+		copy(slot, page[:])
+	}
+	if p.flags&flagChecksums != 0 {
+		n := len(slot) - slotChecksumSize
+		binary.LittleEndian.PutUint32(slot[n:], slotChecksum(off, slot[:n]))
+	}
+	if _, err := p.rw.WriteAt(slot, p.pos(off)); err != nil {
+		return &PageError{Op: "write", Off: off, Err: err}
+	}
+	return nil
+}
+
+func slotChecksum(off int64, slot []byte) uint32 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(off)) // #nosec G115
+	return crc32.Update(crc32.Checksum(b[:], castagnoli), castagnoli, slot)
+}
@@ -0,0 +1,108 @@
+package pager_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// The size of a slot of a database with checksums.
+const checksumSlotSize = pager.PageSize + 4
+
+func TestChecksums(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f, pager.Checksums(), pager.CacheSize(0))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 2000)
+	if len(f.data)%checksumSlotSize != 0 {
+		t.Fatalf("want file of whole slots, got %d bytes", len(f.data))
+	}
+
+	r, _ := p.NewReader()
+	defer r.Close()
+	off := r.Root()
+	pos := off / pager.PageSize * checksumSlotSize
+
+	tests := []struct {
+		name string
+		pos  int64
+	}{
+		{"page", pos + 100},
+		{"checksum", pos + pager.PageSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.data[tt.pos] ^= 0xff
+			defer func() { f.data[tt.pos] ^= 0xff }()
+
+			_, err := r.ReadPage(off)
+			var pageErr *pager.PageError
+			if !errors.Is(err, pager.ErrChecksumMismatch) || !errors.As(err, &pageErr) ||
+				pageErr.Off != off {
+				t.Fatalf("want *PageError wrapping ErrChecksumMismatch for page %d, got %v", off, err)
+			}
+			if _, err := tree.New(r, r.Root()).Get([]byte("key000000")); !errors.Is(err,
+				pager.ErrChecksumMismatch) {
+				t.Fatalf("want tree to fail with ErrChecksumMismatch, got %v", err)
+			}
+		})
+	}
+
+	// Checksums are a feature of the database rather than of the Pager.
+	if p, err = pager.Open(f); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 0, 2000)
+}
+
+func TestChecksums_tornMeta(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f, pager.Checksums())
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 100)
+	testSetRound(t, p, 1, 100)
+	// The last transaction wrote its meta page onto page 2, of which only the checksum is torn.
+	f.data[2*checksumSlotSize+pager.PageSize] ^= 0xff
+
+	if p, err = pager.Open(f, pager.Logger(quietLogger)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 0, 100)
+}
+
+func TestPager_Rewrite(t *testing.T) {
+	p, f := newTestPager(t)
+	testSetRound(t, p, 0, 2000)
+	// Free the pages of round 0, which are zeroed by the rewrite.
+	testSetRound(t, p, 1, 2000)
+	size := len(f.data)
+
+	dst := &memFile{}
+	if err := p.Rewrite(dst, pager.Checksums()); err != nil {
+		t.Fatalf("Rewrite() failed: %v", err)
+	}
+	if want := size / pager.PageSize * checksumSlotSize; len(dst.data) != want {
+		t.Fatalf("want %d bytes, got %d", want, len(dst.data))
+	}
+
+	q, err := pager.Open(dst)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, q, 1, 2000)
+	testSetRound(t, q, 2, 2000)
+	if q, err = pager.Open(dst); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, q, 2, 2000)
+
+	// The source is still usable.
+	testSetRound(t, p, 3, 2000)
+	testCheckRound(t, p, 3, 2000)
+}
@@ -0,0 +1,72 @@
+package pager
+
+/*
+Freed pages are reused by following transactions, but the database never shrinks on its own. A
+vacuum returns the space of free pages to the file system in steps, each of which is a regular
+transaction, so that Readers and Writers continue in between:
+
+ 1. The pages stored at or behind VacuumLimit, which is where the database would end if it had no
+    free pages, are copied onto free pages in front of it. Since Alloc reuses the lowest free
+    offsets first, the copies end up in front of the limit as long as there are enough free pages.
+    Moving the pages and updating the pages referencing them is up to the owner of the pages (see
+    tree.Tree.Relocate).
+ 2. The Writer is committed after calling Shrink, which removes the free pages at the end of the
+    database from the free list and truncates rw once the new meta page is durable.
+
+The pages freed by a step only become free once no Reader of a previous version is open anymore,
+which is why the end of the database is only trimmed by following steps. In WAL mode, rw is
+truncated by the next checkpoint.
+
+The records of a compressed database are placed into the first free space large enough, but never
+moved on their own. Its file is truncated behind the last record still in use.
+*/
+
+// Returns the offset the database would end at if it had no free pages. The pages stored at or
+// behind the limit have to be relocated in front of it before the database can shrink.
+func (w *Writer) VacuumLimit() int64 {
+	free := len(w.freelist) + len(w.pending) + len(w.freeListPages)
+	return max(firstPage, w.nextPage-int64(free)*w.pageSize)
+}
+
+// Marks w to shrink the database by the free pages at its end once it is committed. rw is
+// truncated accordingly if it implements Truncate like *os.File.
+func (w *Writer) Shrink() {
+	w.shrink = true
+}
+
+// Removes the free pages at the end of the database from the free list of w.
+func (w *Writer) trim() {
+	for len(w.freelist) > 0 && w.freelist[0] == w.nextPage-w.pageSize {
+		delete(w.free, w.freelist[0])
+		w.freelist = w.freelist[1:]
+		w.nextPage -= w.pageSize
+	}
+}
+
+// Truncates rw behind the last page or record of the current version of the database. Failures are
+// only logged, since the database stays valid, and truncating is retried by the next shrinking
+// commit or checkpoint. p.mu has to be held.
+func (p *Pager) truncate() {
+	rw, ok := p.rw.(interface{ Truncate(size int64) error })
+	if !ok {
+		p.shrink = false
+		return
+	}
+
+	size := p.pos(p.meta.end())
+	if p.pages != nil {
+		size = p.pages.size()
+	}
+	if p.mapping != nil {
+		// Reads behind the end of a file fault, which is why the mapping is recreated on demand.
+		if err := p.mapping.close(); err != nil {
+			p.log.Warn("pager: failed to unmap database", "error", err)
+			return
+		}
+	}
+	if err := rw.Truncate(size); err != nil {
+		p.log.Warn("pager: failed to truncate database", "size", size, "error", err)
+		return
+	}
+	p.shrink = false
+}
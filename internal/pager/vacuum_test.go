@@ -0,0 +1,166 @@
+package pager_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+)
+
+// Allocates n pages in a single transaction, frees all but the first keep of them in another one
+// and returns the offsets of the pages kept.
+func testFreeTail(t *testing.T, p *pager.Pager, n, keep int) []int64 {
+	t.Helper()
+	w, _ := p.NewWriter()
+	var offs []int64
+	for i := range n {
+		off, _ := w.Alloc(testPage(fmt.Sprint(i)))
+		offs = append(offs, off)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	w, _ = p.NewWriter()
+	for _, off := range offs[keep:] {
+		if err := w.Free(off); err != nil {
+			t.Fatalf("Free() failed: %v", err)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	return offs[:keep]
+}
+
+func testShrink(t *testing.T, p *pager.Pager) {
+	t.Helper()
+	w, _ := p.NewWriter()
+	w.Shrink()
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+}
+
+func TestWriter_Shrink(t *testing.T) {
+	p, f := newTestPager(t)
+	kept := testFreeTail(t, p, 100, 10)
+	size := len(f.data)
+
+	w, _ := p.NewWriter()
+	if limit, want := w.VacuumLimit(), kept[len(kept)-1]+pager.PageSize; limit != want {
+		t.Fatalf("want vacuum limit %d behind the kept pages, got %d", want, limit)
+	}
+	w.Abort()
+
+	testShrink(t, p)
+	// The new free list takes up another page.
+	if want := int(kept[len(kept)-1]) + 2*pager.PageSize; len(f.data) != want {
+		t.Fatalf("want database of %d bytes, got %d of previously %d", want, len(f.data), size)
+	}
+
+	p, err := pager.Open(f)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	r, _ := p.NewReader()
+	defer r.Close()
+	for i, off := range kept {
+		if page, err := r.ReadPage(off); err != nil || page != testPage(fmt.Sprint(i)) {
+			t.Fatalf("ReadPage(%d) = %q, %v, want %q", off, page[:1], err, fmt.Sprint(i))
+		}
+	}
+
+	// The database grows again from its new end.
+	w, _ = p.NewWriter()
+	if off, _ := w.Alloc(testPage("a")); off != int64(len(f.data)) {
+		t.Fatalf("want page to be allocated at %d, got %d", len(f.data), off)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+}
+
+func TestWriter_ShrinkReader(t *testing.T) {
+	p, f := newTestPager(t)
+	w, _ := p.NewWriter()
+	for i := range 100 {
+		w.Alloc(testPage(fmt.Sprint(i)))
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	// The pages freed after r was opened may still be read by it.
+	r, _ := p.NewReader()
+	testFreeTail(t, p, 100, 10)
+	size := len(f.data)
+	testShrink(t, p)
+	if len(f.data) < size {
+		t.Fatalf("want database of %d bytes while reader is open, got %d", size, len(f.data))
+	}
+	r.Close()
+
+	testShrink(t, p)
+	if len(f.data) >= size {
+		t.Fatalf("want database of less than %d bytes, got %d", size, len(f.data))
+	}
+}
+
+func TestWriter_ShrinkWAL(t *testing.T) {
+	p, f, _ := newTestWALPager(t)
+	testFreeTail(t, p, 100, 10)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	size := len(f.data)
+
+	// The database is only truncated once the log is checkpointed.
+	testShrink(t, p)
+	if len(f.data) != size {
+		t.Fatalf("want database of %d bytes before checkpoint, got %d", size, len(f.data))
+	}
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	if len(f.data) >= size {
+		t.Fatalf("want database of less than %d bytes, got %d", size, len(f.data))
+	}
+	testSetRound(t, p, 0, 2000)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	testCheckRound(t, p, 0, 2000)
+}
+
+func TestWriter_ShrinkCompressed(t *testing.T) {
+	f := &memFile{}
+	p, err := pager.Open(f, pager.Compression())
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testSetRound(t, p, 0, 2000)
+	testFreeTail(t, p, 1000, 10)
+	size := len(f.data)
+
+	testShrink(t, p)
+	if len(f.data) >= size {
+		t.Fatalf("want database of less than %d bytes, got %d", size, len(f.data))
+	}
+	if p, err = pager.Open(f); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 0, 2000)
+}
+
+func TestWriter_ShrinkMMap(t *testing.T) {
+	p := openTestFile(t, pager.MMap(), pager.CacheSize(0))
+	testSetRound(t, p, 0, 2000)
+	testCheckRound(t, p, 0, 2000)
+	testFreeTail(t, p, 1000, 0)
+	testShrink(t, p)
+
+	// The pages written behind the truncated end are read from a new mapping.
+	testSetRound(t, p, 1, 4000)
+	testCheckRound(t, p, 1, 4000)
+}
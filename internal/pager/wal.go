@@ -0,0 +1,298 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand/v2"
+
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+/*
+In WAL mode, committed pages are appended to a write-ahead log instead of being written into the
+database. The log starts with a header identifying the current generation of the log:
+
+	Description | Magic | Salt | Checksum
+	------------+-------+------+---------
+	Size in B   | 8     | 8    | 4
+
+The header is followed by frames, each of them holding a single page written by a transaction:
+
+	Description | Salt | TxID | Off | Checksum | Page
+	------------+------+------+-----+----------+---------
+	Size in B   | 8    | 8    | 8   | 4        | PageSize
+
+Off is the offset of the page inside the database and Checksum the CRC-32C of all other fields of
+the frame. The log of an encrypted database holds sealed pages, which are followed by their tag and
+nonce like in the database (see crypt.go). The last frame of a transaction holds its meta page and
+marks the transaction as committed, which is why all frames of a transaction are written before a
+single write barrier.
+
+Frames are only valid if their Salt matches the one of the header, so that frames left behind by a
+previous generation are never mistaken for new ones. Once all frames have been copied into the
+database by a checkpoint, the log is reset by writing a header with a new salt and frames are
+appended from its start again.
+*/
+
+const (
+	walMagic = "pavowal\x00"
+
+	walMagicOff    = 0
+	walSaltOff     = walMagicOff + 8
+	walChecksumOff = walSaltOff + 8
+	walHeaderSize  = walChecksumOff + 4
+
+	frameSaltOff     = 0
+	frameTxIDOff     = frameSaltOff + 8
+	frameOffOff      = frameTxIDOff + 8
+	frameChecksumOff = frameOffOff + 8
+	framePageOff     = frameChecksumOff + 4
+
+	// The number of frames after which the log is checkpointed by the committing Writer.
+	walCheckpointFrames = 1000
+)
+
+// A wal is the write-ahead log of a Pager in WAL mode.
+type wal struct {
+	rw     atomic.ReadWriterAt
+	cipher *pageCipher
+	// The size of a frame, which depends on the cipher.
+	frameSize int64
+	salt      uint64
+	// The number of frames of the current generation.
+	frames int64
+	// The positions of the committed frames of every page in the order of their TxID.
+	index map[int64][]walFrame
+}
+
+type walFrame struct {
+	txid uint64
+	pos  int64
+}
+
+func newWAL(rw atomic.ReadWriterAt) *wal {
+	return &wal{rw: rw, frameSize: framePageOff + PageSize, index: make(map[int64][]walFrame)}
+}
+
+// Sets the cipher the pages of the log are encrypted with, which has to happen before the log is
+// used.
+func (l *wal) setCipher(c *pageCipher) {
+	l.cipher = c
+	l.frameSize = framePageOff + PageSize + c.overhead()
+}
+
+// Returns the position of the newest frame of the page at off that was written by a transaction up
+// to txid.
+func (l *wal) lookup(off int64, txid uint64) (int64, bool) {
+	frames := l.index[off]
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i].txid <= txid {
+			return frames[i].pos, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the page at off stored in the frame at pos.
+func (l *wal) readPage(off, pos int64) ([PageSize]byte, error) {
+	b := make([]byte, l.frameSize-framePageOff)
+	if n, err := l.rw.ReadAt(b, pos+framePageOff); n < len(b) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = ErrShortRead
+		}
+		return [PageSize]byte{}, fmt.Errorf("pager: failed to read frame at %d of write-ahead log: %w",
+			pos, err)
+	}
+	if l.cipher != nil {
+		return l.cipher.open(off, b)
+	}
+	return [PageSize]byte(b), nil
+}
+
+// A recovery describes the transactions recovered from the log when opening a Pager.
+type recovery struct {
+	// The TxIDs of the last transaction in the database and the last recovered one.
+	from, to uint64
+	// The number of frames of the recovered transactions.
+	frames int
+	// The number of valid frames behind the last committed transaction, which belong to a
+	// transaction that never committed.
+	discarded int
+	// Reports weither the log had no valid header and was reset.
+	reset bool
+}
+
+// Reads the log and indexes the frames of every transaction whose frames are all valid and that
+// follows the one committed before, starting with the transaction following m. Returns the meta
+// page of the last of them or m if there is none.
+//
+// Reading stops at the first frame that is torn, belongs to a previous generation of the log or
+// doesn't belong to the transaction following the last committed one. All frames from there on are
+// discarded and overwritten by the next appended frame. A log without a valid header is reset.
+func (l *wal) recover(m meta) (meta, recovery, error) {
+	rec := recovery{from: m.txid, to: m.txid}
+
+	var hdr [walHeaderSize]byte
+	if n, err := l.rw.ReadAt(hdr[:], 0); n < walHeaderSize {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return m, rec, fmt.Errorf("pager: failed to read write-ahead log header: %w", err)
+		}
+		// The salt only has to differ from the one of the previous generation.
+		rec.reset = true
+		return m, rec, l.reset(rand.Uint64()) // #nosec G404
+	}
+	if !bytes.Equal(hdr[walMagicOff:walSaltOff], []byte(walMagic)) ||
+		binary.LittleEndian.Uint32(hdr[walChecksumOff:]) !=
+			crc32.Checksum(hdr[:walChecksumOff], castagnoli) {
+		rec.reset = true
+		return m, rec, l.reset(rand.Uint64()) // #nosec G404
+	}
+	l.salt = binary.LittleEndian.Uint64(hdr[walSaltOff:])
+
+	var (
+		// The frames of the current transaction.
+		frames = make(map[int64]int64)
+		txid   uint64
+		n      int
+	)
+	for pos := int64(walHeaderSize); ; pos += l.frameSize {
+		id, off, page, err := l.readFrame(pos)
+		if err != nil {
+			return m, rec, err
+		}
+		// All frames of a transaction have the same TxID.
+		if page == nil || n > 0 && id != txid {
+			rec.discarded = n
+			return m, rec, nil
+		}
+		txid = id
+		n++
+		if off >= firstPage {
+			frames[off] = pos
+			continue
+		}
+
+		next, ok := decodeMeta(*page)
+		if !ok || next.txid != txid {
+			rec.discarded = n
+			return m, rec, nil
+		}
+		// Transactions up to m have already been copied into the database by a checkpoint that
+		// could not reset the log anymore.
+		if next.txid > m.txid {
+			if next.txid != m.txid+1 {
+				rec.discarded = n
+				return m, rec, nil
+			}
+			for off, pos := range frames {
+				l.index[off] = append(l.index[off], walFrame{txid, pos})
+			}
+			m = next
+			rec.to = next.txid
+			rec.frames += n
+		}
+		l.frames = (pos-walHeaderSize)/l.frameSize + 1
+		frames, n = make(map[int64]int64), 0
+	}
+}
+
+// Returns the TxID, the page offset and the page of the frame at pos. The page is nil if the frame
+// is torn, missing, belongs to a previous generation of the log or fails authentication.
+func (l *wal) readFrame(pos int64) (uint64, int64, *[PageSize]byte, error) {
+	frame := make([]byte, l.frameSize)
+	if n, err := l.rw.ReadAt(frame, pos); n < len(frame) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, 0, nil, fmt.Errorf("pager: failed to read frame at %d of write-ahead log: %w",
+				pos, err)
+		}
+		return 0, 0, nil, nil
+	}
+	if binary.LittleEndian.Uint64(frame[frameSaltOff:]) != l.salt ||
+		binary.LittleEndian.Uint32(frame[frameChecksumOff:]) != frameChecksum(frame) {
+		return 0, 0, nil, nil
+	}
+
+	off := int64(binary.LittleEndian.Uint64(frame[frameOffOff:])) // #nosec G115
+	page := [PageSize]byte(frame[framePageOff:])
+	if l.cipher != nil {
+		var err error
+		if page, err = l.cipher.open(off, frame[framePageOff:]); err != nil {
+			return 0, 0, nil, nil
+		}
+	}
+	return binary.LittleEndian.Uint64(frame[frameTxIDOff:]), off, &page, nil
+}
+
+// Appends the pages and the meta page m of a transaction to the log, followed by a write barrier.
+// The frames have to be added to the index by commit once append returned.
+func (l *wal) append(pages map[int64][PageSize]byte, offs []int64, m meta) error {
+	pos := walHeaderSize + l.frames*l.frameSize
+	for i, off := range offs {
+		if err := l.writeFrame(pos+int64(i)*l.frameSize, m.txid, off, pages[off]); err != nil {
+			return err
+		}
+	}
+	end := pos + int64(len(offs))*l.frameSize
+	if err := l.writeFrame(end, m.txid, m.offset(), m.encode()); err != nil {
+		return err
+	}
+	if err := l.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to commit write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Adds the frames appended for the pages at offs by the transaction txid to the index.
+func (l *wal) commit(offs []int64, txid uint64) {
+	pos := walHeaderSize + l.frames*l.frameSize
+	for i, off := range offs {
+		l.index[off] = append(l.index[off], walFrame{txid, pos + int64(i)*l.frameSize})
+	}
+	l.frames += int64(len(offs)) + 1
+}
+
+func (l *wal) writeFrame(pos int64, txid uint64, off int64, page [PageSize]byte) error {
+	frame := make([]byte, l.frameSize)
+	binary.LittleEndian.PutUint64(frame[frameSaltOff:], l.salt)
+	binary.LittleEndian.PutUint64(frame[frameTxIDOff:], txid)
+	binary.LittleEndian.PutUint64(frame[frameOffOff:], uint64(off)) // #nosec G115
+	if l.cipher != nil {
+		l.cipher.seal(frame[framePageOff:], off, page[:])
+	} else {
+		copy(frame[framePageOff:], page[:])
+	}
+	binary.LittleEndian.PutUint32(frame[frameChecksumOff:], frameChecksum(frame))
+	if _, err := l.rw.WriteAt(frame, pos); err != nil {
+		return fmt.Errorf("pager: failed to append to write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Starts a new generation of the log identified by salt, which invalidates all frames.
+func (l *wal) reset(salt uint64) error {
+	var hdr [walHeaderSize]byte
+	copy(hdr[walMagicOff:], walMagic)
+	binary.LittleEndian.PutUint64(hdr[walSaltOff:], salt)
+	binary.LittleEndian.PutUint32(hdr[walChecksumOff:],
+		crc32.Checksum(hdr[:walChecksumOff], castagnoli))
+	if _, err := l.rw.WriteAt(hdr[:], 0); err != nil {
+		return fmt.Errorf("pager: failed to reset write-ahead log: %w", err)
+	}
+	if err := l.rw.Commit(); err != nil {
+		return fmt.Errorf("pager: failed to reset write-ahead log: %w", err)
+	}
+
+	l.salt = salt
+	l.frames = 0
+	l.index = make(map[int64][]walFrame)
+	return nil
+}
+
+func frameChecksum(frame []byte) uint32 {
+	sum := crc32.Checksum(frame[:frameChecksumOff], castagnoli)
+	return crc32.Update(sum, castagnoli, frame[framePageOff:])
+}
@@ -0,0 +1,184 @@
+package pager_test
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/pager"
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+func newTestWALPager(t *testing.T) (*pager.Pager, *memFile, *memFile) {
+	t.Helper()
+	f, log := &memFile{}, &memFile{}
+	p, err := pager.Open(f, pager.WAL(log))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	f.log, log.log = nil, nil
+	return p, f, log
+}
+
+// Sets the values of n keys to the value of round in a single transaction.
+func testSetRound(t *testing.T, p *pager.Pager, round, n int) {
+	t.Helper()
+	w, _ := p.NewWriter()
+	tr := tree.New(w, w.Root())
+	for i := range n {
+		k := fmt.Appendf(nil, "key%06d", i)
+		if err := tr.Set(k, fmt.Appendf(nil, "value%06d", round)); err != nil {
+			t.Fatalf("Set(%q) failed: %v", k, err)
+		}
+	}
+	w.SetRoot(tr.Root())
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+}
+
+// Checks that p holds n keys with the value of round.
+func testCheckRound(t *testing.T, p *pager.Pager, round, n int) {
+	t.Helper()
+	r, _ := p.NewReader()
+	defer r.Close()
+	tr := tree.New(r, r.Root())
+	if rep := tr.Check(); !rep.OK() || rep.Keys != n {
+		t.Fatalf("want %d keys without violations, got %s", n, rep)
+	}
+	want := fmt.Appendf(nil, "value%06d", round)
+	for i := range n {
+		k := fmt.Appendf(nil, "key%06d", i)
+		if v, err := tr.Get(k); err != nil || !bytes.Equal(v, want) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", k, v, err, want)
+		}
+	}
+}
+
+func TestWAL_commit(t *testing.T) {
+	p, f, log := newTestWALPager(t)
+
+	w, _ := p.NewWriter()
+	var offs []int64
+	for i := range 3 {
+		off, _ := w.Alloc(testPage(fmt.Sprint(i)))
+		offs = append(offs, off)
+	}
+	w.SetRoot(offs[0])
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+
+	if len(f.log) != 0 {
+		t.Fatalf("want no writes into the database, got %v", f.log)
+	}
+	// Three pages and the meta page followed by a single barrier.
+	if len(log.log) != 5 || slices.Index(log.log, -1) != 4 {
+		t.Fatalf("want four frames and a single barrier, got %v", log.log)
+	}
+
+	r, _ := p.NewReader()
+	defer r.Close()
+	if r.Root() != offs[0] {
+		t.Fatalf("want root %d, got %d", offs[0], r.Root())
+	}
+	for i, off := range offs {
+		if page, err := r.ReadPage(off); err != nil || page != testPage(fmt.Sprint(i)) {
+			t.Fatalf("ReadPage(%d) = %q, %v, want %q", off, page[:1], err, fmt.Sprint(i))
+		}
+	}
+}
+
+func TestWAL_reopen(t *testing.T) {
+	p, f, log := newTestWALPager(t)
+	for round := range 5 {
+		testSetRound(t, p, round, 500)
+	}
+
+	p, err := pager.Open(f, pager.WAL(log))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 4, 500)
+
+	// The recovered log is appended to.
+	testSetRound(t, p, 5, 500)
+	if p, err = pager.Open(f, pager.WAL(log)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 5, 500)
+}
+
+func TestWAL_checkpoint(t *testing.T) {
+	p, f, log := newTestWALPager(t)
+	testSetRound(t, p, 0, 500)
+
+	// A snapshot stays readable across the checkpoint and the reset of the log.
+	r, _ := p.NewReader()
+	defer r.Close()
+	testSetRound(t, p, 1, 500)
+	if err := p.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() failed: %v", err)
+	}
+	testSetRound(t, p, 2, 500)
+
+	tr := tree.New(r, r.Root())
+	if v, err := tr.Get([]byte("key000000")); err != nil || string(v) != "value000000" {
+		t.Fatalf("want snapshot to read value of round 0, got %q, %v", v, err)
+	}
+
+	// The database alone holds all transactions up to the checkpoint.
+	p, err := pager.Open(&memFile{data: slices.Clone(f.data)})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 1, 500)
+
+	if p, err = pager.Open(f, pager.WAL(log)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 2, 500)
+}
+
+func TestWAL_autoCheckpoint(t *testing.T) {
+	p, f, log := newTestWALPager(t)
+	for round := range 20 {
+		testSetRound(t, p, round, 2000)
+	}
+	if f.commits == 0 {
+		t.Fatal("want log to be checkpointed into the database")
+	}
+
+	p, err := pager.Open(f, pager.WAL(log))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 19, 2000)
+}
+
+func TestWAL_tornFrame(t *testing.T) {
+	p, f, log := newTestWALPager(t)
+	testSetRound(t, p, 0, 500)
+	size := len(log.data)
+	testSetRound(t, p, 1, 500)
+
+	// The meta frame of the last transaction is torn.
+	log.data = log.data[:len(log.data)-100]
+	p, err := pager.Open(f, pager.WAL(log))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 0, 500)
+
+	// The frames of the discarded transaction are overwritten.
+	testSetRound(t, p, 2, 500)
+	if p, err = pager.Open(f, pager.WAL(log)); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	testCheckRound(t, p, 2, 500)
+	if len(log.data) > 2*size {
+		t.Fatalf("want frames of discarded transaction to be overwritten, got log size %d",
+			len(log.data))
+	}
+}
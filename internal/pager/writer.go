@@ -1,60 +1,228 @@
 package pager
 
+import (
+	"cmp"
+	"slices"
+)
+
+// A Writer is a write transaction on the pages of a database. All pages allocated by a Writer are
+// kept in memory and only written once it is committed.
+//
+// A Writer implements the pager expected by tree.Tree.
 type Writer struct {
-	freelist set[int64]
-	freed    set[int64]
-	new      map[int64][]byte
+	// The offsets of the pages free to be allocated in descending order.
+	freelist []int64
+	free     set[int64]
+	// The previously committed pages freed by w, which only become free once w is committed.
+	freed set[int64]
+	// The pages freed by previous transactions which may still be read by open Readers.
+	pending []int64
+	// The pages of the committed free list, which are freed once w is committed.
+	freeListPages []int64
+	// The pages of pending and freeListPages, which are already free and can't be freed by w.
+	reserved set[int64]
+
+	new      map[int64][PageSize]byte
+	root     int64
 	nextPage int64
 	pageSize int64
+	done     bool
 	commit   commitFn
+	abort    abortFn
+	// Set by Shrink to return the free pages at the end of the database to the file system.
+	shrink bool
+
 	*Reader
 }
 
-func newWriter(r *Reader, freelist set[int64], nextPage int64, commitCallback commitFn) *Writer {
+func newWriter(
+	r *Reader,
+	freelist []int64,
+	pending []int64,
+	freeListPages []int64,
+	commitCallback commitFn,
+	abortCallback abortFn,
+) *Writer {
+	free := make(set[int64], len(freelist))
+	for _, off := range freelist {
+		free[off] = struct{}{}
+	}
+	reserved := make(set[int64], len(pending)+len(freeListPages))
+	for _, off := range slices.Concat(pending, freeListPages) {
+		reserved[off] = struct{}{}
+	}
+
 	return &Writer{
 		Reader: r,
 
 		freelist: freelist,
+		free:     free,
 		freed:    make(set[int64]),
-		new:      make(map[int64][]byte),
+		new:      make(map[int64][PageSize]byte),
+
+		pending:       pending,
+		freeListPages: freeListPages,
+		reserved:      reserved,
 
 		commit:   commitCallback,
-		nextPage: nextPage,
+		abort:    abortCallback,
+		root:     r.meta.root,
+		nextPage: r.meta.end(),
+		pageSize: PageSize,
 	}
 }
 
-func (w *Writer) Alloc(d []byte) int64 {
-	off := w.nextPage
-	switch {
-	case len(w.freed) > 0:
-		for off := range w.freed {
-			delete(w.freed, off)
-			break
-		}
-	case len(w.freelist) > 0:
-		for off := range w.freelist {
-			delete(w.freelist, off)
-			break
-		}
-	default:
-		w.nextPage += w.pageSize
+// Returns the offset of the root page of the database as set by w.
+func (w *Writer) Root() int64 {
+	return w.root
+}
+
+// Sets the offset of the root page of the database, which becomes durable once w is committed.
+func (w *Writer) SetRoot(root int64) {
+	w.root = root
+}
+
+// Returns the page stored at off including the pages allocated by w.
+func (w *Writer) ReadPage(off int64) ([PageSize]byte, error) {
+	if page, ok := w.new[off]; ok {
+		return page, nil
 	}
+	return w.Reader.ReadPage(off)
+}
+
+// Returns the page stored at off without copying it, like Reader.Pin. Pages allocated by w are not
+// cached and don't have to be unpinned. All pages still pinned are unpinned once w is committed or
+// aborted.
+func (w *Writer) Pin(off int64) (*[PageSize]byte, error) {
+	if page, ok := w.new[off]; ok {
+		return &page, nil
+	}
+	return w.Reader.Pin(off)
+}
+
+// Allocates a page holding d and returns its offset. Free pages are reused before the database is
+// grown, starting with the lowest offset.
+func (w *Writer) Alloc(d [PageSize]byte) (int64, error) {
+	if w.done {
+		return 0, ErrTxDone
+	}
+
+	off := w.take()
 	w.new[off] = d
+	return off, nil
+}
+
+// Returns the offset of a page that is neither in use nor part of the committed version of the
+// database and removes it from the free list of w.
+func (w *Writer) take() int64 {
+	if l := len(w.freelist); l > 0 {
+		off := w.freelist[l-1]
+		w.freelist = w.freelist[:l-1]
+		delete(w.free, off)
+		return off
+	}
+
+	off := w.nextPage
+	w.nextPage += w.pageSize
 	return off
 }
 
-func (w *Writer) Free(off int64) {
-	if _, ok := w.freelist[off]; ok {
-		return
+// Frees the page at off. A page allocated by w is free to be allocated again right away, while a
+// previously committed page only becomes free once w is committed.
+//
+// Returns a *PageError wrapping ErrUnaligned or ErrOutOfRange if off is not the offset of a page and
+// ErrDoubleFree if the page is already free, including pages freed by previous transactions that
+// may still be read and the pages of the committed free list.
+func (w *Writer) Free(off int64) error {
+	if w.done {
+		return ErrTxDone
+	}
+	if err := checkOffset("free", off, w.nextPage); err != nil {
+		return err
+	}
+	if _, ok := w.free[off]; ok {
+		return &PageError{Op: "free", Off: off, Err: ErrDoubleFree}
 	}
 	if _, ok := w.freed[off]; ok {
-		return
+		return &PageError{Op: "free", Off: off, Err: ErrDoubleFree}
+	}
+	if _, ok := w.reserved[off]; ok {
+		return &PageError{Op: "free", Off: off, Err: ErrDoubleFree}
+	}
+
+	if _, ok := w.new[off]; ok {
+		delete(w.new, off)
+		w.pushFree(off)
+		return nil
 	}
 	w.freed[off] = struct{}{}
+	return nil
 }
 
+// Writes all pages allocated by w together with a new free list and makes the pages freed by w
+// available to following Writers. w can't be used anymore after Commit returned, even if it failed.
 func (w *Writer) Commit() error {
-	return w.commit(w.new)
+	if w.done {
+		return ErrTxDone
+	}
+	w.done = true
+	defer w.abort()
+	defer w.Reader.Close()
+
+	if w.shrink {
+		w.trim()
+	}
+	// The new free list can only be stored on pages that are neither part of the committed version
+	// nor readable by open Readers, which are the ones still left in the free list of w.
+	n := len(w.freed) + len(w.pending) + len(w.freeListPages)
+	var freeListPages []int64
+	for len(freeListPages)*freeListCap < len(w.freelist)+n {
+		freeListPages = append(freeListPages, w.take())
+	}
+
+	freed := make([]int64, 0, len(w.freed))
+	for off := range w.freed {
+		freed = append(freed, off)
+	}
+	// Readers never read the free list, which makes its previous pages free right away.
+	w.freelist = append(w.freelist, w.freeListPages...)
+	slices.SortFunc(w.freelist, descending)
+	if w.shrink {
+		w.trim()
+	}
+
+	// The free list records all free pages, since there are no Readers after reopening.
+	all := slices.Concat(w.freelist, freed, w.pending)
+	slices.SortFunc(all, descending)
+
+	m := meta{txid: w.meta.txid + 1, root: w.root, pages: w.nextPage / w.pageSize}
+	if len(freeListPages) > 0 {
+		m.freeList = freeListPages[0]
+	}
+	for off, page := range encodeFreeList(all, freeListPages) {
+		w.new[off] = page
+	}
+	return w.commit(w.new, w.freelist, freed, freeListPages, m, w.shrink)
 }
 
-func (w *Writer) Abort() {}
+// Discards all changes of w. w can't be used anymore after Abort returned.
+func (w *Writer) Abort() error {
+	if w.done {
+		return ErrTxDone
+	}
+	w.done = true
+	w.Reader.Close()
+	w.abort()
+	return nil
+}
+
+// Inserts off into the descending free list of w.
+func (w *Writer) pushFree(off int64) {
+	i, _ := slices.BinarySearchFunc(w.freelist, off, descending)
+	w.freelist = slices.Insert(w.freelist, i, off)
+	w.free[off] = struct{}{}
+}
+
+func descending(a, b int64) int {
+	return cmp.Compare(b, a)
+}
@@ -0,0 +1,179 @@
+package tree
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+)
+
+var (
+	ErrUnsortedInput     = errors.New("tree: bulk load input is not sorted")
+	ErrDuplicateKey      = errors.New("tree: bulk load input contains duplicate key")
+	ErrInvalidFillFactor = errors.New("tree: fill factor must be greater than 0 and at most 1")
+)
+
+const defaultFillFactor = 0.9
+
+// Sets the fraction of the usable page space BulkLoad fills nodes up to. Leaving space in the nodes
+// avoids splitting them on subsequent writes. Defaults to 0.9.
+func FillFactor(f float64) Option {
+	return func(t *Tree) { t.fillFactor = f }
+}
+
+/*
+Builds a new Tree from the k-v pairs of seq bottom-up. The keys of seq have to be in strictly
+ascending order, otherwise BulkLoad fails with ErrUnsortedInput or ErrDuplicateKey.
+
+Instead of inserting every pair separately, BulkLoad fills one leaf after another up to the fill
+factor and only writes a node once it is full. The cell referencing a written node is added to the
+pointer node on the level above, which is again written once it is full, so that every page of the
+tree is written exactly once.
+
+If BulkLoad fails the pages written so far are not freed, which is why the pager transaction
+BulkLoad runs in should be aborted.
+*/
+func BulkLoad(p pager, seq iter.Seq2[[]byte, []byte], opts ...Option) (*Tree, error) {
+	t := New(p, 0, opts...)
+	if t.fillFactor <= 0 || t.fillFactor > 1 {
+		return nil, ErrInvalidFillFactor
+	}
+	b := bulkLoader{tree: t, limit: int(t.fillFactor * (PageSize - dataOff))}
+
+	// prev stays nil after an empty first key, which is why seen tracks the first key instead.
+	var prev []byte
+	var seen bool
+	var i int
+	for k, v := range seq {
+		if seen {
+			switch cmp := t.compare(prev, k); {
+			case cmp == 0:
+				return nil, fmt.Errorf("%w: pair %d", ErrDuplicateKey, i)
+			case cmp > 0:
+				return nil, fmt.Errorf("%w: pair %d", ErrUnsortedInput, i)
+			}
+		}
+		prev, seen = append(prev[:0], k...), true
+		i++
+
+		sk, kOverflow, err := t.storeKey(k)
+		if err != nil {
+			return nil, err
+		}
+		sv, vOverflow, err := t.storeVal(sk, v)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.add(0, makeFlaggedCell(sk, kOverflow, sv, vOverflow)); err != nil {
+			return nil, err
+		}
+	}
+
+	root, err := b.finish()
+	if err != nil {
+		return nil, err
+	}
+	t.root = root
+	return t, nil
+}
+
+// A bulkLoader holds the cells of the node currently being filled on every level of the tree, level
+// 0 being the leaves. The last completed node of every level is held back until the next one is
+// complete, which allows balancing the last two nodes of a level once all cells are added.
+type bulkLoader struct {
+	tree   *Tree
+	limit  int
+	levels []bulkLevel
+}
+
+type bulkLevel struct {
+	typ   PageType
+	prev  *node
+	cells [][]byte
+	size  int
+}
+
+// Returns the number of bytes of the data area the node of l would take up with cell added.
+func (l *bulkLevel) sizeWith(cell []byte) int {
+	size := l.size + 2 + len(cell)
+	if !isPrefixed(l.typ) || len(l.cells) == 0 {
+		return size
+	}
+	prefix := commonPrefix(comparableKey(l.cells[0]), comparableKey(cell))
+	return size - (len(l.cells)+1)*len(prefix) + 2 + len(prefix)
+}
+
+// Appends cell to the node currently filled on level. If the node is filled up to the limit, it is
+// completed and a new node is started.
+func (b *bulkLoader) add(level int, cell []byte) error {
+	if level == len(b.levels) {
+		kind := PointerPage
+		if level == 0 {
+			kind = LeafPage
+		}
+		b.levels = append(b.levels, bulkLevel{typ: b.tree.pageType(kind)})
+	}
+
+	l := &b.levels[level]
+	if size := l.sizeWith(cell); len(l.cells) >= 2 && (size > b.limit || size > PageSize-dataOff) {
+		full := buildNode(l.typ, l.cells)
+		if l.prev != nil {
+			if err := b.write(level, *l.prev); err != nil {
+				return err
+			}
+		}
+		// b.write may have grown b.levels, so l can't be used anymore.
+		l = &b.levels[level]
+		*l = bulkLevel{typ: l.typ, prev: &full}
+	}
+	l.cells = append(l.cells, cell)
+	l.size += 2 + len(cell)
+	return nil
+}
+
+// Writes n onto a new page and adds the cell referencing it to the level above.
+func (b *bulkLoader) write(level int, n node) error {
+	ptr, err := b.tree.pager.Alloc(n)
+	if err != nil {
+		return fmt.Errorf("tree: failed to allocate page: %w", err)
+	}
+	return b.add(level+1, b.tree.pointerCell(&n, ptr))
+}
+
+// Writes the remaining nodes of every level and returns the offset of the root page.
+func (b *bulkLoader) finish() (int64, error) {
+	for level := 0; level < len(b.levels); level++ {
+		l := b.levels[level]
+		cur := buildNode(l.typ, l.cells)
+
+		if level == len(b.levels)-1 && l.prev == nil {
+			switch {
+			case cur.N() == 0:
+				return 0, nil
+			case cur.Kind() == PointerPage && cur.N() == 1:
+				return cur.Pointer(0), nil
+			}
+			ptr, err := b.tree.pager.Alloc(cur)
+			if err != nil {
+				return 0, fmt.Errorf("tree: failed to allocate page: %w", err)
+			}
+			return ptr, nil
+		}
+
+		nodes := []node{cur}
+		if l.prev != nil {
+			nodes = []node{*l.prev, cur}
+			if cur.used() < minNodeSize {
+				nodes = balance(*l.prev, cur)
+			}
+		}
+		for _, n := range nodes {
+			if n.N() == 0 {
+				continue
+			}
+			if err := b.write(level, n); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return 0, nil
+}
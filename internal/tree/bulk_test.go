@@ -0,0 +1,144 @@
+package tree_test
+
+import (
+	"bytes"
+	"errors"
+	"iter"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// Returns an iterator over the sorted test keys from 0 to n.
+func sortedPairs(n int) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for i := range n {
+			if !yield(testKey(i), testVal(i)) {
+				return
+			}
+		}
+	}
+}
+
+func pairs(keys ...string) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for _, k := range keys {
+			if !yield([]byte(k), []byte(k)) {
+				return
+			}
+		}
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 100, 20000} {
+		for _, opts := range [][]tree.Option{nil, {tree.PrefixCompression()}} {
+			testBulkLoad(t, n, opts...)
+		}
+	}
+}
+
+func testBulkLoad(t *testing.T, n int, opts ...tree.Option) {
+	t.Helper()
+	p := newMemPager()
+	tr, err := tree.BulkLoad(p, sortedPairs(n), opts...)
+	if err != nil {
+		t.Fatalf("BulkLoad() of %d pairs failed: %v", n, err)
+	}
+
+	i := 0
+	c := tr.Cursor()
+	for ok := c.First(); ok; ok = c.Next() {
+		if !bytes.Equal(c.Key(), testKey(i)) || !bytes.Equal(c.Val(), testVal(i)) {
+			t.Fatalf("want %q at position %d, got %q", testKey(i), i, c.Key())
+		}
+		i++
+	}
+	if i != n {
+		t.Fatalf("want %d keys, got %d", n, i)
+	}
+
+	for i := range n {
+		if got, err := tr.Get(testKey(i)); err != nil || !bytes.Equal(got, testVal(i)) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", testKey(i), got, err, testVal(i))
+		}
+	}
+
+	for i := range n {
+		if err := tr.Delete(testKey(i)); err != nil {
+			t.Fatalf("Delete(%q) failed: %v", testKey(i), err)
+		}
+	}
+	if len(p.pages) != 0 {
+		t.Fatalf("want no pages left after deleting all keys, got %d", len(p.pages))
+	}
+}
+
+func TestBulkLoad_FillFactor(t *testing.T) {
+	full, half := newMemPager(), newMemPager()
+	if _, err := tree.BulkLoad(full, sortedPairs(20000), tree.FillFactor(1)); err != nil {
+		t.Fatalf("BulkLoad() failed: %v", err)
+	}
+	if _, err := tree.BulkLoad(half, sortedPairs(20000), tree.FillFactor(0.5)); err != nil {
+		t.Fatalf("BulkLoad() failed: %v", err)
+	}
+	if got, want := len(half.pages), 2*len(full.pages)*9/10; got < want {
+		t.Errorf("want at least %d pages with fill factor 0.5, got %d", want, got)
+	}
+
+	_, inserted := testTree(t, 20000)
+	if len(full.pages) >= len(inserted.pages) {
+		t.Errorf("want bulk loaded tree smaller than %d pages, got %d", len(inserted.pages), len(full.pages))
+	}
+
+	_, err := tree.BulkLoad(newMemPager(), sortedPairs(1), tree.FillFactor(0))
+	if !errors.Is(err, tree.ErrInvalidFillFactor) {
+		t.Errorf("BulkLoad() = %v, want %v", err, tree.ErrInvalidFillFactor)
+	}
+}
+
+func TestBulkLoad_invalidInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		seq     iter.Seq2[[]byte, []byte]
+		opts    []tree.Option
+		wantErr error
+	}{
+		{"unsorted", pairs("a", "c", "b"), nil, tree.ErrUnsortedInput},
+		{"duplicate", pairs("a", "b", "b"), nil, tree.ErrDuplicateKey},
+		{"duplicate empty key", pairs("", ""), nil, tree.ErrDuplicateKey},
+		{
+			"unsorted after empty key",
+			pairs("", "a"),
+			[]tree.Option{tree.KeyComparator(tree.ReverseComparator)},
+			tree.ErrUnsortedInput,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tree.BulkLoad(newMemPager(), tt.seq, tt.opts...); !errors.Is(err, tt.wantErr) {
+				t.Errorf("BulkLoad() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBulkLoad_thenWrite(t *testing.T) {
+	p := newMemPager()
+	tr, err := tree.BulkLoad(p, sortedPairs(10000), tree.FillFactor(1))
+	if err != nil {
+		t.Fatalf("BulkLoad() failed: %v", err)
+	}
+
+	for i := range 5000 {
+		j := 10000 + (i*7919)%5000
+		if err := tr.Set(testKey(j), testVal(j)); err != nil {
+			t.Fatalf("Set(%q) failed: %v", testKey(j), err)
+		}
+	}
+	for i := range 15000 {
+		if got, err := tr.Get(testKey(i)); err != nil || !bytes.Equal(got, testVal(i)) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", testKey(i), got, err, testVal(i))
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package tree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrTreeNotFound   = errors.New("tree: named tree not found")
+	ErrTreeExists     = errors.New("tree: named tree already exists")
+	ErrInvalidName    = errors.New("tree: tree name must not be empty")
+	ErrCorruptCatalog = errors.New("tree: corrupt catalog entry")
+)
+
+/*
+A Catalog hosts many named trees, like the tables and indexes of a database, inside a single file.
+The catalog itself is a Tree, called the directory, mapping the name of every tree to an entry
+structured as follows:
+
+	Description | Root | Flags | Comparator
+	------------+------+-------+-----------
+	Size in B   | 8    | 1     | ?
+
+Root is the offset of the root page of the named tree and Flags stores the options the tree was
+created with, so that it is opened with the same options again. Comparator is the name of the
+Comparator ordering the keys of the tree, which is empty for BytewiseComparator.
+
+Writing onto a named tree changes its root without touching the directory. The catalog keeps track
+of all trees it opened and writes their new roots into the directory on Sync, which therefore has to
+be called before committing the pager.
+*/
+type Catalog struct {
+	dir   *Tree
+	trees map[string]*catalogTree
+}
+
+type catalogTree struct {
+	tree *Tree
+	// The root of tree as it is stored in the directory.
+	root int64
+}
+
+const (
+	entryRootOff  = 0
+	entryFlagsOff = entryRootOff + 8
+	entryCmpOff   = entryFlagsOff + 1
+)
+
+const (
+	flagPrefixCompression byte = 1 << iota
+	flagSubtreeCounts
+)
+
+// Returns a Catalog reading and writing its pages through p with the root node of its directory
+// stored at the page root. A root of 0 denotes an empty catalog.
+func OpenCatalog(p pager, root int64) *Catalog {
+	return &Catalog{dir: New(p, root), trees: make(map[string]*catalogTree)}
+}
+
+// Returns the offset of the root page of the directory of c. The root changes with every call to
+// Create, Rename, Drop and Sync.
+func (c *Catalog) Root() int64 {
+	return c.dir.Root()
+}
+
+// Creates a new empty tree called name, which is created with opts.
+//
+// Returns ErrTreeExists if a tree called name already exists and ErrReservedComparator if opts set
+// a comparator using a name reserved for BytewiseComparator.
+func (c *Catalog) Create(name string, opts ...Option) (*Tree, error) {
+	if name == "" {
+		return nil, ErrInvalidName
+	}
+	if _, err := c.dir.Get([]byte(name)); err == nil {
+		return nil, ErrTreeExists
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+
+	t := New(c.dir.pager, 0, opts...)
+	if t.cmp.reserved() {
+		return nil, fmt.Errorf("%w: %q", ErrReservedComparator, t.cmp.Name)
+	}
+	if err := c.dir.Set([]byte(name), encodeEntry(t)); err != nil {
+		return nil, err
+	}
+	c.trees[name] = &catalogTree{tree: t}
+	return t, nil
+}
+
+// Opens the tree called name with the options it was created with. Since comparators are code they
+// can't be restored, which is why a tree created with a comparator other than BytewiseComparator has
+// to be opened by passing the same comparator using KeyComparator again. All other options of opts
+// are ignored. Opening the same tree more than once returns the same Tree.
+//
+// Returns ErrTreeNotFound if no tree called name exists and ErrComparatorMismatch if the tree was
+// created with a comparator of a different name. Returns ErrReservedComparator like Create.
+func (c *Catalog) Open(name string, opts ...Option) (*Tree, error) {
+	ct, err := c.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	o := Tree{cmp: BytewiseComparator}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.cmp.reserved() {
+		return nil, fmt.Errorf("%w: %q", ErrReservedComparator, o.cmp.Name)
+	}
+	if o.cmp.Name != ct.tree.cmp.Name {
+		return nil, fmt.Errorf("%w: %q uses %q", ErrComparatorMismatch, name, ct.tree.cmp.Name)
+	}
+	ct.tree.cmp = o.cmp
+	c.trees[name] = ct
+	return ct.tree, nil
+}
+
+// Returns the tree called name, which is either already open or decoded from its entry. The
+// comparator of a decoded tree only carries the name of the actual comparator.
+func (c *Catalog) open(name string) (*catalogTree, error) {
+	if ct, ok := c.trees[name]; ok {
+		return ct, nil
+	}
+
+	e, err := c.dir.Get([]byte(name))
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, ErrTreeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	t, err := decodeEntry(c.dir.pager, e)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", err, name)
+	}
+	return &catalogTree{tree: t, root: t.root}, nil
+}
+
+// Returns the names of all trees in c in ascending order.
+func (c *Catalog) List() ([]string, error) {
+	seq, errf := c.dir.Range(nil, nil)
+	var names []string
+	for k := range seq {
+		names = append(names, string(k))
+	}
+	if err := errf(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Renames the tree called from to to. Trees opened under the old name stay valid.
+//
+// Returns ErrTreeNotFound if no tree called from exists and ErrTreeExists if a tree called to
+// already exists.
+func (c *Catalog) Rename(from, to string) error {
+	if to == "" {
+		return ErrInvalidName
+	}
+	e, err := c.dir.Get([]byte(from))
+	if errors.Is(err, ErrKeyNotFound) {
+		return ErrTreeNotFound
+	} else if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	if _, err := c.dir.Get([]byte(to)); err == nil {
+		return ErrTreeExists
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+
+	if err := c.dir.Set([]byte(to), e); err != nil {
+		return err
+	}
+	if err := c.dir.Delete([]byte(from)); err != nil {
+		return err
+	}
+	if ct, ok := c.trees[from]; ok {
+		delete(c.trees, from)
+		c.trees[to] = ct
+	}
+	return nil
+}
+
+// Drops the tree called name and frees all of its pages. A Tree opened before is emptied and
+// becomes read only.
+//
+// Returns ErrTreeNotFound if no tree called name exists.
+func (c *Catalog) Drop(name string) error {
+	ct, err := c.open(name)
+	if err != nil {
+		return err
+	}
+	t := ct.tree
+	if t.root != 0 {
+		if err := t.free(t.root); err != nil {
+			return err
+		}
+	}
+	if err := c.dir.Delete([]byte(name)); err != nil {
+		return err
+	}
+
+	delete(c.trees, name)
+	t.root = 0
+	t.readOnly = true
+	return nil
+}
+
+// Writes the roots of all trees opened through c that changed since they were opened or last
+// synced into the directory.
+func (c *Catalog) Sync() error {
+	names := make([]string, 0, len(c.trees))
+	for name := range c.trees {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		ct := c.trees[name]
+		if ct.tree.root == ct.root {
+			continue
+		}
+		if err := c.dir.Set([]byte(name), encodeEntry(ct.tree)); err != nil {
+			return err
+		}
+		ct.root = ct.tree.root
+	}
+	return nil
+}
+
+func encodeEntry(t *Tree) []byte {
+	var cmp string
+	if !t.bytewise() {
+		cmp = t.cmp.Name
+	}
+
+	e := make([]byte, entryCmpOff+len(cmp))
+	binary.LittleEndian.PutUint64(e[entryRootOff:], uint64(t.root)) // #nosec G115
+	if t.prefixCompression {
+		e[entryFlagsOff] |= flagPrefixCompression
+	}
+	if t.subtreeCounts {
+		e[entryFlagsOff] |= flagSubtreeCounts
+	}
+	copy(e[entryCmpOff:], cmp)
+	return e
+}
+
+func decodeEntry(p pager, e []byte) (*Tree, error) {
+	if len(e) < entryCmpOff {
+		return nil, ErrCorruptCatalog
+	}
+
+	var opts []Option
+	if e[entryFlagsOff]&flagPrefixCompression != 0 {
+		opts = append(opts, PrefixCompression())
+	}
+	if e[entryFlagsOff]&flagSubtreeCounts != 0 {
+		opts = append(opts, SubtreeCounts())
+	}
+	if cmp := string(e[entryCmpOff:]); cmp != "" {
+		opts = append(opts, KeyComparator(Comparator{Name: cmp}))
+	}
+	root := int64(binary.LittleEndian.Uint64(e[entryRootOff:])) // #nosec G115
+	return New(p, root, opts...), nil
+}
+
+// Frees the node stored at ptr, all nodes of its subtree and the overflow chains they own.
+func (t *Tree) free(ptr int64) error {
+	n, err := t.read(ptr)
+	if err != nil {
+		return err
+	}
+
+	switch n.Kind() {
+	case PointerPage:
+		for i := range n.N() {
+			if err := t.free(n.Pointer(i)); err != nil {
+				return err
+			}
+		}
+	case LeafPage:
+		for i := range n.N() {
+			if err := t.freeCell(&n, i); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrInvalidPageType
+	}
+
+	if err := t.pager.Free(ptr); err != nil {
+		return fmt.Errorf("tree: failed to free page: %w", err)
+	}
+	return nil
+}
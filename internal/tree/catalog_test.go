@@ -0,0 +1,188 @@
+package tree_test
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// Returns a catalog holding the trees called names, each filled with n keys.
+func testCatalog(t *testing.T, n int, names ...string) (*tree.Catalog, *memPager) {
+	t.Helper()
+	p := newMemPager()
+	c := tree.OpenCatalog(p, 0)
+	for _, name := range names {
+		tr, err := c.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%q) failed: %v", name, err)
+		}
+		for i := range n {
+			if err := tr.Set(testKey(i), []byte(name)); err != nil {
+				t.Fatalf("Set(%q) failed: %v", testKey(i), err)
+			}
+		}
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	return c, p
+}
+
+func TestCatalog(t *testing.T) {
+	c, p := testCatalog(t, 1000, "users", "orders", "orders_by_user")
+
+	names, err := c.List()
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if want := []string{"orders", "orders_by_user", "users"}; !slices.Equal(names, want) {
+		t.Fatalf("want names %q, got %q", want, names)
+	}
+
+	// Reopening the catalog from its root only sees what was synced.
+	reopened := tree.OpenCatalog(p, c.Root())
+	for _, name := range names {
+		tr, err := reopened.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%q) failed: %v", name, err)
+		}
+		for i := range 1000 {
+			if got, err := tr.Get(testKey(i)); err != nil || !bytes.Equal(got, []byte(name)) {
+				t.Fatalf("Get(%q) from %q = %q, %v, want %q", testKey(i), name, got, err, name)
+			}
+		}
+	}
+
+	if _, err := c.Create("users"); !errors.Is(err, tree.ErrTreeExists) {
+		t.Fatalf("want ErrTreeExists creating existing tree, got %v", err)
+	}
+	if _, err := c.Open("missing"); !errors.Is(err, tree.ErrTreeNotFound) {
+		t.Fatalf("want ErrTreeNotFound opening missing tree, got %v", err)
+	}
+	if _, err := c.Create(""); !errors.Is(err, tree.ErrInvalidName) {
+		t.Fatalf("want ErrInvalidName creating unnamed tree, got %v", err)
+	}
+}
+
+func TestCatalog_Open(t *testing.T) {
+	c, p := testCatalog(t, 0, "a")
+
+	tr, err := c.Open("a")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if again, _ := c.Open("a"); again != tr {
+		t.Fatal("want opening a tree twice to return the same tree")
+	}
+
+	if err := tr.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	unsynced, err := tree.OpenCatalog(p, c.Root()).Open("a")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if _, err := unsynced.Get([]byte("k")); !errors.Is(err, tree.ErrKeyNotFound) {
+		t.Fatalf("want unsynced write to be invisible, got %v", err)
+	}
+
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	synced, _ := tree.OpenCatalog(p, c.Root()).Open("a")
+	if got, err := synced.Get([]byte("k")); err != nil || string(got) != "v" {
+		t.Fatalf("Get() = %q, %v, want %q", got, err, "v")
+	}
+}
+
+func TestCatalog_options(t *testing.T) {
+	p := newMemPager()
+	c := tree.OpenCatalog(p, 0)
+	tr, err := c.Create("compressed", tree.PrefixCompression())
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if err := tr.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+
+	before := len(p.pages)
+	reopened, err := tree.OpenCatalog(p, c.Root()).Open("compressed")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	for i := range 1000 {
+		k := append(bytes.Repeat([]byte("prefix"), 20), testKey(i)...)
+		if err := reopened.Set(k, nil); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+	// 1000 keys of 129 bytes need 17 plain leafs but fit into 2 compressed ones.
+	if got := len(p.pages) - before; got > 5 {
+		t.Fatalf("want reopened tree to be prefix compressed, got %d new pages", got)
+	}
+}
+
+func TestCatalog_Rename(t *testing.T) {
+	c, p := testCatalog(t, 100, "a", "b")
+
+	tr, _ := c.Open("a")
+	if err := tr.Set([]byte("new"), []byte("v")); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := c.Rename("a", "c"); err != nil {
+		t.Fatalf("Rename() failed: %v", err)
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+
+	reopened := tree.OpenCatalog(p, c.Root())
+	if names, _ := reopened.List(); !slices.Equal(names, []string{"b", "c"}) {
+		t.Fatalf("want names %q, got %q", []string{"b", "c"}, names)
+	}
+	renamed, err := reopened.Open("c")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if got, err := renamed.Get([]byte("new")); err != nil || string(got) != "v" {
+		t.Fatalf("Get() = %q, %v, want %q", got, err, "v")
+	}
+
+	if err := c.Rename("missing", "d"); !errors.Is(err, tree.ErrTreeNotFound) {
+		t.Fatalf("want ErrTreeNotFound, got %v", err)
+	}
+	if err := c.Rename("b", "c"); !errors.Is(err, tree.ErrTreeExists) {
+		t.Fatalf("want ErrTreeExists, got %v", err)
+	}
+}
+
+func TestCatalog_Drop(t *testing.T) {
+	c, p := testCatalog(t, 5000, "a", "b")
+	b, _ := c.Open("b")
+	if err := b.Set(largeKey(0, 3*tree.PageSize), largeKey(1, 3*tree.PageSize)); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if err := c.Drop(name); err != nil {
+			t.Fatalf("Drop(%q) failed: %v", name, err)
+		}
+	}
+	if err := c.Drop("a"); !errors.Is(err, tree.ErrTreeNotFound) {
+		t.Fatalf("want ErrTreeNotFound dropping dropped tree, got %v", err)
+	}
+	if err := b.Set([]byte("k"), []byte("v")); !errors.Is(err, tree.ErrReadOnly) {
+		t.Fatalf("want dropped tree to be read only, got %v", err)
+	}
+
+	if c.Root() != 0 || len(p.pages) != 0 {
+		t.Fatalf("want all pages freed after dropping all trees, got %d", len(p.pages))
+	}
+}
@@ -0,0 +1,344 @@
+package tree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A ViolationKind classifies a structural problem found by Tree.Check.
+type ViolationKind uint8
+
+const (
+	// The page could not be read from the pager.
+	UnreadablePage ViolationKind = iota + 1
+	// The page has a PageType that is unknown or not allowed at its position.
+	InvalidPageType
+	// The write cursor lies inside the offset table or outside the page.
+	InvalidWCursor
+	// A cell offset points outside the cell area or cells overlap.
+	InvalidOffset
+	// A cell is malformed, e.g. a pointer cell without a valid page offset.
+	InvalidCell
+	// A node that is not allowed to be empty holds no cells.
+	EmptyNode
+	// The keys of a node are not strictly ascending.
+	UnsortedKeys
+	// A key lies outside the range bounded by the separator keys of its parent.
+	InvalidSeparator
+	// A leaf is stored at a different depth than the other leafs.
+	UnequalDepth
+	// A page is reachable more than once.
+	DuplicatePage
+	// An overflow chain is broken or does not match the length stored in its cell.
+	InvalidOverflow
+	// The subtree count of a pointer cell does not match the number of keys in the subtree.
+	InvalidCount
+)
+
+var violationKinds = map[ViolationKind]string{
+	UnreadablePage:   "unreadable page",
+	InvalidPageType:  "invalid page type",
+	InvalidWCursor:   "invalid write cursor",
+	InvalidOffset:    "invalid offset",
+	InvalidCell:      "invalid cell",
+	EmptyNode:        "empty node",
+	UnsortedKeys:     "unsorted keys",
+	InvalidSeparator: "invalid separator",
+	UnequalDepth:     "unequal depth",
+	DuplicatePage:    "duplicate page",
+	InvalidOverflow:  "invalid overflow chain",
+	InvalidCount:     "invalid subtree count",
+}
+
+func (k ViolationKind) String() string {
+	if s, ok := violationKinds[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("ViolationKind(%d)", k)
+}
+
+// A Violation is a single structural problem found by Tree.Check.
+type Violation struct {
+	Kind ViolationKind
+	// The offset of the page the violation was found on.
+	Page int64
+	// The index of the offending cell or -1 if the violation concerns the page as a whole.
+	Cell int
+	Msg  string
+}
+
+func (v Violation) String() string {
+	if v.Cell < 0 {
+		return fmt.Sprintf("page %d: %s: %s", v.Page, v.Kind, v.Msg)
+	}
+	return fmt.Sprintf("page %d: cell %d: %s: %s", v.Page, v.Cell, v.Kind, v.Msg)
+}
+
+// A Report is the result of Tree.Check.
+type Report struct {
+	// The number of distinct pages reachable from the root including overflow pages.
+	Pages int
+	// The number of k-v pairs stored in the leafs.
+	Keys int
+	// The number of levels of the tree, which is 0 for an empty tree.
+	Depth      int
+	Violations []Violation
+}
+
+// Reports weither no violations were found.
+func (r *Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d pages, %d keys, depth %d, %d violations", r.Pages, r.Keys, r.Depth,
+		len(r.Violations))
+	for _, v := range r.Violations {
+		b.WriteString("\n\t")
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+// Walks every page reachable from the root of t and returns a report of all structural violations
+// found on the way. Check never panics on corrupt pages, instead pages that can't be decoded safely
+// are reported and not descended into.
+func (t *Tree) Check() *Report {
+	c := checker{tree: t, report: &Report{}, visited: make(map[int64]bool)}
+	if t.root != 0 {
+		c.checkNode(t.root, 1, nil, nil)
+	}
+	return c.report
+}
+
+type checker struct {
+	tree    *Tree
+	report  *Report
+	visited map[int64]bool
+}
+
+func (c *checker) violation(kind ViolationKind, ptr int64, cell int, format string, args ...any) {
+	c.report.Violations = append(c.report.Violations, Violation{
+		Kind: kind,
+		Page: ptr,
+		Cell: cell,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Marks the page at ptr as visited and reports weither it has not been visited before.
+func (c *checker) visit(ptr int64) bool {
+	if c.visited[ptr] {
+		c.violation(DuplicatePage, ptr, -1, "page is reachable more than once")
+		return false
+	}
+	c.visited[ptr] = true
+	c.report.Pages++
+	return true
+}
+
+// Checks the node stored at ptr at the given depth, whose keys have to be in the range [lo, hi). A
+// nil bound is unbounded. Returns the number of keys found in the subtree of the node.
+func (c *checker) checkNode(ptr int64, depth int, lo, hi []byte) uint64 {
+	if !c.visit(ptr) {
+		return 0
+	}
+	n, err := c.tree.read(ptr)
+	if err != nil {
+		c.violation(UnreadablePage, ptr, -1, "%v", err)
+		return 0
+	}
+	if !c.checkLayout(ptr, &n) {
+		return 0
+	}
+	if n.N() == 0 {
+		c.violation(EmptyNode, ptr, -1, "node holds no cells")
+		return 0
+	}
+
+	if n.Kind() == LeafPage {
+		switch {
+		case c.report.Depth == 0:
+			c.report.Depth = depth
+		case c.report.Depth != depth:
+			c.violation(UnequalDepth, ptr, -1, "leaf at depth %d, want %d", depth, c.report.Depth)
+		}
+		c.report.Keys += int(n.N())
+	}
+
+	keys := c.checkKeys(ptr, &n, lo, hi)
+	if n.Kind() == LeafPage {
+		return uint64(n.N())
+	}
+
+	var sum uint64
+	for i := range n.N() {
+		if keys[i] == nil {
+			continue
+		}
+		childHi := hi
+		if i+1 < n.N() {
+			childHi = keys[i+1]
+		}
+		found := c.checkNode(n.Pointer(i), depth+1, keys[i], childHi)
+		if count, ok := n.Count(i); ok && count != found {
+			c.violation(InvalidCount, ptr, int(i), "subtree count %d, found %d keys", count, found)
+		}
+		sum += found
+	}
+	return sum
+}
+
+// Checks that the header and offset table of n are consistent and that all cells lie inside the cell
+// area without overlapping. Reports weither n is safe to be accessed.
+func (c *checker) checkLayout(ptr int64, n *node) bool {
+	switch n.Type() {
+	case PointerPage, LeafPage, PrefixPointerPage, PrefixLeafPage:
+	default:
+		c.violation(InvalidPageType, ptr, -1, "page type %d is not a node", n.Type())
+		return false
+	}
+
+	start := dataOff
+	if n.isPrefixed() {
+		start = prefixOff + int(binary.LittleEndian.Uint16(n[prefixLenOff:]))
+	}
+	end := start + 2*int(n.N())
+	if end > PageSize {
+		c.violation(InvalidOffset, ptr, -1, "offset table of %d cells exceeds page", n.N())
+		return false
+	}
+	wc := int(n.wCursor())
+	if wc < end || wc > PageSize {
+		c.violation(InvalidWCursor, ptr, -1, "write cursor %d outside of [%d, %d]", wc, end, PageSize)
+		return false
+	}
+
+	type span struct{ i, start, end int }
+	spans := make([]span, 0, n.N())
+	ok := true
+	for i := range n.N() {
+		off := int(binary.LittleEndian.Uint16(n[n.offPos(i):]))
+		if off < wc || off+4 > PageSize {
+			c.violation(InvalidOffset, ptr, int(i), "offset %d outside of cell area [%d, %d)", off, wc,
+				PageSize)
+			ok = false
+			continue
+		}
+		kLen := int(binary.LittleEndian.Uint16(n[off:]) &^ overflowFlag)
+		vLen := int(binary.LittleEndian.Uint16(n[off+2:]) &^ overflowFlag)
+		if off+4+kLen+vLen > PageSize {
+			c.violation(InvalidOffset, ptr, int(i), "cell of %d bytes at offset %d exceeds page",
+				4+kLen+vLen, off)
+			ok = false
+			continue
+		}
+		spans = append(spans, span{int(i), off, off + 4 + kLen + vLen})
+	}
+
+	slices.SortFunc(spans, func(a, b span) int { return a.start - b.start })
+	for j := 1; j < len(spans); j++ {
+		if spans[j].start < spans[j-1].end {
+			c.violation(InvalidOffset, ptr, spans[j].i, "cell overlaps cell %d", spans[j-1].i)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// Checks the cells of n, which has to have a valid layout, and returns their full keys. The key of a
+// cell that can't be read is nil.
+func (c *checker) checkKeys(ptr int64, n *node, lo, hi []byte) [][]byte {
+	keys := make([][]byte, n.N())
+	var prev []byte
+	for i := range n.N() {
+		if !c.checkCell(ptr, n, i) {
+			continue
+		}
+		k, err := c.tree.key(n, i)
+		if err != nil {
+			c.violation(InvalidOverflow, ptr, int(i), "failed to read key: %v", err)
+			continue
+		}
+		keys[i] = k
+
+		if prev != nil && c.tree.compare(prev, k) >= 0 {
+			c.violation(UnsortedKeys, ptr, int(i), "key %q is not greater than key %q", k, prev)
+		}
+		if lo != nil && c.tree.compare(k, lo) < 0 || hi != nil && c.tree.compare(k, hi) >= 0 {
+			c.violation(InvalidSeparator, ptr, int(i), "key %q outside of separator range [%q, %q)",
+				k, lo, hi)
+		}
+		prev = k
+	}
+	return keys
+}
+
+// Checks the encoding of the i'th cell of n and the overflow chains owned by it. Reports weither
+// the cell is safe to be accessed.
+func (c *checker) checkCell(ptr int64, n *node, i uint16) bool {
+	kOverflow, vOverflow := n.Overflows(i)
+	if kOverflow && len(n.suffix(i)) < overflowRefSize {
+		c.violation(InvalidCell, ptr, int(i), "overflowing key without overflow reference")
+		return false
+	}
+	if vOverflow && len(n.Val(i)) < overflowRefSize {
+		c.violation(InvalidCell, ptr, int(i), "overflowing value without overflow reference")
+		return false
+	}
+
+	if n.Kind() == PointerPage {
+		if l := len(n.Val(i)); vOverflow || l != 8 && l != countedPointerSize {
+			c.violation(InvalidCell, ptr, int(i), "pointer cell without page offset")
+			return false
+		}
+		if p := n.Pointer(i); p <= 0 || p%PageSize != 0 {
+			c.violation(InvalidCell, ptr, int(i), "pointer %d is not a page offset", p)
+			return false
+		}
+		// Pointer cells share the overflow chains of the leaf cells they originate from, which are
+		// checked as part of the leaf.
+		return true
+	}
+
+	ok := true
+	if kOverflow && !c.checkOverflow(ptr, i, n.Key(i)) {
+		ok = false
+	}
+	if vOverflow {
+		c.checkOverflow(ptr, i, n.Val(i))
+	}
+	return ok
+}
+
+// Checks the overflow chain referenced by the stored representation of a key or value of the i'th
+// cell of the leaf at ptr. Reports weither the chain is intact.
+func (c *checker) checkOverflow(ptr int64, i uint16, stored []byte) bool {
+	prefix, total, next := parseOverflowRef(stored)
+	l := len(prefix)
+	for next != 0 {
+		if !c.visit(next) {
+			return false
+		}
+		page, err := c.tree.readOverflowPage(next)
+		if err != nil {
+			c.violation(InvalidOverflow, ptr, int(i), "overflow page %d: %v", next, err)
+			return false
+		}
+		n := int(binary.LittleEndian.Uint16(page[ovNOff:]))
+		if n > ovDataSize {
+			c.violation(InvalidOverflow, ptr, int(i), "overflow page %d holds %d bytes", next, n)
+			return false
+		}
+		l += n
+		next = int64(binary.LittleEndian.Uint64(page[ovNextOff:])) // #nosec G115
+	}
+	if l != total {
+		c.violation(InvalidOverflow, ptr, int(i), "chain holds %d bytes, want %d", l, total)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,252 @@
+package tree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// A read only pager over a fixed set of pages.
+type pageMap map[int64][PageSize]byte
+
+func (p pageMap) ReadPage(off int64) ([PageSize]byte, error) {
+	page, ok := p[off]
+	if !ok {
+		return page, fmt.Errorf("page %d is not allocated", off)
+	}
+	return page, nil
+}
+
+func (p pageMap) Alloc([PageSize]byte) (int64, error) { return 0, ErrReadOnly }
+
+func (p pageMap) Free(int64) error { return ErrReadOnly }
+
+func (p pageMap) Commit() error { return nil }
+
+func (p pageMap) Abort() error { return nil }
+
+// Returns a pointer node referencing the nodes stored at ptrs in order.
+func testPointerNode(t *testing.T, pages pageMap, ptrs ...int64) node {
+	t.Helper()
+	n := newNode(PointerPage)
+	for i, ptr := range ptrs {
+		child := node(pages[ptr])
+		n = n.SetCell(uint16(i), child.pointerCell(ptr)) // #nosec G115
+	}
+	return n
+}
+
+// Returns a valid tree of depth 2 with a root on page 1 and two leafs on pages 2 and 3.
+func testCheckPages(t *testing.T) pageMap {
+	t.Helper()
+	pages := pageMap{
+		2 * PageSize: testNode(t, "a", "b", "c"),
+		3 * PageSize: testNode(t, "d", "e", "f"),
+	}
+	pages[PageSize] = testPointerNode(t, pages, 2*PageSize, 3*PageSize)
+	return pages
+}
+
+func TestCheck(t *testing.T) {
+	r := New(testCheckPages(t), PageSize).Check()
+	if !r.OK() {
+		t.Fatalf("want no violations, got %s", r)
+	}
+	if r.Pages != 3 || r.Keys != 6 || r.Depth != 2 {
+		t.Fatalf("want 3 pages, 6 keys and depth 2, got %s", r)
+	}
+
+	if r := New(pageMap{}, 0).Check(); !r.OK() || r.Pages != 0 || r.Depth != 0 {
+		t.Fatalf("want empty report for empty tree, got %s", r)
+	}
+}
+
+func TestCheck_violations(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, pages pageMap)
+		want    ViolationKind
+		page    int64
+	}{
+		{
+			name:    "unreadable page",
+			corrupt: func(t *testing.T, pages pageMap) { delete(pages, 3*PageSize) },
+			want:    UnreadablePage,
+			page:    3 * PageSize,
+		},
+		{
+			name: "invalid page type",
+			corrupt: func(t *testing.T, pages pageMap) {
+				page := pages[2*PageSize]
+				page[0] = 0xff
+				pages[2*PageSize] = page
+			},
+			want: InvalidPageType,
+			page: 2 * PageSize,
+		},
+		{
+			name: "write cursor inside offset table",
+			corrupt: func(t *testing.T, pages pageMap) {
+				n := node(pages[2*PageSize])
+				n.setWCursor(dataOff)
+				pages[2*PageSize] = n
+			},
+			want: InvalidWCursor,
+			page: 2 * PageSize,
+		},
+		{
+			name: "offset outside of page",
+			corrupt: func(t *testing.T, pages pageMap) {
+				n := node(pages[2*PageSize])
+				n.setOffset(1, PageSize-2)
+				pages[2*PageSize] = n
+			},
+			want: InvalidOffset,
+			page: 2 * PageSize,
+		},
+		{
+			name: "offset inside void",
+			corrupt: func(t *testing.T, pages pageMap) {
+				n := node(pages[2*PageSize])
+				n.setOffset(1, n.wCursor()-1)
+				pages[2*PageSize] = n
+			},
+			want: InvalidOffset,
+			page: 2 * PageSize,
+		},
+		{
+			name: "overlapping cells",
+			corrupt: func(t *testing.T, pages pageMap) {
+				n := node(pages[2*PageSize])
+				n.setOffset(1, n.offset(0)+1)
+				pages[2*PageSize] = n
+			},
+			want: InvalidOffset,
+			page: 2 * PageSize,
+		},
+		{
+			name: "unsorted keys",
+			corrupt: func(t *testing.T, pages pageMap) {
+				n := node(pages[2*PageSize])
+				off0, off1 := n.offset(0), n.offset(1)
+				n.setOffset(0, off1)
+				n.setOffset(1, off0)
+				pages[2*PageSize] = n
+			},
+			want: UnsortedKeys,
+			page: 2 * PageSize,
+		},
+		{
+			name: "key outside of separator range",
+			corrupt: func(t *testing.T, pages pageMap) {
+				pages[2*PageSize] = testNode(t, "a", "b", "x")
+			},
+			want: InvalidSeparator,
+			page: 2 * PageSize,
+		},
+		{
+			name: "empty node",
+			corrupt: func(t *testing.T, pages pageMap) {
+				pages[3*PageSize] = newNode(LeafPage)
+			},
+			want: EmptyNode,
+			page: 3 * PageSize,
+		},
+		{
+			name: "invalid pointer",
+			corrupt: func(t *testing.T, pages pageMap) {
+				n := node(pages[PageSize])
+				n = n.Set(1, []byte("d"), pointerVal(PageSize+1))
+				pages[PageSize] = n
+			},
+			want: InvalidCell,
+			page: PageSize,
+		},
+		{
+			name: "unequal leaf depth",
+			corrupt: func(t *testing.T, pages pageMap) {
+				pages[4*PageSize] = pages[3*PageSize]
+				pages[3*PageSize] = testPointerNode(t, pages, 4*PageSize)
+			},
+			want: UnequalDepth,
+			page: 4 * PageSize,
+		},
+		{
+			name: "page reachable twice",
+			corrupt: func(t *testing.T, pages pageMap) {
+				pages[PageSize] = testPointerNode(t, pages, 2*PageSize, 2*PageSize)
+			},
+			want: DuplicatePage,
+			page: 2 * PageSize,
+		},
+		{
+			name: "cycle",
+			corrupt: func(t *testing.T, pages pageMap) {
+				n := node(pages[PageSize])
+				n = n.Set(1, []byte("d"), pointerVal(PageSize))
+				pages[PageSize] = n
+			},
+			want: DuplicatePage,
+			page: PageSize,
+		},
+		{
+			name: "invalid subtree count",
+			corrupt: func(t *testing.T, pages pageMap) {
+				v := make([]byte, countedPointerSize)
+				copy(v, pointerVal(3*PageSize))
+				binary.LittleEndian.PutUint64(v[8:], 99)
+				n := node(pages[PageSize])
+				n = n.Set(1, []byte("d"), v)
+				pages[PageSize] = n
+			},
+			want: InvalidCount,
+			page: PageSize,
+		},
+		{
+			name: "broken overflow chain",
+			corrupt: func(t *testing.T, pages pageMap) {
+				v := make([]byte, overflowRefSize)
+				binary.LittleEndian.PutUint32(v, 100)
+				binary.LittleEndian.PutUint64(v[4:], 5*PageSize)
+				n := node(pages[3*PageSize])
+				n = n.SetCell(2, makeFlaggedCell([]byte("f"), false, v, true))
+				pages[3*PageSize] = n
+			},
+			want: InvalidOverflow,
+			page: 3 * PageSize,
+		},
+		{
+			name: "overflow chain length mismatch",
+			corrupt: func(t *testing.T, pages pageMap) {
+				var ov [PageSize]byte
+				ov[0] = byte(OverflowPage)
+				binary.LittleEndian.PutUint16(ov[ovNOff:], 10)
+				pages[5*PageSize] = ov
+
+				v := make([]byte, overflowRefSize)
+				binary.LittleEndian.PutUint32(v, 100)
+				binary.LittleEndian.PutUint64(v[4:], 5*PageSize)
+				n := node(pages[3*PageSize])
+				n = n.SetCell(2, makeFlaggedCell([]byte("f"), false, v, true))
+				pages[3*PageSize] = n
+			},
+			want: InvalidOverflow,
+			page: 3 * PageSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := testCheckPages(t)
+			tt.corrupt(t, pages)
+
+			r := New(pages, PageSize).Check()
+			for _, v := range r.Violations {
+				if v.Kind == tt.want && v.Page == tt.page {
+					return
+				}
+			}
+			t.Fatalf("want %s violation on page %d, got %s", tt.want, tt.page, r)
+		})
+	}
+}
@@ -2,9 +2,30 @@ package tree
 
 const PageSize = 8192
 
+// The maximum size a single cell including its offset may take up inside a node. Limiting the cell
+// size to a quarter of the usable page space guarantees that both halves of a split node have
+// enough space left for the cell that caused the split.
+const maxCellSize = (PageSize - dataOff) / 4
+
+// The minimum number of bytes of cell data a non root node should hold. Nodes falling below it
+// after a deletion are merged with or borrow cells from one of their siblings.
+const minNodeSize = (PageSize - dataOff) / 4
+
+// Keys larger than maxInlineKey and values not fitting into a cell next to their key overflow (see
+// overflow.go). They are stored inside the cell as a prefix of keyPrefixSize or valPrefixSize bytes
+// followed by a reference to the overflow chain.
+const (
+	maxInlineKey  = 1024
+	keyPrefixSize = maxInlineKey - overflowRefSize
+	valPrefixSize = 128
+)
+
 type PageType uint8
 
 const (
 	PointerPage PageType = iota + 1
 	LeafPage
+	OverflowPage
+	PrefixPointerPage
+	PrefixLeafPage
 )
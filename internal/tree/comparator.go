@@ -0,0 +1,57 @@
+package tree
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrComparatorMismatch = errors.New("tree: tree was created with a different comparator")
+	ErrReservedComparator = errors.New("tree: comparator name is reserved for BytewiseComparator")
+)
+
+// A Comparator defines the order of the keys of a Tree.
+//
+// Named trees persist the name of their comparator and can't be opened with a different one, since
+// every node of a tree is sorted according to it. The name of a comparator therefore must never be
+// reused for a different order once a tree has been created with it. The empty name and the name of
+// BytewiseComparator are reserved for BytewiseComparator itself.
+type Comparator struct {
+	Name string
+	// Returns a negative number if a < b, 0 if a == b and a positive number if a > b.
+	Compare func(a, b []byte) int
+
+	// Set for BytewiseComparator only, which tells it apart from comparators reusing its name.
+	bytewise bool
+}
+
+var (
+	// Orders keys by their bytes using bytes.Compare, which is the default order of a Tree.
+	BytewiseComparator = Comparator{Name: "bytewise", Compare: bytes.Compare, bytewise: true}
+	// Orders keys by their bytes in descending order, e.g. for descending index columns.
+	ReverseComparator = Comparator{Name: "reverse", Compare: func(a, b []byte) int {
+		return bytes.Compare(b, a)
+	}}
+)
+
+// Orders the keys of the tree using c instead of BytewiseComparator.
+//
+// Prefix compression relies on keys sharing a prefix being stored next to each other, which only
+// the bytewise order guarantees. It is therefore disabled for trees using any other comparator.
+func KeyComparator(c Comparator) Option {
+	return func(t *Tree) { t.cmp = c }
+}
+
+// Reports weither t orders its keys by their bytes, which allows comparing keys by their prefixes.
+func (t *Tree) bytewise() bool {
+	return t.cmp.bytewise
+}
+
+// Reports weither c uses a name reserved for BytewiseComparator without being it.
+func (c Comparator) reserved() bool {
+	return !c.bytewise && (c.Name == "" || c.Name == BytewiseComparator.Name)
+}
+
+func (t *Tree) compare(a, b []byte) int {
+	return t.cmp.Compare(a, b)
+}
@@ -0,0 +1,171 @@
+package tree_test
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// Orders keys case insensitive like a simple text collation.
+var foldComparator = tree.Comparator{Name: "fold", Compare: func(a, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}}
+
+func TestKeyComparator(t *testing.T) {
+	const n = 5000
+	p := newMemPager()
+	tr := tree.New(p, 0, tree.KeyComparator(tree.ReverseComparator), tree.PrefixCompression())
+	for i := range n {
+		j := (i * 7919) % n
+		if err := tr.Set(testKey(j), testVal(j)); err != nil {
+			t.Fatalf("Set(%q) failed: %v", testKey(j), err)
+		}
+	}
+	if err := tr.Set(largeKey(n, 2*tree.PageSize), nil); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if r := tr.Check(); !r.OK() {
+		t.Fatalf("want no violations, got %s", r)
+	}
+
+	c := tr.Cursor()
+	if !c.First() || !bytes.Equal(c.Key(), largeKey(n, 2*tree.PageSize)) {
+		t.Fatalf("want largest key first, got %q", c.Key())
+	}
+	for i := n - 1; i >= 0; i-- {
+		if !c.Next() || !bytes.Equal(c.Key(), testKey(i)) {
+			t.Fatalf("want key %q, got %q", testKey(i), c.Key())
+		}
+	}
+
+	seq, errf := tr.Range(testKey(10), testKey(5))
+	i := 10
+	for k := range seq {
+		if !bytes.Equal(k, testKey(i)) {
+			t.Fatalf("want key %q, got %q", testKey(i), k)
+		}
+		i--
+	}
+	if err := errf(); err != nil || i != 5 {
+		t.Fatalf("want range to end at %q, got %q, %v", testKey(5), testKey(i), err)
+	}
+
+	for i := range n {
+		if got, err := tr.Get(testKey(i)); err != nil || !bytes.Equal(got, testVal(i)) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", testKey(i), got, err, testVal(i))
+		}
+		if err := tr.Delete(testKey(i)); err != nil {
+			t.Fatalf("Delete(%q) failed: %v", testKey(i), err)
+		}
+	}
+}
+
+func TestKeyComparator_collation(t *testing.T) {
+	tr := tree.New(newMemPager(), 0, tree.KeyComparator(foldComparator))
+	for _, k := range []string{"b", "C", "a", "B"} {
+		if err := tr.Set([]byte(k), []byte(k)); err != nil {
+			t.Fatalf("Set(%q) failed: %v", k, err)
+		}
+	}
+
+	// Overwriting a key equal to a stored one keeps the stored key.
+	want := []string{"a", "b", "C"}
+	seq, _ := tr.Range(nil, nil)
+	var got []string
+	for k := range seq {
+		got = append(got, string(k))
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("want keys %q, got %q", want, got)
+	}
+	if v, err := tr.Get([]byte("c")); err != nil || string(v) != "C" {
+		t.Fatalf("Get(%q) = %q, %v, want %q", "c", v, err, "C")
+	}
+}
+
+func TestKeyComparator_BulkLoad(t *testing.T) {
+	if _, err := tree.BulkLoad(newMemPager(), pairs("c", "b", "a"),
+		tree.KeyComparator(tree.ReverseComparator)); err != nil {
+		t.Fatalf("BulkLoad() of descending keys failed: %v", err)
+	}
+	if _, err := tree.BulkLoad(newMemPager(), pairs("a", "b"),
+		tree.KeyComparator(tree.ReverseComparator)); !errors.Is(err, tree.ErrUnsortedInput) {
+		t.Fatalf("want ErrUnsortedInput for ascending keys, got %v", err)
+	}
+}
+
+func TestCatalog_KeyComparator(t *testing.T) {
+	p := newMemPager()
+	c := tree.OpenCatalog(p, 0)
+	tr, err := c.Create("desc", tree.KeyComparator(tree.ReverseComparator))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if err := tr.Set([]byte(k), nil); err != nil {
+			t.Fatalf("Set(%q) failed: %v", k, err)
+		}
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+
+	reopened := tree.OpenCatalog(p, c.Root())
+	if _, err := reopened.Open("desc"); !errors.Is(err, tree.ErrComparatorMismatch) {
+		t.Fatalf("want ErrComparatorMismatch opening without comparator, got %v", err)
+	}
+	_, err = reopened.Open("desc", tree.KeyComparator(foldComparator))
+	if !errors.Is(err, tree.ErrComparatorMismatch) {
+		t.Fatalf("want ErrComparatorMismatch opening with wrong comparator, got %v", err)
+	}
+	tr, err = reopened.Open("desc", tree.KeyComparator(tree.ReverseComparator))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if c := tr.Cursor(); !c.First() || string(c.Key()) != "c" {
+		t.Fatalf("want first key %q, got %q", "c", c.Key())
+	}
+
+	if err := reopened.Drop("desc"); err != nil {
+		t.Fatalf("Drop() failed: %v", err)
+	}
+}
+
+func TestKeyComparator_reservedName(t *testing.T) {
+	fake := tree.Comparator{Name: tree.BytewiseComparator.Name, Compare: tree.ReverseComparator.Compare}
+
+	// Comparators reusing the name of BytewiseComparator don't get its prefix compression.
+	const n = 1000
+	tr := tree.New(newMemPager(), 0, tree.KeyComparator(fake), tree.PrefixCompression())
+	for i := range n {
+		if err := tr.Set(testKey(i), testVal(i)); err != nil {
+			t.Fatalf("Set(%q) failed: %v", testKey(i), err)
+		}
+	}
+	if r := tr.Check(); !r.OK() {
+		t.Fatalf("want no violations, got %s", r)
+	}
+	if c := tr.Cursor(); !c.First() || !bytes.Equal(c.Key(), testKey(n-1)) {
+		t.Fatalf("want largest key first, got %q", c.Key())
+	}
+
+	p := newMemPager()
+	c := tree.OpenCatalog(p, 0)
+	for _, cmp := range []tree.Comparator{fake, {Compare: tree.ReverseComparator.Compare}} {
+		if _, err := c.Create("fake", tree.KeyComparator(cmp)); !errors.Is(err, tree.ErrReservedComparator) {
+			t.Fatalf("want ErrReservedComparator creating with %q, got %v", cmp.Name, err)
+		}
+	}
+	if _, err := c.Create("asc"); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if _, err := c.Open("asc", tree.KeyComparator(fake)); !errors.Is(err, tree.ErrReservedComparator) {
+		t.Fatalf("want ErrReservedComparator opening with reserved name, got %v", err)
+	}
+	if _, err := c.Open("asc"); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+}
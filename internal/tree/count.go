@@ -0,0 +1,226 @@
+package tree
+
+import "encoding/binary"
+
+/*
+Trees created with SubtreeCounts store the number of k-v pairs inside the subtree of every child
+next to the pointer to the child:
+
+	Description | KeyLen | ValLen | Key    | Pointer | Count
+	------------+--------+--------+--------+---------+------
+	Size in B   | 2      | 2      | KeyLen | 8       | 8
+
+Pointer cells carrying a count are told apart from regular pointer cells by their ValLen of 16.
+Since every write copies the path from the root to the changed leaf, all counts on that path are
+recalculated from the new children whenever their pointer cells are rewritten.
+
+The counts allow Count and Nth to skip whole subtrees and only descend along a single path. Pointer
+cells without a count are still supported, in which case the subtree of the child is walked.
+*/
+
+const countedPointerSize = 16
+
+// Stores the number of k-v pairs inside the subtree of every child in the pointer cell referencing
+// it, allowing Count and Nth to run in logarithmic time.
+func SubtreeCounts() Option {
+	return func(t *Tree) { t.subtreeCounts = true }
+}
+
+// Returns the number of k-v pairs inside the subtree of the child referenced by the i'th cell of the
+// pointer node n and weither the cell carries a count at all.
+//
+// Panics if i is greater or equal than the length of n.
+func (n *node) Count(i uint16) (uint64, bool) {
+	v := n.Val(i)
+	if len(v) != countedPointerSize {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(v[8:]), true
+}
+
+// Returns the number of k-v pairs inside the subtree of n and weither it is known, which is the case
+// for all leafs and for pointer nodes whose cells all carry a count.
+func (n *node) count() (uint64, bool) {
+	if n.Kind() == LeafPage {
+		return uint64(n.N()), true
+	}
+
+	var sum uint64
+	for i := range n.N() {
+		c, ok := n.Count(i)
+		if !ok {
+			return 0, false
+		}
+		sum += c
+	}
+	return sum, true
+}
+
+// Returns a pointer cell referencing the node n stored at ptr, which carries the number of k-v pairs
+// inside the subtree of n if t stores subtree counts and the count is known.
+func (t *Tree) pointerCell(n *node, ptr int64) []byte {
+	cell := n.pointerCell(ptr)
+	if !t.subtreeCounts {
+		return cell
+	}
+	c, ok := n.count()
+	if !ok {
+		return cell
+	}
+
+	kOverflow, _ := n.Overflows(0)
+	v := make([]byte, countedPointerSize)
+	copy(v, pointerVal(ptr))
+	binary.LittleEndian.PutUint64(v[8:], c)
+	return makeFlaggedCell(n.Key(0), kOverflow, v, false)
+}
+
+// Returns the number of k-v pairs inside the subtree of the child referenced by the i'th cell of the
+// pointer node n. The subtree is walked if the cell does not carry a count.
+func (t *Tree) childCount(n *node, i uint16) (uint64, error) {
+	if c, ok := n.Count(i); ok {
+		return c, nil
+	}
+
+	child, err := t.read(n.Pointer(i))
+	if err != nil {
+		return 0, err
+	}
+	switch child.Kind() {
+	case PointerPage:
+		var sum uint64
+		for j := range child.N() {
+			c, err := t.childCount(&child, j)
+			if err != nil {
+				return 0, err
+			}
+			sum += c
+		}
+		return sum, nil
+	case LeafPage:
+		return uint64(child.N()), nil
+	default:
+		return 0, ErrInvalidPageType
+	}
+}
+
+// Returns the number of keys in t from start to end, including start and excluding end. A nil start
+// or end leaves the range unbounded on that side.
+func (t *Tree) Count(start, end []byte) (int, error) {
+	var from uint64
+	if start != nil {
+		var err error
+		if from, err = t.rank(start); err != nil {
+			return 0, err
+		}
+	}
+	to, err := t.rank(end)
+	if err != nil {
+		return 0, err
+	}
+	if to < from {
+		return 0, nil
+	}
+	return int(to - from), nil // #nosec G115 // counts fit into an int
+}
+
+// Returns the number of keys in t less than k or all keys if k is nil.
+func (t *Tree) rank(k []byte) (uint64, error) {
+	if t.root == 0 {
+		return 0, nil
+	}
+
+	cur, err := t.read(t.root)
+	if err != nil {
+		return 0, err
+	}
+
+	var rank uint64
+	for {
+		i, exists := cur.N(), false
+		if k != nil {
+			i, exists, err = t.search(&cur, k)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		switch cur.Kind() {
+		case PointerPage:
+			if k == nil {
+				i = cur.N() - 1
+			} else {
+				i = childIndex(i, exists)
+			}
+			for j := range i {
+				c, err := t.childCount(&cur, j)
+				if err != nil {
+					return 0, err
+				}
+				rank += c
+			}
+			cur, err = t.read(cur.Pointer(i))
+			if err != nil {
+				return 0, err
+			}
+		case LeafPage:
+			return rank + uint64(i), nil
+		default:
+			return 0, ErrInvalidPageType
+		}
+	}
+}
+
+// Returns the k-v pair at position i of t in key order, starting at 0.
+//
+// Returns ErrIndexOutOfBounds if t holds i or less keys.
+func (t *Tree) Nth(i int) ([]byte, []byte, error) {
+	if i < 0 || t.root == 0 {
+		return nil, nil, ErrIndexOutOfBounds
+	}
+	pos := uint64(i)
+
+	cur, err := t.read(t.root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		switch cur.Kind() {
+		case PointerPage:
+			j := uint16(0)
+			for ; j < cur.N(); j++ {
+				c, err := t.childCount(&cur, j)
+				if err != nil {
+					return nil, nil, err
+				}
+				if pos < c {
+					break
+				}
+				pos -= c
+			}
+			if j == cur.N() {
+				return nil, nil, ErrIndexOutOfBounds
+			}
+			cur, err = t.read(cur.Pointer(j))
+			if err != nil {
+				return nil, nil, err
+			}
+		case LeafPage:
+			if pos >= uint64(cur.N()) {
+				return nil, nil, ErrIndexOutOfBounds
+			}
+			k, err := t.key(&cur, uint16(pos)) // #nosec G115 // pos is a node index
+			if err != nil {
+				return nil, nil, err
+			}
+			v, err := t.val(&cur, uint16(pos)) // #nosec G115 // pos is a node index
+			if err != nil {
+				return nil, nil, err
+			}
+			return k, v, nil
+		default:
+			return nil, nil, ErrInvalidPageType
+		}
+	}
+}
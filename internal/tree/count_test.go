@@ -0,0 +1,139 @@
+package tree_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// A pager counting the pages read through it.
+type countingPager struct {
+	*memPager
+	reads int
+}
+
+func (p *countingPager) ReadPage(off int64) ([tree.PageSize]byte, error) {
+	p.reads++
+	return p.memPager.ReadPage(off)
+}
+
+func TestTree_Count(t *testing.T) {
+	const n = 20000
+	for _, opts := range [][]tree.Option{nil, {tree.SubtreeCounts()}} {
+		p := &countingPager{memPager: newMemPager()}
+		tr := tree.New(p, 0, opts...)
+		var keys []int
+		for i := range n {
+			j := (i * 7919) % n
+			if err := tr.Set(testKey(j), testVal(j)); err != nil {
+				t.Fatalf("Set(%q) failed: %v", testKey(j), err)
+			}
+		}
+		for i := range n {
+			if i%3 == 0 {
+				if err := tr.Delete(testKey(i)); err != nil {
+					t.Fatalf("Delete(%q) failed: %v", testKey(i), err)
+				}
+				continue
+			}
+			keys = append(keys, i)
+		}
+		if r := tr.Check(); !r.OK() {
+			t.Fatalf("want no violations, got %s", r)
+		}
+
+		tests := []struct {
+			name       string
+			start, end []byte
+			want       int
+		}{
+			{"all", nil, nil, len(keys)},
+			{"from start", testKey(3000), nil, len(keys) - 2000},
+			{"to end", nil, testKey(3000), 2000},
+			{"existing bounds", testKey(1), testKey(301), 200},
+			{"missing bounds", testKey(0), testKey(300), 200},
+			{"inverted bounds", testKey(300), testKey(0), 0},
+			{"outside", []byte("z"), nil, 0},
+		}
+		for _, tt := range tests {
+			p.reads = 0
+			got, err := tr.Count(tt.start, tt.end)
+			if err != nil || got != tt.want {
+				t.Fatalf("%s: Count(%q, %q) = %d, %v, want %d", tt.name, tt.start, tt.end, got, err,
+					tt.want)
+			}
+			if opts != nil && p.reads > 10 {
+				t.Fatalf("%s: want Count to read at most 10 pages, read %d", tt.name, p.reads)
+			}
+		}
+
+		for _, i := range []int{0, 1, 500, len(keys) / 2, len(keys) - 1} {
+			p.reads = 0
+			k, v, err := tr.Nth(i)
+			if err != nil || !bytes.Equal(k, testKey(keys[i])) || !bytes.Equal(v, testVal(keys[i])) {
+				t.Fatalf("Nth(%d) = %q, %v, want %q", i, k, err, testKey(keys[i]))
+			}
+			if opts != nil && p.reads > 5 {
+				t.Fatalf("want Nth to read at most 5 pages, read %d", p.reads)
+			}
+		}
+		for _, i := range []int{-1, len(keys)} {
+			if _, _, err := tr.Nth(i); !errors.Is(err, tree.ErrIndexOutOfBounds) {
+				t.Fatalf("want ErrIndexOutOfBounds for Nth(%d), got %v", i, err)
+			}
+		}
+	}
+}
+
+func TestTree_Count_BulkLoad(t *testing.T) {
+	p := &countingPager{memPager: newMemPager()}
+	tr, err := tree.BulkLoad(p, sortedPairs(20000), tree.SubtreeCounts())
+	if err != nil {
+		t.Fatalf("BulkLoad() failed: %v", err)
+	}
+	if r := tr.Check(); !r.OK() {
+		t.Fatalf("want no violations, got %s", r)
+	}
+
+	p.reads = 0
+	if got, err := tr.Count(nil, nil); err != nil || got != 20000 {
+		t.Fatalf("Count() = %d, %v, want %d", got, err, 20000)
+	}
+	if k, _, err := tr.Nth(12345); err != nil || !bytes.Equal(k, testKey(12345)) {
+		t.Fatalf("Nth() = %q, %v, want %q", k, err, testKey(12345))
+	}
+	if p.reads > 10 {
+		t.Fatalf("want Count and Nth to read at most 10 pages, read %d", p.reads)
+	}
+}
+
+func TestCatalog_SubtreeCounts(t *testing.T) {
+	p := &countingPager{memPager: newMemPager()}
+	c := tree.OpenCatalog(p, 0)
+	if _, err := c.Create("counted", tree.SubtreeCounts()); err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+
+	tr, err := tree.OpenCatalog(p, c.Root()).Open("counted")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	for i := range 20000 {
+		if err := tr.Set(testKey(i), nil); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+
+	p.reads = 0
+	if got, err := tr.Count(nil, nil); err != nil || got != 20000 {
+		t.Fatalf("Count() = %d, %v, want %d", got, err, 20000)
+	}
+	if p.reads > 5 {
+		t.Fatalf("want reopened tree to store subtree counts, Count read %d pages", p.reads)
+	}
+}
@@ -0,0 +1,296 @@
+package tree
+
+import (
+	"iter"
+)
+
+// A Cursor traverses the k-v pairs of a Tree in key order across page boundaries. A Cursor holds
+// copies of the nodes on the path from the root to its current leaf and must not be used after t
+// was written to.
+//
+// The key and value slices returned by a Cursor are only valid until the Cursor is moved.
+type Cursor struct {
+	tree  *Tree
+	stack []frame
+	err   error
+}
+
+// A frame is a node on the path of a Cursor together with the position of the cursor inside it.
+type frame struct {
+	n node
+	i int
+}
+
+// Returns a new unpositioned Cursor over t.
+func (t *Tree) Cursor() *Cursor {
+	return &Cursor{tree: t}
+}
+
+// Positions c at the first key greater or equal than k and reports weither such a key exists.
+func (c *Cursor) Seek(k []byte) bool {
+	if !c.reset(false) {
+		return false
+	}
+
+	for {
+		f := c.top()
+		i, exists, err := c.tree.search(&f.n, k)
+		if err != nil {
+			c.err = err
+			c.stack = c.stack[:0]
+			return false
+		}
+
+		switch f.n.Kind() {
+		case PointerPage:
+			f.i = int(childIndex(i, exists))
+			if !c.push(f.n.Pointer(uint16(f.i)), false) { // #nosec G115 // i is a node index
+				return false
+			}
+		case LeafPage:
+			f.i = int(i)
+			return c.forward()
+		default:
+			c.err = ErrInvalidPageType
+			return false
+		}
+	}
+}
+
+// Positions c at the first key of the tree and reports weither the tree holds any keys.
+func (c *Cursor) First() bool {
+	return c.reset(false) && c.forward()
+}
+
+// Positions c at the last key of the tree and reports weither the tree holds any keys.
+func (c *Cursor) Last() bool {
+	return c.reset(true) && c.backward()
+}
+
+// Moves c to the next key and reports weither it exists.
+func (c *Cursor) Next() bool {
+	if !c.Valid() {
+		return false
+	}
+	c.top().i++
+	return c.forward()
+}
+
+// Moves c to the previous key and reports weither it exists.
+func (c *Cursor) Prev() bool {
+	if !c.Valid() {
+		return false
+	}
+	c.top().i--
+	return c.backward()
+}
+
+// Reports weither c is positioned at a key.
+func (c *Cursor) Valid() bool {
+	if c.err != nil || len(c.stack) == 0 {
+		return false
+	}
+	f := c.top()
+	return f.n.Kind() == LeafPage && f.i >= 0 && f.i < int(f.n.N())
+}
+
+// Returns the key c is positioned at or nil if c is not valid. Overflowing keys are reassembled from
+// their overflow chain, if that fails c becomes invalid and Err reports the error.
+func (c *Cursor) Key() []byte {
+	if !c.Valid() {
+		return nil
+	}
+	f := c.top()
+	k, err := c.tree.key(&f.n, uint16(f.i)) // #nosec G115 // i is a node index
+	if err != nil {
+		c.err = err
+		return nil
+	}
+	return k
+}
+
+// Returns the value of the key c is positioned at or nil if c is not valid. Overflowing values are
+// reassembled from their overflow chain, if that fails c becomes invalid and Err reports the error.
+func (c *Cursor) Val() []byte {
+	if !c.Valid() {
+		return nil
+	}
+	f := c.top()
+	v, err := c.tree.val(&f.n, uint16(f.i)) // #nosec G115 // i is a node index
+	if err != nil {
+		c.err = err
+		return nil
+	}
+	return v
+}
+
+// Returns the first error c encountered while reading pages.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Clears the path of c and pushes the root node positioned at its first or last cell.
+func (c *Cursor) reset(last bool) bool {
+	c.stack = c.stack[:0]
+	if c.err != nil || c.tree.root == 0 {
+		return false
+	}
+	return c.push(c.tree.root, last)
+}
+
+// Reads the node at ptr and pushes it onto the path positioned at its first or last cell.
+func (c *Cursor) push(ptr int64, last bool) bool {
+	n, err := c.tree.read(ptr)
+	if err != nil {
+		c.err = err
+		c.stack = c.stack[:0]
+		return false
+	}
+
+	f := frame{n: n}
+	if last {
+		f.i = int(n.N()) - 1
+	}
+	c.stack = append(c.stack, f)
+	return true
+}
+
+func (c *Cursor) top() *frame {
+	return &c.stack[len(c.stack)-1]
+}
+
+// Moves c forward until it is positioned at a cell of a leaf, starting from the current position.
+func (c *Cursor) forward() bool {
+	for {
+		f := c.top()
+		switch {
+		case f.i >= int(f.n.N()):
+			c.stack = c.stack[:len(c.stack)-1]
+			if len(c.stack) == 0 {
+				return false
+			}
+			c.top().i++
+		case f.n.Kind() == PointerPage:
+			if !c.push(f.n.Pointer(uint16(f.i)), false) { // #nosec G115 // i is a node index
+				return false
+			}
+		case f.n.Kind() == LeafPage:
+			return true
+		default:
+			c.err = ErrInvalidPageType
+			return false
+		}
+	}
+}
+
+// Moves c backward until it is positioned at a cell of a leaf, starting from the current position.
+func (c *Cursor) backward() bool {
+	for {
+		f := c.top()
+		switch {
+		case f.i < 0:
+			c.stack = c.stack[:len(c.stack)-1]
+			if len(c.stack) == 0 {
+				return false
+			}
+			c.top().i--
+		case f.n.Kind() == PointerPage:
+			if !c.push(f.n.Pointer(uint16(f.i)), true) { // #nosec G115 // i is a node index
+				return false
+			}
+		case f.n.Kind() == LeafPage:
+			return true
+		default:
+			c.err = ErrInvalidPageType
+			return false
+		}
+	}
+}
+
+type rangeOptions struct {
+	excludeStart bool
+	includeEnd   bool
+	reverse      bool
+}
+
+// A RangeOption configures the bounds and direction of Tree.Range.
+type RangeOption func(*rangeOptions)
+
+// Excludes the start key from the range.
+func ExcludeStart() RangeOption {
+	return func(o *rangeOptions) { o.excludeStart = true }
+}
+
+// Includes the end key in the range.
+func IncludeEnd() RangeOption {
+	return func(o *rangeOptions) { o.includeEnd = true }
+}
+
+// Iterates over the range in descending key order.
+func Reverse() RangeOption {
+	return func(o *rangeOptions) { o.reverse = true }
+}
+
+// Returns an iterator over the k-v pairs of t with keys from start to end. By default the range is
+// half-open, including start and excluding end, which can be changed using ExcludeStart and
+// IncludeEnd. A nil start or end leaves the range unbounded on that side.
+//
+// The iteration stops early if reading a page fails, in which case the returned function reports
+// the error. Like with a Cursor, the yielded slices are only valid until the next iteration.
+func (t *Tree) Range(start, end []byte, opts ...RangeOption) (iter.Seq2[[]byte, []byte], func() error) {
+	var o rangeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := t.Cursor()
+
+	afterStart := func(k []byte) bool {
+		if start == nil {
+			return true
+		}
+		cmp := t.compare(k, start)
+		return cmp > 0 || cmp == 0 && !o.excludeStart
+	}
+	beforeEnd := func(k []byte) bool {
+		if end == nil {
+			return true
+		}
+		cmp := t.compare(k, end)
+		return cmp < 0 || cmp == 0 && o.includeEnd
+	}
+
+	seq := func(yield func([]byte, []byte) bool) {
+		var ok bool
+		if o.reverse {
+			switch {
+			case end == nil:
+				ok = c.Last()
+			case c.Seek(end):
+				ok = beforeEnd(c.Key()) || c.Prev()
+			case c.Err() == nil:
+				ok = c.Last()
+			}
+			for ; ok; ok = c.Prev() {
+				k, v := c.Key(), c.Val()
+				if !c.Valid() || !afterStart(k) || !yield(k, v) {
+					return
+				}
+			}
+			return
+		}
+
+		if start == nil {
+			ok = c.First()
+		} else if ok = c.Seek(start); ok && !afterStart(c.Key()) {
+			ok = c.Next()
+		}
+		for ; ok; ok = c.Next() {
+			k, v := c.Key(), c.Val()
+			if !c.Valid() || !beforeEnd(k) || !yield(k, v) {
+				return
+			}
+		}
+	}
+	return seq, c.Err
+}
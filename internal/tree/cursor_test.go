@@ -0,0 +1,140 @@
+package tree_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+func TestCursor(t *testing.T) {
+	const n = 3000
+	tr, _ := testTree(t, n)
+	c := tr.Cursor()
+
+	i := 0
+	for ok := c.First(); ok; ok = c.Next() {
+		if !bytes.Equal(c.Key(), testKey(i)) || !bytes.Equal(c.Val(), testVal(i)) {
+			t.Fatalf("want %q at position %d, got %q", testKey(i), i, c.Key())
+		}
+		i++
+	}
+	if i != n {
+		t.Fatalf("want %d keys iterating forward, got %d", n, i)
+	}
+
+	for ok := c.Last(); ok; ok = c.Prev() {
+		i--
+		if !bytes.Equal(c.Key(), testKey(i)) {
+			t.Fatalf("want %q at position %d, got %q", testKey(i), i, c.Key())
+		}
+	}
+	if i != 0 {
+		t.Fatalf("want %d keys iterating backward, got %d", n, n-i)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+}
+
+func TestCursor_Seek(t *testing.T) {
+	tr, _ := testTree(t, 3000)
+
+	tests := []struct {
+		name   string
+		target []byte
+		want   []byte
+	}{
+		{"existing key", testKey(1234), testKey(1234)},
+		{"key between keys", append(testKey(1234), 0), testKey(1235)},
+		{"key before first", []byte("a"), testKey(0)},
+		{"key after last", []byte("z"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tr.Cursor()
+			ok := c.Seek(tt.target)
+			if ok != (tt.want != nil) || !bytes.Equal(c.Key(), tt.want) {
+				t.Errorf("Seek(%q) = %t at %q, want %q", tt.target, ok, c.Key(), tt.want)
+			}
+		})
+	}
+
+	c := tree.New(newMemPager(), 0).Cursor()
+	if c.Seek(nil) || c.First() || c.Last() || c.Valid() {
+		t.Error("want cursor over empty tree to be invalid")
+	}
+}
+
+func TestTree_Range(t *testing.T) {
+	tr, _ := testTree(t, 3000)
+
+	keys := func(from, to int) [][]byte {
+		var keys [][]byte
+		for i := from; i != to; {
+			keys = append(keys, testKey(i))
+			if from < to {
+				i++
+			} else {
+				i--
+			}
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name       string
+		start, end []byte
+		opts       []tree.RangeOption
+		want       [][]byte
+	}{
+		{"half-open", testKey(10), testKey(20), nil, keys(10, 20)},
+		{"closed", testKey(10), testKey(20), []tree.RangeOption{tree.IncludeEnd()}, keys(10, 21)},
+		{"open", testKey(10), testKey(20), []tree.RangeOption{tree.ExcludeStart()}, keys(11, 20)},
+		{"unbounded start", nil, testKey(5), nil, keys(0, 5)},
+		{"unbounded end", testKey(2995), nil, nil, keys(2995, 3000)},
+		{"keys between keys", append(testKey(10), 0), append(testKey(20), 0), nil, keys(11, 21)},
+		{"empty", testKey(20), testKey(10), nil, nil},
+		{"reverse", testKey(10), testKey(20), []tree.RangeOption{tree.Reverse()}, keys(19, 9)},
+		{
+			"reverse closed", testKey(10), testKey(20),
+			[]tree.RangeOption{tree.Reverse(), tree.IncludeEnd(), tree.ExcludeStart()}, keys(20, 10),
+		},
+		{"reverse unbounded end", testKey(2995), nil, []tree.RangeOption{tree.Reverse()}, keys(2999, 2994)},
+		{"reverse end after last", nil, []byte("z"), []tree.RangeOption{tree.Reverse()}, keys(2999, -1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq, errFn := tr.Range(tt.start, tt.end, tt.opts...)
+
+			var got [][]byte
+			for k := range seq {
+				got = append(got, bytes.Clone(k))
+			}
+			if err := errFn(); err != nil {
+				t.Fatalf("Range() failed: %v", err)
+			}
+			if !slices.EqualFunc(got, tt.want, bytes.Equal) {
+				t.Errorf("Range() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTree_Range_error(t *testing.T) {
+	tr, p := testTree(t, 3000)
+	for off := range p.pages {
+		if off != tr.Root() {
+			delete(p.pages, off)
+			break
+		}
+	}
+
+	seq, errFn := tr.Range(nil, nil)
+	for range seq {
+	}
+	if err := errFn(); err == nil {
+		t.Fatal("want error reading a missing page")
+	}
+}
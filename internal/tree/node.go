@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"iter"
+	"slices"
 )
 
 const (
@@ -13,6 +14,8 @@ const (
 	dataOff = wCurOff + 2
 )
 
+const overflowFlag uint16 = 1 << 15
+
 var (
 	ErrIndexOutOfBounds = errors.New("index is out of bounds")
 )
@@ -52,6 +55,13 @@ The data stored in the cells is formatted as follows:
 	Description | KeyLen | ValLen | Key    | Val
 	------------+--------+--------+--------+-------
 	Size in B   | 2      | 2      | KeyLen | ValLen
+
+The highest bit of KeyLen and ValLen is the overflow flag marking the key or value as too large to
+be stored inside the cell. The cell then only holds the prefix of the key or value followed by a
+reference to the overflow pages holding the remaining bytes (see overflow.go).
+
+The cells of a LeafPage hold the actual k-v pairs. The cells of a PointerPage hold the first key
+of a child node as key and the uint64 offset of the page the child node is stored on as value.
 */
 type node [PageSize]byte
 
@@ -67,61 +77,107 @@ func (n *node) Type() PageType {
 	return PageType(n[0])
 }
 
+// Returns the type of n disregarding its format, meaning PointerPage for both PointerPage and
+// PrefixPointerPage nodes and LeafPage for both LeafPage and PrefixLeafPage nodes.
+func (n *node) Kind() PageType {
+	switch t := n.Type(); t {
+	case PrefixPointerPage:
+		return PointerPage
+	case PrefixLeafPage:
+		return LeafPage
+	default:
+		return t
+	}
+}
+
 // Returns the number of cells currently stored on n.
 func (n *node) N() uint16 {
 	return binary.LittleEndian.Uint16(n[nOff:])
 }
 
-// Returns the key of the i'th cell stored in n.
+// Returns the key of the i'th cell stored in n. On prefix compressed nodes the key is assembled from
+// the prefix of n and the suffix stored in the cell.
 //
 // Panics if i is greater or equal than the length of n.
 func (n *node) Key(i uint16) []byte {
-	if !n.indexInBounds(i) {
-		panic(ErrIndexOutOfBounds)
+	if n.isPrefixed() {
+		prefix, suffix := n.Prefix(), n.suffix(i)
+		return append(prefix[:len(prefix):len(prefix)], suffix...)
 	}
-	off := n.offset(i)
-	kLen := binary.LittleEndian.Uint16(n[off:])
-	return n[off+4 : off+4+kLen]
+	return n.suffix(i)
 }
 
 // Returns the value of the i'th cell stored in n.
 //
 // Panics if i is greater or equal than the length of n.
 func (n *node) Val(i uint16) []byte {
-	if !n.indexInBounds(i) {
-		panic(ErrIndexOutOfBounds)
-	}
 	off := n.offset(i)
-	kLen := binary.LittleEndian.Uint16(n[off:])
-	vLen := binary.LittleEndian.Uint16(n[off+2:])
+	kLen := binary.LittleEndian.Uint16(n[off:]) &^ overflowFlag
+	vLen := binary.LittleEndian.Uint16(n[off+2:]) &^ overflowFlag
 	return n[off+4+kLen : off+4+kLen+vLen]
 }
 
-func (n *node) Pointer(i uint16) int64 {
-	if !n.indexInBounds(i) {
-		panic(ErrIndexOutOfBounds)
-	}
+// Reports weither the key and the value of the i'th cell stored in n overflow.
+//
+// Panics if i is greater or equal than the length of n.
+func (n *node) Overflows(i uint16) (key bool, val bool) {
 	off := n.offset(i)
 	kLen := binary.LittleEndian.Uint16(n[off:])
-	return int64(binary.LittleEndian.Uint64(n[off+2+kLen : off+2+kLen+8]))
+	vLen := binary.LittleEndian.Uint16(n[off+2:])
+	return kLen&overflowFlag != 0, vLen&overflowFlag != 0
+}
+
+// Returns the i'th cell stored in n. On prefix compressed nodes the returned cell holds the full key
+// instead of the suffix stored in n.
+//
+// Panics if i is greater or equal than the length of n.
+func (n *node) Cell(i uint16) []byte {
+	if n.isPrefixed() {
+		kOverflow, vOverflow := n.Overflows(i)
+		return makeFlaggedCell(n.Key(i), kOverflow, n.Val(i), vOverflow)
+	}
+	return n.rawCell(i)
+}
+
+// Returns the child pointer stored as the value of the i'th cell in n.
+//
+// Panics if i is greater or equal than the length of n.
+func (n *node) Pointer(i uint16) int64 {
+	return int64(binary.LittleEndian.Uint64(n.Val(i))) // #nosec G115 // pointers are page offsets
 }
 
 // Binary searches the target key inside n and returns its position and weither it exists.
 func (n *node) Search(target []byte) (uint16, bool) {
-	l := n.N()
-	left, right := uint16(0), l
+	prefix := n.Prefix()
+	if !bytes.HasPrefix(target, prefix) {
+		if bytes.Compare(target, prefix) < 0 {
+			return 0, false
+		}
+		return n.N(), false
+	}
+
+	suffix := target[len(prefix):]
+	return n.SearchFunc(func(i uint16) int {
+		return bytes.Compare(n.suffix(i), suffix)
+	})
+}
+
+// Binary searches a target inside n using cmp, which compares the key of the i'th cell of n to the
+// target. Returns the position of the target and weither it exists.
+func (n *node) SearchFunc(cmp func(i uint16) int) (uint16, bool) {
+	left, right := uint16(0), n.N()
 
 	for left < right {
 		cur := uint16(uint(left+right) >> 1) // #nosec G115 // right shift stops overflow
-		if cmp := bytes.Compare(n.Key(cur), target); cmp == -1 {
+		if c := cmp(cur); c < 0 {
 			left = cur + 1
-		} else if cmp == 1 {
+		} else if c > 0 {
 			right = cur
 		} else {
 			return cur, true
 		}
 	}
-	return left, left < l && bytes.Equal(n.Key(left), target)
+	return left, false
 }
 
 // Returns a copy of n with k-v set at position i. If the key at i is equal to k it will be
@@ -136,11 +192,28 @@ func (n *node) Search(target []byte) (uint16, bool) {
 // will not break. Always use Search and CanSet before using Set to ensure that n has enough space
 // for the k-v pair and that the value of i is correct.
 func (n *node) Set(i uint16, k, v []byte) node {
+	return n.SetCell(i, makeCell(k, v))
+}
+
+// Returns a copy of n with cell set at position i. SetCell behaves like Set but takes an already
+// encoded cell, which allows setting cells with overflowing keys or values.
+//
+// If n is prefix compressed and the key of cell does not share the prefix of n, the returned node is
+// rebuilt with the prefix shared by all keys including the new one.
+func (n *node) SetCell(i uint16, cell []byte) node {
+	if n.isPrefixed() {
+		prefix := n.Prefix()
+		if !bytes.HasPrefix(comparableKey(cell), prefix) {
+			cells := n.cells()
+			return buildNode(n.Type(), slices.Insert(cells, int(i), cell))
+		}
+		cell = stripPrefix(cell, len(prefix))
+	}
+
 	l := n.N()
-	cell := makeCell(k, v)
 
 	wCur := n.wCursor()
-	off := wCur - uint16(len(cell))
+	off := wCur - uint16(len(cell)) // #nosec G115 // cells are smaller than a page
 
 	var res node
 	copy(res[:], n[:])
@@ -148,15 +221,14 @@ func (n *node) Set(i uint16, k, v []byte) node {
 
 	res.setWCursor(off)
 
-	if bytes.Equal(k, n.Key(i)) {
+	if i < l && sameKey(cell, n.rawCell(i)) {
 		res.setOffset(i, off)
 		return res
 	}
 
-	trailingOffs := n[offPos(i) : offPos(l)+2]
-	copy(res[offPos(i+1):], trailingOffs)
-	res.setOffset(i, off)
+	copy(res[n.offPos(i+1):], n[n.offPos(i):n.offPos(l)])
 	res.setN(l + 1)
+	res.setOffset(i, off)
 
 	return res
 }
@@ -164,7 +236,23 @@ func (n *node) Set(i uint16, k, v []byte) node {
 // Returns true if n has enough space left in its void to add the given k-v pair. CanSet always
 // assumes that k does not exist.
 func (n *node) CanSet(k, v []byte) bool {
-	return n.voidSize() >= 6+len(k)+len(v)
+	return n.CanSetCell(makeCell(k, v))
+}
+
+// Returns true if n has enough space left in its void to add cell. CanSetCell always assumes that
+// the key of cell does not exist.
+//
+// A cell whose key does not share the prefix of a prefix compressed node requires n to be rebuilt,
+// in which case CanSetCell reports weither all cells of n including cell fit into a single node.
+func (n *node) CanSetCell(cell []byte) bool {
+	if n.isPrefixed() {
+		prefix := n.Prefix()
+		if !bytes.HasPrefix(comparableKey(cell), prefix) {
+			return encodedSize(n.Type(), append(n.cells(), cell)) <= PageSize-dataOff
+		}
+		return n.voidSize() >= 2+len(cell)-len(prefix)
+	}
+	return n.voidSize() >= 2+len(cell)
 }
 
 // Returns a copy of n with the k-v pair at index i deleted.
@@ -181,69 +269,52 @@ func (n *node) Delete(i uint16) node {
 	var res node
 	copy(res[:], n[:])
 
-	trailingOffs := n[offPos(i) : offPos(l)+2]
-	copy(res[offPos(i):], trailingOffs[2:])
-	res.setOffset(l, 0)
+	copy(res[n.offPos(i):], n[n.offPos(i+1):n.offPos(l)])
+	binary.LittleEndian.PutUint16(res[n.offPos(l-1):], 0)
 	res.setN(l - 1)
 
 	return res
 }
 
-// Splits n into two separate nodes.
+// Splits n into two separate nodes, each holding roughly half of the cell data of n. The left node
+// receives the lower and the right node the upper keys of n.
+//
+// Panics if n holds less than two cells.
 func (n *node) Split() (left node, right node) {
-	var addToRight bool
-	var i uint16
-	var wc uint16 = PageSize
-
-	left, right = newNode(n.Type()), newNode(n.Type())
-
-	thresh := (PageSize - wc) / 2
-
-	addToNode := func(addTo *node, i uint16, cell []byte, wCursor *uint16) {
-		*wCursor -= uint16(len(cell))
-		addTo.setOffset(i, *wCursor)
-		copy(addTo[*wCursor:], cell)
-		addTo.setWCursor(*wCursor)
+	if n.N() < 2 {
+		panic(ErrIndexOutOfBounds)
 	}
 
-	for k, v := range n.All() {
-		cell := makeCell(k, v)
+	cells := n.cells()
+	mid := splitPoint(n.Type(), cells)
+	return buildNode(n.Type(), cells[:mid]), buildNode(n.Type(), cells[mid:])
+}
 
-		if addToRight {
-			addToNode(&right, i, cell, &wc)
-			i++
-			continue
-		}
+// Returns true if the cells of n and right fit into a single node.
+func (n *node) CanMerge(right *node) bool {
+	return encodedSize(n.Type(), append(n.cells(), right.cells()...)) <= PageSize-dataOff
+}
 
-		addToNode(&left, i, cell, &wc)
-		i++
+// Returns a new node holding the cells of n followed by the cells of right.
+//
+// WARNING: No additional check is performed weither all keys of right are greater than the keys of
+// n or weither the cells fit into a single node. Always use CanMerge before using Merge.
+func (n *node) Merge(right *node) node {
+	return buildNode(n.Type(), append(n.cells(), right.cells()...))
+}
 
-		if wc < PageSize-thresh {
-			addToRight = true
-		}
-	}
-	return left, right
+// Returns two new nodes holding the cells of n followed by the cells of right, evenly distributed
+// by size. Redistribute is used to balance the cells of two siblings that can't be merged.
+func (n *node) Redistribute(right *node) (node, node) {
+	cells := append(n.cells(), right.cells()...)
+	mid := splitPoint(n.Type(), cells)
+	return buildNode(n.Type(), cells[:mid]), buildNode(n.Type(), cells[mid:])
 }
 
-// Returns a resorted and reduced copy of n by freeing up space used by unreferenced cells.
+// Returns a resorted and reduced copy of n by freeing up space used by unreferenced cells. Vacuum
+// also recalculates the prefix of prefix compressed nodes.
 func (n *node) Vacuum() node {
-	var vacuumed node
-	vacuumed[0] = byte(n.Type())
-	vacuumed.setN(n.N())
-
-	var wc uint16 = PageSize
-	var i uint16
-	for k, v := range n.All() {
-		cell := makeCell(k, v)
-		wc -= uint16(len(cell))
-		vacuumed.setOffset(i, wc)
-		copy(vacuumed[wc:], cell)
-
-		i++
-	}
-	vacuumed.setWCursor(wc)
-
-	return vacuumed
+	return buildNode(n.Type(), n.cells())
 }
 
 // An iterator over all key-value pairs of n.
@@ -268,21 +339,21 @@ func (n *node) setN(nc uint16) {
 }
 
 func (n *node) offset(i uint16) uint16 {
-	if n.indexInBounds(i) {
+	if !n.indexInBounds(i) {
 		panic(ErrIndexOutOfBounds)
 	}
-	return binary.LittleEndian.Uint16(n[offPos(i):])
+	return binary.LittleEndian.Uint16(n[n.offPos(i):])
 }
 
 func (n *node) setOffset(i, off uint16) {
-	if n.indexInBounds(i) {
+	if !n.indexInBounds(i) {
 		panic(ErrIndexOutOfBounds)
 	}
-	binary.LittleEndian.PutUint16(n[offPos(i):], off)
+	binary.LittleEndian.PutUint16(n[n.offPos(i):], off)
 }
 
 func (n *node) indexInBounds(i uint16) bool {
-	return i >= n.N()
+	return i < n.N()
 }
 
 func (n *node) wCursor() uint16 {
@@ -294,18 +365,108 @@ func (n *node) setWCursor(wc uint16) {
 }
 
 func (n *node) voidSize() int {
-	return int(n.wCursor()) - int(offPos(n.N())+2)
+	return int(n.wCursor()) - int(n.offPos(n.N()))
+}
+
+// Returns the number of bytes taken up by the referenced cells of n including their offsets.
+func (n *node) used() int {
+	var used int
+	for i := range n.N() {
+		used += 2 + len(n.rawCell(i))
+	}
+	return used
+}
+
+// Returns all cells of n in order.
+func (n *node) cells() [][]byte {
+	cells := make([][]byte, n.N())
+	for i := range n.N() {
+		cells[i] = n.Cell(i)
+	}
+	return cells
+}
+
+// Returns the i'th cell as it is stored in n.
+func (n *node) rawCell(i uint16) []byte {
+	off := n.offset(i)
+	kLen := binary.LittleEndian.Uint16(n[off:]) &^ overflowFlag
+	vLen := binary.LittleEndian.Uint16(n[off+2:]) &^ overflowFlag
+	return n[off : off+4+kLen+vLen]
+}
+
+// Returns the key of the i'th cell as it is stored in n, which is only the suffix of the key on
+// prefix compressed nodes.
+func (n *node) suffix(i uint16) []byte {
+	off := n.offset(i)
+	kLen := binary.LittleEndian.Uint16(n[off:]) &^ overflowFlag
+	return n[off+4 : off+4+kLen]
+}
+
+// Appends cell behind the last cell of n. The caller has to ensure that the key of cell is greater
+// than all keys in n, that it shares the prefix of n and that n has enough space left.
+func (n *node) appendCell(cell []byte) {
+	wc := n.wCursor() - uint16(len(cell)) // #nosec G115 // cells are smaller than a page
+	copy(n[wc:], cell)
+	n.setWCursor(wc)
+
+	l := n.N()
+	n.setN(l + 1)
+	n.setOffset(l, wc)
 }
 
 // Returns the calculated position to the offset inside the offset list. This does NOT return the
 // offset itself only the reference to the offset.
-func offPos(i uint16) uint16 { return dataOff + 2*i }
+func (n *node) offPos(i uint16) uint16 {
+	if n.isPrefixed() {
+		return prefixOff + uint16(len(n.Prefix())) + 2*i // #nosec G115 // prefixes are short
+	}
+	return dataOff + 2*i
+}
+
+// Returns a PointerPage cell referencing the page at ptr, using the key of the first cell of n,
+// which is the node stored at ptr, as key.
+func (n *node) pointerCell(ptr int64) []byte {
+	kOverflow, _ := n.Overflows(0)
+	return makeFlaggedCell(n.Key(0), kOverflow, pointerVal(ptr), false)
+}
+
+// Returns the value of a PointerPage cell referencing the page at ptr.
+func pointerVal(ptr int64) []byte {
+	v := make([]byte, 8)
+	binary.LittleEndian.PutUint64(v, uint64(ptr)) // #nosec G115 // pointers are page offsets
+	return v
+}
 
 func makeCell(k, v []byte) []byte {
+	return makeFlaggedCell(k, false, v, false)
+}
+
+// Returns a cell holding the k-v pair with the overflow flags of the key and value set accordingly.
+func makeFlaggedCell(k []byte, kOverflow bool, v []byte, vOverflow bool) []byte {
+	kLen, vLen := uint16(len(k)), uint16(len(v)) // #nosec G115 // cells are smaller than a page
+	if kOverflow {
+		kLen |= overflowFlag
+	}
+	if vOverflow {
+		vLen |= overflowFlag
+	}
+
 	cell := make([]byte, 4+len(k)+len(v))
-	binary.LittleEndian.PutUint16(cell[0:], uint16(len(k)))
-	binary.LittleEndian.PutUint16(cell[2:], uint16(len(v)))
+	binary.LittleEndian.PutUint16(cell[0:], kLen)
+	binary.LittleEndian.PutUint16(cell[2:], vLen)
 	copy(cell[4:], k)
 	copy(cell[4+len(k):], v)
 	return cell
 }
+
+// Returns the key of cell.
+func cellKey(cell []byte) []byte {
+	kLen := binary.LittleEndian.Uint16(cell) &^ overflowFlag
+	return cell[4 : 4+kLen]
+}
+
+// Reports weither the cells a and b hold the same key with the same overflow flag.
+func sameKey(a, b []byte) bool {
+	return binary.LittleEndian.Uint16(a) == binary.LittleEndian.Uint16(b) &&
+		bytes.Equal(cellKey(a), cellKey(b))
+}
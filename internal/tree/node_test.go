@@ -1,37 +1,207 @@
 package tree
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 )
 
+// Returns a leaf node holding the given keys with their keys as values.
+func testNode(t *testing.T, keys ...string) node {
+	t.Helper()
+	n := newNode(LeafPage)
+	for _, k := range keys {
+		i, _ := n.Search([]byte(k))
+		n = n.Set(i, []byte(k), []byte(k))
+	}
+	return n
+}
+
+func assertKeys(t *testing.T, n node, want ...string) {
+	t.Helper()
+	if n.N() != uint16(len(want)) {
+		t.Fatalf("want %d cells, got %d", len(want), n.N())
+	}
+	for i, k := range want {
+		if got := n.Key(uint16(i)); !bytes.Equal(got, []byte(k)) {
+			t.Fatalf("want key %q at %d, got %q", k, i, got)
+		}
+	}
+}
+
 func TestKey(t *testing.T) {
-	t.Error("not tested")
+	n := testNode(t, "b", "a", "c")
+	assertKeys(t, n, "a", "b", "c")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want panic on out of bounds index")
+		}
+	}()
+	n.Key(3)
 }
 
 func TestVal(t *testing.T) {
-	t.Error("not tested")
+	n := newNode(LeafPage)
+	n = n.Set(0, []byte("key"), []byte("value"))
+	if got := n.Val(0); !bytes.Equal(got, []byte("value")) {
+		t.Fatalf("want value %q, got %q", "value", got)
+	}
+
+	p := newNode(PointerPage)
+	p = p.Set(0, []byte("key"), pointerVal(3*PageSize))
+	if got := p.Pointer(0); got != 3*PageSize {
+		t.Fatalf("want pointer %d, got %d", 3*PageSize, got)
+	}
 }
 
 func TestSearch(t *testing.T) {
-	t.Error("not tested")
+	n := testNode(t, "b", "d", "f")
+
+	cases := []struct {
+		target string
+		i      uint16
+		exists bool
+	}{
+		{"a", 0, false},
+		{"b", 0, true},
+		{"c", 1, false},
+		{"d", 1, true},
+		{"f", 2, true},
+		{"g", 3, false},
+	}
+	for _, c := range cases {
+		i, exists := n.Search([]byte(c.target))
+		if i != c.i || exists != c.exists {
+			t.Errorf("Search(%q) = %d, %t, want %d, %t", c.target, i, exists, c.i, c.exists)
+		}
+	}
 }
 
 func TestSet(t *testing.T) {
-	t.Error("not tested")
+	n := testNode(t, "a", "c")
+
+	n = n.Set(1, []byte("b"), []byte("inserted"))
+	assertKeys(t, n, "a", "b", "c")
+
+	n = n.Set(2, []byte("c"), []byte("overwritten"))
+	assertKeys(t, n, "a", "b", "c")
+	if got := n.Val(2); !bytes.Equal(got, []byte("overwritten")) {
+		t.Fatalf("want overwritten value, got %q", got)
+	}
 }
 
 func TestCanSet(t *testing.T) {
-	t.Error("not tested")
+	n := newNode(LeafPage)
+	v := make([]byte, 1000)
+
+	var i uint16
+	for ; n.CanSet([]byte{byte(i)}, v); i++ {
+		n = n.Set(i, []byte{byte(i)}, v)
+	}
+	if n.voidSize() >= 6+1+len(v) {
+		t.Fatalf("CanSet returned false with %d bytes void left", n.voidSize())
+	}
+	if n.N() != (PageSize-dataOff)/(6+1+1000) {
+		t.Fatalf("want %d cells to fit, got %d", (PageSize-dataOff)/(6+1+1000), n.N())
+	}
 }
 
 func TestDelete(t *testing.T) {
-	t.Error("not tested")
+	n := testNode(t, "a", "b", "c")
+
+	n = n.Delete(1)
+	assertKeys(t, n, "a", "c")
+	n = n.Delete(1)
+	assertKeys(t, n, "a")
+	n = n.Delete(0)
+	assertKeys(t, n)
 }
 
 func TestSplit(t *testing.T) {
-	t.Error("not tested")
+	var keys []string
+	for i := range 100 {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	n := testNode(t, keys...)
+
+	left, right := n.Split()
+	if left.N() == 0 || right.N() == 0 {
+		t.Fatalf("want two non empty nodes, got %d and %d cells", left.N(), right.N())
+	}
+	if diff := int(left.N()) - int(right.N()); diff > 1 || diff < -1 {
+		t.Fatalf("want evenly split nodes, got %d and %d cells", left.N(), right.N())
+	}
+	assertKeys(t, left, keys[:left.N()]...)
+	assertKeys(t, right, keys[left.N():]...)
 }
 
 func TestVacuum(t *testing.T) {
-	t.Error("not tested")
+	n := testNode(t, "a", "b", "c")
+	n = n.Set(1, []byte("b"), []byte("overwritten"))
+	n = n.Delete(0)
+
+	before := n.voidSize()
+	n = n.Vacuum()
+	assertKeys(t, n, "b", "c")
+	if want := before + 6 + 6; n.voidSize() != want {
+		t.Fatalf("want %d bytes void after vacuum, got %d", want, n.voidSize())
+	}
+	if got := n.Val(0); !bytes.Equal(got, []byte("overwritten")) {
+		t.Fatalf("want overwritten value, got %q", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	left, right := testNode(t, "a", "b"), testNode(t, "c")
+	if !left.CanMerge(&right) {
+		t.Fatal("want small nodes to be mergeable")
+	}
+	assertKeys(t, left.Merge(&right), "a", "b", "c")
+
+	v := make([]byte, maxCellSize-7)
+	full := newNode(LeafPage)
+	for i := range 4 {
+		full = full.Set(uint16(i), []byte{byte(i)}, v)
+	}
+	if full.CanMerge(&right) {
+		t.Fatal("want full node not to be mergeable")
+	}
+}
+
+func TestRedistribute(t *testing.T) {
+	left, right := testNode(t, "a"), testNode(t, "b", "c", "d", "e", "f")
+
+	l, r := left.Redistribute(&right)
+	assertKeys(t, l, "a", "b", "c")
+	assertKeys(t, r, "d", "e", "f")
+}
+
+func TestOverflows(t *testing.T) {
+	n := newNode(LeafPage)
+	n = n.SetCell(0, makeFlaggedCell([]byte("a"), true, []byte("val"), false))
+	n = n.SetCell(1, makeFlaggedCell([]byte("b"), false, []byte("val"), true))
+
+	left, right := n.Split()
+	for _, c := range []struct {
+		n          node
+		key, val   bool
+		wantKey    string
+		wantVal    string
+		wantCellSz int
+	}{
+		{left, true, false, "a", "val", 8},
+		{right, false, true, "b", "val", 8},
+	} {
+		key, val := c.n.Overflows(0)
+		if key != c.key || val != c.val {
+			t.Errorf("Overflows() = %t, %t, want %t, %t", key, val, c.key, c.val)
+		}
+		if string(c.n.Key(0)) != c.wantKey || string(c.n.Val(0)) != c.wantVal {
+			t.Errorf("want %q-%q, got %q-%q", c.wantKey, c.wantVal, c.n.Key(0), c.n.Val(0))
+		}
+		if len(c.n.Cell(0)) != c.wantCellSz {
+			t.Errorf("want cell of %d bytes, got %d", c.wantCellSz, len(c.n.Cell(0)))
+		}
+	}
 }
@@ -0,0 +1,247 @@
+package tree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+/*
+Keys and values too large to be stored inside a single cell overflow onto a linked list of
+overflow pages, called an overflow chain. The cell then only holds a prefix of the key or value
+followed by a reference to the overflow chain holding the remaining bytes:
+
+	Description | Prefix | Len | Pointer
+	------------+--------+-----+--------
+	Size in B   | ?      | 4   | 8
+
+Len is the total length of the key or value including its prefix and Pointer the offset of the
+first page of the chain. Overflow pages are structured as follows:
+
+	Description | Type | Next | N | Data
+	------------+------+------+---+-----
+	Size in B   | 1    | 8    | 2 | N
+
+Next is the offset of the following page of the chain or 0 on the last page. Overflow chains are
+never modified, instead they are written once and freed as a whole once the cell referencing them is
+deleted or overwritten.
+
+The key of a PointerPage cell is always the key of the first cell of its child. Because of that
+pointer cells share the overflow chain of the leaf cell their key originates from.
+*/
+
+const (
+	overflowRefSize = 12
+
+	ovNextOff  = 1
+	ovNOff     = ovNextOff + 8
+	ovDataOff  = ovNOff + 2
+	ovDataSize = PageSize - ovDataOff
+)
+
+var ErrCorruptOverflow = errors.New("tree: corrupt overflow chain")
+
+// Returns the representation of k stored inside a cell and weither it overflows. Keys larger than
+// maxInlineKey are written onto a new overflow chain.
+func (t *Tree) storeKey(k []byte) ([]byte, bool, error) {
+	if len(k) <= maxInlineKey {
+		return k, false, nil
+	}
+	stored, err := t.writeOverflow(k, keyPrefixSize)
+	return stored, err == nil, err
+}
+
+// Returns the representation of v stored inside a cell next to the stored key sk and weither it
+// overflows. Values that would exceed the maximum cell size are written onto a new overflow chain.
+func (t *Tree) storeVal(sk, v []byte) ([]byte, bool, error) {
+	if 6+len(sk)+len(v) <= maxCellSize {
+		return v, false, nil
+	}
+	stored, err := t.writeOverflow(v, valPrefixSize)
+	return stored, err == nil, err
+}
+
+// Writes everything but the first prefix bytes of d onto a new overflow chain and returns the
+// prefix followed by the reference to the chain. The chain is written back to front so that every
+// page already knows the offset of its successor when it is allocated.
+func (t *Tree) writeOverflow(d []byte, prefix int) ([]byte, error) {
+	if uint64(len(d)) > math.MaxUint32 {
+		return nil, ErrValTooLarge
+	}
+
+	var next int64
+	rest := d[prefix:]
+	for end := len(rest); end > 0; end -= ovDataSize {
+		start := max(0, end-ovDataSize)
+
+		var page [PageSize]byte
+		page[0] = byte(OverflowPage)
+		binary.LittleEndian.PutUint64(page[ovNextOff:], uint64(next))   // #nosec G115
+		binary.LittleEndian.PutUint16(page[ovNOff:], uint16(end-start)) // #nosec G115
+		copy(page[ovDataOff:], rest[start:end])
+
+		ptr, err := t.pager.Alloc(page)
+		if err != nil {
+			return nil, fmt.Errorf("tree: failed to allocate overflow page: %w", err)
+		}
+		next = ptr
+	}
+
+	stored := make([]byte, prefix+overflowRefSize)
+	copy(stored, d[:prefix])
+	binary.LittleEndian.PutUint32(stored[prefix:], uint32(len(d))) // #nosec G115 // checked above
+	binary.LittleEndian.PutUint64(stored[prefix+4:], uint64(next)) // #nosec G115
+	return stored, nil
+}
+
+// Returns the full key or value from its stored representation holding an overflow reference.
+func (t *Tree) readOverflow(stored []byte) ([]byte, error) {
+	prefix, total, ptr := parseOverflowRef(stored)
+
+	d := make([]byte, 0, total)
+	d = append(d, prefix...)
+	for ptr != 0 {
+		page, err := t.readOverflowPage(ptr)
+		if err != nil {
+			return nil, err
+		}
+		n := binary.LittleEndian.Uint16(page[ovNOff:])
+		if int(n) > ovDataSize || len(d)+int(n) > total {
+			return nil, ErrCorruptOverflow
+		}
+		d = append(d, page[ovDataOff:ovDataOff+int(n)]...)
+		ptr = int64(binary.LittleEndian.Uint64(page[ovNextOff:])) // #nosec G115
+	}
+	if len(d) != total {
+		return nil, ErrCorruptOverflow
+	}
+	return d, nil
+}
+
+// Frees all pages of the overflow chain referenced by the stored representation of a key or value.
+func (t *Tree) freeOverflow(stored []byte) error {
+	_, _, ptr := parseOverflowRef(stored)
+	for ptr != 0 {
+		page, err := t.readOverflowPage(ptr)
+		if err != nil {
+			return err
+		}
+		if err := t.pager.Free(ptr); err != nil {
+			return fmt.Errorf("tree: failed to free overflow page: %w", err)
+		}
+		ptr = int64(binary.LittleEndian.Uint64(page[ovNextOff:])) // #nosec G115
+	}
+	return nil
+}
+
+// Frees the overflow chains of the key and value of the i'th cell of the leaf n.
+func (t *Tree) freeCell(n *node, i uint16) error {
+	kOverflow, vOverflow := n.Overflows(i)
+	if kOverflow {
+		if err := t.freeOverflow(n.Key(i)); err != nil {
+			return err
+		}
+	}
+	if vOverflow {
+		return t.freeOverflow(n.Val(i))
+	}
+	return nil
+}
+
+func (t *Tree) readOverflowPage(ptr int64) ([PageSize]byte, error) {
+	page, err := t.pager.ReadPage(ptr)
+	if err != nil {
+		return page, fmt.Errorf("tree: failed to read overflow page: %w", err)
+	}
+	if PageType(page[0]) != OverflowPage {
+		return page, ErrCorruptOverflow
+	}
+	return page, nil
+}
+
+// Returns the full key of the i'th cell of n, reassembling it from its overflow chain if necessary.
+func (t *Tree) key(n *node, i uint16) ([]byte, error) {
+	if kOverflow, _ := n.Overflows(i); kOverflow {
+		return t.readOverflow(n.Key(i))
+	}
+	return n.Key(i), nil
+}
+
+// Returns the full value of the i'th cell of n, reassembling it from its overflow chain if
+// necessary.
+func (t *Tree) val(n *node, i uint16) ([]byte, error) {
+	if _, vOverflow := n.Overflows(i); vOverflow {
+		return t.readOverflow(n.Val(i))
+	}
+	return n.Val(i), nil
+}
+
+// Binary searches the target key inside n like node.Search but using the comparator of t. Overflowing
+// keys are compared by their prefix first and only reassembled if the prefix is not sufficient to
+// decide the order, which is only possible for bytewise ordered trees.
+func (t *Tree) search(n *node, target []byte) (uint16, bool, error) {
+	if !t.bytewise() {
+		var err error
+		i, exists := n.SearchFunc(func(i uint16) int {
+			if err != nil {
+				return 0
+			}
+			var k []byte
+			k, err = t.key(n, i)
+			if err != nil {
+				return 0
+			}
+			return t.compare(k, target)
+		})
+		return i, exists, err
+	}
+
+	prefix := n.Prefix()
+	if !bytes.HasPrefix(target, prefix) {
+		if bytes.Compare(target, prefix) < 0 {
+			return 0, false, nil
+		}
+		return n.N(), false, nil
+	}
+
+	var err error
+	suffix := target[len(prefix):]
+	i, exists := n.SearchFunc(func(i uint16) int {
+		if err != nil {
+			return 0
+		}
+		if kOverflow, _ := n.Overflows(i); !kOverflow {
+			return bytes.Compare(n.suffix(i), suffix)
+		}
+		var cmp int
+		cmp, err = t.compareOverflow(n.Key(i), target)
+		return cmp
+	})
+	return i, exists, err
+}
+
+// Compares the full key of the stored overflowing key k to target.
+func (t *Tree) compareOverflow(k, target []byte) (int, error) {
+	prefix, _, _ := parseOverflowRef(k)
+	if cmp := bytes.Compare(prefix, target[:min(len(prefix), len(target))]); cmp != 0 {
+		return cmp, nil
+	}
+	if len(target) <= len(prefix) {
+		return 1, nil
+	}
+
+	full, err := t.readOverflow(k)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Compare(full, target), nil
+}
+
+func parseOverflowRef(stored []byte) (prefix []byte, total int, ptr int64) {
+	p := len(stored) - overflowRefSize
+	total = int(binary.LittleEndian.Uint32(stored[p:]))
+	ptr = int64(binary.LittleEndian.Uint64(stored[p+4:])) // #nosec G115
+	return stored[:p], total, ptr
+}
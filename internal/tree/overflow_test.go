@@ -0,0 +1,68 @@
+package tree_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// Returns a key of roughly size bytes sharing a long prefix with all other large keys.
+func largeKey(i, size int) []byte {
+	return append(bytes.Repeat([]byte("p"), size), fmt.Appendf(nil, "%06d", i)...)
+}
+
+func TestTree_overflow(t *testing.T) {
+	const n = 200
+	p := newMemPager()
+	tr := tree.New(p, 0)
+
+	sizes := []int{10, 1000, 1020, 5000, 3 * tree.PageSize}
+	for i := range n {
+		k := largeKey(i, sizes[i%len(sizes)])
+		if err := tr.Set(k, largeKey(i, sizes[(i+1)%len(sizes)])); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+
+	for i := range n {
+		k := largeKey(i, sizes[i%len(sizes)])
+		got, err := tr.Get(k)
+		if err != nil || !bytes.Equal(got, largeKey(i, sizes[(i+1)%len(sizes)])) {
+			t.Fatalf("Get(%d) = %d bytes, %v", i, len(got), err)
+		}
+		if _, err := tr.Get(k[:len(k)-1]); err == nil {
+			t.Fatalf("Get() of prefix of key %d succeeded unexpectedly", i)
+		}
+	}
+
+	c := tr.Cursor()
+	var count int
+	var prev []byte
+	for ok := c.First(); ok; ok = c.Next() {
+		if prev != nil && bytes.Compare(prev, c.Key()) >= 0 {
+			t.Fatalf("want keys in order, got %d bytes after %d bytes", len(c.Key()), len(prev))
+		}
+		prev = bytes.Clone(c.Key())
+		count++
+	}
+	if c.Err() != nil || count != n {
+		t.Fatalf("want %d keys iterating, got %d, %v", n, count, c.Err())
+	}
+
+	for i := range n {
+		k := largeKey(i, sizes[i%len(sizes)])
+		if err := tr.Set(k, []byte("small")); err != nil {
+			t.Fatalf("Set() failed: %v", err)
+		}
+	}
+	for i := range n {
+		if err := tr.Delete(largeKey(i, sizes[i%len(sizes)])); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", i, err)
+		}
+	}
+	if len(p.pages) != 0 {
+		t.Fatalf("want all overflow pages freed, got %d pages", len(p.pages))
+	}
+}
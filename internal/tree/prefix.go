@@ -0,0 +1,137 @@
+package tree
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+/*
+Keys of composite indexes often share long prefixes, which a regular node would store over and over
+again in every cell. Nodes of type PrefixPointerPage and PrefixLeafPage instead store the prefix
+shared by all of their keys only once, directly behind the header:
+
+	Description | Header | PrefixLen | Prefix    | Data area
+	------------+--------+-----------+-----------+----------
+	Size in B   | 5      | 2         | PrefixLen | ?
+
+The cells of a prefix compressed node only hold the suffix of their key following the prefix,
+everything else about the data area is identical to regular nodes.
+
+The prefix is calculated whenever a node is built from its cells, which is the case for split,
+merged, redistributed and vacuumed nodes, as the longest prefix shared by its first and last key.
+Since the keys are sorted that is the prefix shared by all keys. Setting a key that does not share
+the prefix rebuilds the node with a shorter prefix. The prefix never extends into the overflow
+reference of an overflowing key, so that the prefix is also always a prefix of the full keys.
+*/
+
+const (
+	prefixLenOff = dataOff
+	prefixOff    = prefixLenOff + 2
+)
+
+// Returns the prefix shared by all keys of n, which is always empty for nodes that are not prefix
+// compressed.
+func (n *node) Prefix() []byte {
+	if !n.isPrefixed() {
+		return nil
+	}
+	l := binary.LittleEndian.Uint16(n[prefixLenOff:])
+	return n[prefixOff : prefixOff+l]
+}
+
+func (n *node) isPrefixed() bool {
+	return isPrefixed(n.Type())
+}
+
+func isPrefixed(typ PageType) bool {
+	return typ == PrefixPointerPage || typ == PrefixLeafPage
+}
+
+// Returns a new node of type typ holding cells, which have to be sorted and fit into a single node.
+func buildNode(typ PageType, cells [][]byte) node {
+	n := newNode(typ)
+
+	var prefix []byte
+	if isPrefixed(typ) && len(cells) > 0 {
+		prefix = commonPrefix(comparableKey(cells[0]), comparableKey(cells[len(cells)-1]))
+		binary.LittleEndian.PutUint16(n[prefixLenOff:], uint16(len(prefix))) // #nosec G115
+		copy(n[prefixOff:], prefix)
+	}
+
+	for _, cell := range cells {
+		n.appendCell(stripPrefix(cell, len(prefix)))
+	}
+	return n
+}
+
+// Returns the number of bytes of the data area a node of type typ built from cells would take up.
+func encodedSize(typ PageType, cells [][]byte) int {
+	var size int
+	for _, cell := range cells {
+		size += 2 + len(cell)
+	}
+	if !isPrefixed(typ) || len(cells) == 0 {
+		return size
+	}
+
+	prefix := commonPrefix(comparableKey(cells[0]), comparableKey(cells[len(cells)-1]))
+	return size - len(cells)*len(prefix) + 2 + len(prefix)
+}
+
+// Returns the index splitting cells into two nodes of type typ whose sizes are as balanced as
+// possible. The sizes take the prefixes of both nodes into account.
+func splitPoint(typ PageType, cells [][]byte) int {
+	sums := make([]int, len(cells)+1)
+	for i, cell := range cells {
+		sums[i+1] = sums[i] + 2 + len(cell)
+	}
+
+	size := func(from, to int) int {
+		size := sums[to] - sums[from]
+		if isPrefixed(typ) {
+			l := len(commonPrefix(comparableKey(cells[from]), comparableKey(cells[to-1])))
+			size += 2 + l - (to-from)*l
+		}
+		return size
+	}
+
+	mid, best := 1, math.MaxInt
+	for i := 1; i < len(cells); i++ {
+		if s := max(size(0, i), size(i, len(cells))); s < best {
+			mid, best = i, s
+		}
+	}
+	return mid
+}
+
+// Returns the part of the key of cell that may be part of a node prefix, which is the whole key for
+// regular keys and the inline prefix of the key for overflowing keys.
+func comparableKey(cell []byte) []byte {
+	k := cellKey(cell)
+	if binary.LittleEndian.Uint16(cell)&overflowFlag != 0 {
+		return k[:len(k)-overflowRefSize]
+	}
+	return k
+}
+
+// Returns a copy of cell with the first l bytes of its key removed.
+func stripPrefix(cell []byte, l int) []byte {
+	if l == 0 {
+		return cell
+	}
+
+	kLen := binary.LittleEndian.Uint16(cell)
+	stripped := make([]byte, len(cell)-l)
+	binary.LittleEndian.PutUint16(stripped, kLen-uint16(l)) // #nosec G115 // prefixes are short
+	copy(stripped[2:], cell[2:4])
+	copy(stripped[4:], cell[4+l:])
+	return stripped
+}
+
+func commonPrefix(a, b []byte) []byte {
+	l := 0
+	for l < len(a) && l < len(b) && a[l] == b[l] {
+		l++
+	}
+	return a[:l]
+}
@@ -0,0 +1,104 @@
+package tree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// Returns a prefix compressed leaf node built from keys sharing a long common prefix.
+func testPrefixNode(t *testing.T, n int) (node, [][]byte) {
+	t.Helper()
+	var keys, cells [][]byte
+	for i := range n {
+		k := fmt.Appendf(nil, "table/0001/index/0002/%06d", i)
+		keys = append(keys, k)
+		cells = append(cells, makeCell(k, []byte("v")))
+	}
+	return buildNode(PrefixLeafPage, cells), keys
+}
+
+func TestPrefix(t *testing.T) {
+	n, keys := testPrefixNode(t, 100)
+	if got := string(n.Prefix()); got != "table/0001/index/0002/0000" {
+		t.Fatalf("want prefix %q, got %q", "table/0001/index/0002/0000", got)
+	}
+	for i, k := range keys {
+		if !bytes.Equal(n.Key(uint16(i)), k) {
+			t.Fatalf("want key %q at %d, got %q", k, i, n.Key(uint16(i)))
+		}
+	}
+
+	plain := buildNode(LeafPage, n.cells())
+	if n.voidSize() <= plain.voidSize() {
+		t.Fatalf("want prefix compressed node to use less space, got %d and %d bytes void",
+			n.voidSize(), plain.voidSize())
+	}
+}
+
+func TestPrefix_Search(t *testing.T) {
+	n, keys := testPrefixNode(t, 100)
+
+	cases := []struct {
+		target string
+		i      uint16
+		exists bool
+	}{
+		{string(keys[42]), 42, true},
+		{string(keys[42]) + "0", 43, false},
+		{"table", 0, false},
+		{"table/0001/index/0002/000000", 0, true},
+		{"table/0001/index/0001/999999", 0, false},
+		{"table/0001/index/0003", 100, false},
+	}
+	for _, c := range cases {
+		i, exists := n.Search([]byte(c.target))
+		if i != c.i || exists != c.exists {
+			t.Errorf("Search(%q) = %d, %t, want %d, %t", c.target, i, exists, c.i, c.exists)
+		}
+	}
+}
+
+func TestPrefix_SetCell(t *testing.T) {
+	n, _ := testPrefixNode(t, 10)
+
+	n = n.SetCell(10, makeCell([]byte("table/0001/index/0002/000010"), []byte("v")))
+	if got := string(n.Prefix()); got != "table/0001/index/0002/0000" {
+		t.Fatalf("want prefix to stay %q, got %q", "table/0001/index/0002/0000", got)
+	}
+
+	n = n.SetCell(11, makeCell([]byte("table/0002"), []byte("v")))
+	if got := string(n.Prefix()); got != "table/000" {
+		t.Fatalf("want prefix to shrink to %q, got %q", "table/000", got)
+	}
+	if n.N() != 12 || string(n.Key(11)) != "table/0002" || string(n.Key(0)) != "table/0001/index/0002/000000" {
+		t.Fatalf("want all keys after rebuild, got %d keys", n.N())
+	}
+
+	n = n.Delete(11)
+	n = n.Vacuum()
+	if got := string(n.Prefix()); got != "table/0001/index/0002/0000" {
+		t.Fatalf("want vacuum to restore prefix %q, got %q", "table/0001/index/0002/0000", got)
+	}
+}
+
+func TestPrefix_Split(t *testing.T) {
+	n, keys := testPrefixNode(t, 200)
+
+	left, right := n.Split()
+	if left.Type() != PrefixLeafPage || right.Type() != PrefixLeafPage {
+		t.Fatalf("want split nodes to keep their type, got %d and %d", left.Type(), right.Type())
+	}
+	if len(left.Prefix()) < len(n.Prefix()) || len(right.Prefix()) < len(n.Prefix()) {
+		t.Fatalf("want split nodes to have at least prefix %q, got %q and %q", n.Prefix(), left.Prefix(), right.Prefix())
+	}
+	for i, k := range keys {
+		got := left.Key(uint16(i) % left.N())
+		if i >= int(left.N()) {
+			got = right.Key(uint16(i) - left.N())
+		}
+		if !bytes.Equal(got, k) {
+			t.Fatalf("want key %q at %d, got %q", k, i, got)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package tree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Pagers can only shrink their file by the free pages at its end. Relocate moves the pages of a tree
+stored at or behind a limit onto newly allocated pages, which a pager reusing the lowest free offsets
+first places in front of the limit, so that everything behind it becomes free.
+
+Like every write, relocation never modifies a page in place. The tree is walked depth first and every
+node is copied if it is stored behind the limit or one of the pages it references moved, which
+copies the path from every moved page up to the root. Pointer cells are rebuilt from their copied
+children, so that they keep sharing the overflow chains of the keys they originate from. Overflow
+chains are immutable and always moved as a whole.
+*/
+
+type relocation struct {
+	limit int64
+	// The number of pages moved in front of limit and the maximum number of pages to move.
+	moved, max int
+}
+
+// Moves up to about n pages of t, including overflow pages, that are stored at offsets of at least
+// limit onto newly allocated pages and returns the number of pages that ended up in front of limit.
+// Overflow chains are moved as a whole, which may exceed n. Every node referencing a moved page is
+// copied as well, up to the root.
+func (t *Tree) Relocate(limit int64, n int) (int, error) {
+	if t.readOnly {
+		return 0, ErrReadOnly
+	}
+	if t.root == 0 || n <= 0 {
+		return 0, nil
+	}
+
+	r := &relocation{limit: limit, max: n}
+	root, _, err := t.relocate(t.root, r)
+	if err != nil {
+		return r.moved, err
+	}
+	t.root = root
+	return r.moved, nil
+}
+
+// Relocates the subtree of the node stored at ptr and returns the offset the node is stored at
+// afterwards together with the node itself.
+func (t *Tree) relocate(ptr int64, r *relocation) (int64, node, error) {
+	n, err := t.read(ptr)
+	if err != nil {
+		return 0, n, err
+	}
+
+	// The cells of n, which are only set once one of them changed.
+	var cells [][]byte
+	switch n.Kind() {
+	case PointerPage:
+		for i := uint16(0); i < n.N() && r.moved < r.max; i++ {
+			child, c, err := t.relocate(n.Pointer(i), r)
+			if err != nil {
+				return 0, n, err
+			}
+			if child != n.Pointer(i) {
+				if cells == nil {
+					cells = n.cells()
+				}
+				cells[i] = t.pointerCell(&c, child)
+			}
+		}
+	case LeafPage:
+		for i := uint16(0); i < n.N() && r.moved < r.max; i++ {
+			cell, err := t.relocateCell(&n, i, r)
+			if err != nil {
+				return 0, n, err
+			}
+			if cell != nil {
+				if cells == nil {
+					cells = n.cells()
+				}
+				cells[i] = cell
+			}
+		}
+	default:
+		return 0, n, ErrInvalidPageType
+	}
+
+	if cells != nil {
+		n = buildNode(n.Type(), cells)
+	} else if ptr < r.limit || r.moved >= r.max {
+		return ptr, n, nil
+	}
+
+	moved, err := t.pager.Alloc(n)
+	if err != nil {
+		return 0, n, fmt.Errorf("tree: failed to allocate page: %w", err)
+	}
+	if err := t.pager.Free(ptr); err != nil {
+		return 0, n, fmt.Errorf("tree: failed to free page: %w", err)
+	}
+	if ptr >= r.limit && moved < r.limit {
+		r.moved++
+	}
+	return moved, n, nil
+}
+
+// Returns the i'th cell of the leaf n with its overflow chains relocated or nil if none of them
+// moved.
+func (t *Tree) relocateCell(n *node, i uint16, r *relocation) ([]byte, error) {
+	kOverflow, vOverflow := n.Overflows(i)
+	k, v := n.Key(i), n.Val(i)
+	moved := false
+	if kOverflow {
+		stored, err := t.relocateOverflow(k, r)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			k, moved = stored, true
+		}
+	}
+	if vOverflow {
+		stored, err := t.relocateOverflow(v, r)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			v, moved = stored, true
+		}
+	}
+	if !moved {
+		return nil, nil
+	}
+	return makeFlaggedCell(k, kOverflow, v, vOverflow), nil
+}
+
+// Returns the stored representation of an overflowing key or value rewritten onto a new overflow
+// chain if any page of its chain is stored at or behind the limit, otherwise nil.
+func (t *Tree) relocateOverflow(stored []byte, r *relocation) ([]byte, error) {
+	prefix, _, ptr := parseOverflowRef(stored)
+	behind := 0
+	for ptr != 0 {
+		page, err := t.readOverflowPage(ptr)
+		if err != nil {
+			return nil, err
+		}
+		if ptr >= r.limit {
+			behind++
+		}
+		ptr = int64(binary.LittleEndian.Uint64(page[ovNextOff:])) // #nosec G115
+	}
+	if behind == 0 {
+		return nil, nil
+	}
+
+	d, err := t.readOverflow(stored)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.freeOverflow(stored); err != nil {
+		return nil, err
+	}
+	moved, err := t.writeOverflow(d, len(prefix))
+	if err != nil {
+		return nil, err
+	}
+	r.moved += behind
+	return moved, nil
+}
+
+// Relocates the pages of all trees of c and of its directory like Tree.Relocate and returns the
+// number of pages that ended up in front of limit. The new roots of the trees are synced into the
+// directory before it is relocated itself.
+func (c *Catalog) Relocate(limit int64, n int) (int, error) {
+	names, err := c.List()
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, name := range names {
+		if moved >= n {
+			break
+		}
+		ct, err := c.open(name)
+		if err != nil {
+			return moved, err
+		}
+		c.trees[name] = ct
+		m, err := ct.tree.Relocate(limit, n-moved)
+		moved += m
+		if err != nil {
+			return moved, err
+		}
+	}
+	if err := c.Sync(); err != nil {
+		return moved, err
+	}
+
+	m, err := c.dir.Relocate(limit, n-moved)
+	return moved + m, err
+}
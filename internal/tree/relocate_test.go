@@ -0,0 +1,175 @@
+package tree_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gkits/pavosql/internal/tree"
+)
+
+// A reusingPager allocates the lowest free offset first like the pager of a database.
+type reusingPager struct {
+	*memPager
+}
+
+func (p reusingPager) Alloc(page [tree.PageSize]byte) (int64, error) {
+	for off := int64(tree.PageSize); off < p.next; off += tree.PageSize {
+		if _, ok := p.pages[off]; !ok {
+			p.pages[off] = page
+			return off, nil
+		}
+	}
+	return p.memPager.Alloc(page)
+}
+
+// Returns the offset behind the last page p would need if it had no free pages.
+func (p reusingPager) limit() int64 {
+	return int64(1+len(p.pages)) * tree.PageSize
+}
+
+// Returns the offset behind the last allocated page of p.
+func (p reusingPager) end() int64 {
+	end := int64(tree.PageSize)
+	for off := range p.pages {
+		end = max(end, off+tree.PageSize)
+	}
+	return end
+}
+
+// Returns the key and value of the i'th k-v pair of a tree holding overflowing keys and values.
+func testOverflowPair(i int) ([]byte, []byte) {
+	k, v := testKey(i), testVal(i)
+	switch i % 100 {
+	case 1:
+		k = append(bytes.Repeat([]byte("k"), 2000), k...)
+	case 2:
+		v = bytes.Repeat(v, 200)
+	}
+	return k, v
+}
+
+func TestTree_Relocate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []tree.Option
+	}{
+		{"plain", nil},
+		{"prefix compression", []tree.Option{tree.PrefixCompression()}},
+		{"subtree counts", []tree.Option{tree.SubtreeCounts()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := reusingPager{newMemPager()}
+			tr := tree.New(p, 0, tt.opts...)
+			for i := range 5000 {
+				k, v := testOverflowPair((i * 7919) % 5000)
+				if err := tr.Set(k, v); err != nil {
+					t.Fatalf("Set(%q) failed: %v", k, err)
+				}
+			}
+			// Deleting the first keys leaves holes in front of the pages of the remaining ones.
+			for i := range 4000 {
+				k, _ := testOverflowPair(i)
+				if err := tr.Delete(k); err != nil {
+					t.Fatalf("Delete(%q) failed: %v", k, err)
+				}
+			}
+
+			steps := 0
+			for ; ; steps++ {
+				moved, err := tr.Relocate(p.limit(), 50)
+				if err != nil {
+					t.Fatalf("Relocate() failed: %v", err)
+				}
+				if moved == 0 {
+					break
+				}
+			}
+			if steps < 2 {
+				t.Fatalf("want relocation in more than one step, got %d", steps)
+			}
+			if p.end() != p.limit() {
+				t.Fatalf("want all pages in front of %d, got end %d", p.limit(), p.end())
+			}
+
+			if rep := tr.Check(); !rep.OK() || rep.Keys != 1000 || rep.Pages != len(p.pages) {
+				t.Fatalf("want 1000 keys on %d pages without violations, got %s", len(p.pages),
+					rep)
+			}
+			for i := 4000; i < 5000; i++ {
+				k, v := testOverflowPair(i)
+				if got, err := tr.Get(k); err != nil || !bytes.Equal(got, v) {
+					t.Fatalf("Get(%q) = %q, %v, want %q", k, got, err, v)
+				}
+			}
+		})
+	}
+}
+
+func TestTree_Relocate_readOnly(t *testing.T) {
+	p := reusingPager{newMemPager()}
+	c := tree.OpenCatalog(p, 0)
+	tr, err := c.Create("users")
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if err := c.Drop("users"); err != nil {
+		t.Fatalf("Drop() failed: %v", err)
+	}
+	if _, err := tr.Relocate(0, 1); !errors.Is(err, tree.ErrReadOnly) {
+		t.Fatalf("want ErrReadOnly, got %v", err)
+	}
+}
+
+func TestCatalog_Relocate(t *testing.T) {
+	p := reusingPager{newMemPager()}
+	c := tree.OpenCatalog(p, 0)
+	var names []string
+	for i := range 10 {
+		name := fmt.Sprint("tree", i)
+		names = append(names, name)
+		tr, err := c.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%q) failed: %v", name, err)
+		}
+		for j := range 1000 {
+			if err := tr.Set(testKey(j), []byte(name)); err != nil {
+				t.Fatalf("Set(%q) failed: %v", testKey(j), err)
+			}
+		}
+	}
+	for _, name := range names[:8] {
+		if err := c.Drop(name); err != nil {
+			t.Fatalf("Drop(%q) failed: %v", name, err)
+		}
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+
+	for {
+		moved, err := c.Relocate(p.limit(), 10)
+		if err != nil {
+			t.Fatalf("Relocate() failed: %v", err)
+		}
+		if moved == 0 {
+			break
+		}
+	}
+	if p.end() != p.limit() {
+		t.Fatalf("want all pages in front of %d, got end %d", p.limit(), p.end())
+	}
+
+	reopened := tree.OpenCatalog(p, c.Root())
+	for _, name := range names[8:] {
+		tr, err := reopened.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%q) failed: %v", name, err)
+		}
+		if rep := tr.Check(); !rep.OK() || rep.Keys != 1000 {
+			t.Fatalf("want 1000 keys in %q without violations, got %s", name, rep)
+		}
+	}
+}
@@ -3,9 +3,18 @@ package tree
 import (
 	"errors"
 	"fmt"
+	"math"
 	"slices"
 )
 
+var (
+	ErrKeyNotFound     = errors.New("tree: key not found")
+	ErrKeyTooLarge     = errors.New("tree: key exceeds maximum key size")
+	ErrValTooLarge     = errors.New("tree: value exceeds maximum value size")
+	ErrReadOnly        = errors.New("tree: cannot write onto read only tree")
+	ErrInvalidPageType = errors.New("tree: invalid page type")
+)
+
 type pager interface {
 	ReadPage(int64) ([PageSize]byte, error)
 	Alloc([PageSize]byte) (int64, error)
@@ -18,107 +27,382 @@ type Tree struct {
 	root     int64
 	pager    pager
 	readOnly bool
+
+	cmp               Comparator
+	prefixCompression bool
+	subtreeCounts     bool
+	fillFactor        float64
+}
+
+// An Option configures a Tree.
+type Option func(*Tree)
+
+// Creates new nodes in the prefix compressed PrefixPointerPage and PrefixLeafPage format, which
+// stores the prefix shared by all keys of a node only once. Existing nodes keep their format, nodes
+// split off from them as well.
+func PrefixCompression() Option {
+	return func(t *Tree) { t.prefixCompression = true }
+}
+
+// Returns a new Tree reading and writing its pages through p with its root node stored at the page
+// root. A root of 0 denotes an empty tree.
+func New(p pager, root int64, opts ...Option) *Tree {
+	t := &Tree{root: root, pager: p, cmp: BytewiseComparator, fillFactor: defaultFillFactor}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
-func New() *Tree {
-	return &Tree{}
+// Returns the offset of the page the root node of t is stored on. The root changes with every write
+// onto t and is 0 if t is empty.
+func (t *Tree) Root() int64 {
+	return t.root
 }
 
 func (t *Tree) Get(k []byte) ([]byte, error) {
-	page, err := t.pager.ReadPage(t.root)
+	if t.root == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	cur, err := t.read(t.root)
 	if err != nil {
 		return nil, err
 	}
-	cur := node(page)
 
 	for {
-		i, exists := cur.Search(k)
+		i, exists, err := t.search(&cur, k)
+		if err != nil {
+			return nil, err
+		}
 
-		switch cur.Type() {
+		switch cur.Kind() {
 		case PointerPage:
-			ptr := cur.Pointer(i)
-			page, err = t.pager.ReadPage(ptr)
+			cur, err = t.read(cur.Pointer(childIndex(i, exists)))
 			if err != nil {
-				return nil, fmt.Errorf("tree: failed to read page: %w", err)
+				return nil, err
 			}
-			cur = node(page)
 		case LeafPage:
 			if !exists {
-				return nil, errors.New("key does not exists on leaf node")
+				return nil, ErrKeyNotFound
 			}
-			return cur.Val(i), nil
+			return t.val(&cur, i)
 		default:
-			return nil, errors.New("invalid page type")
+			return nil, ErrInvalidPageType
 		}
 	}
 }
 
+// Sets the value of k to v. Set never modifies a page in place, instead every node on the path from
+// the root to the leaf holding k is copied, written to a newly allocated page and the page of the
+// superseded node is freed. Once Set returns without error the root of t points to the new version
+// of the tree.
+//
+// Keys and values too large to fit into a single cell are stored on overflow pages.
 func (t *Tree) Set(k []byte, v []byte) error {
 	if t.readOnly {
-		return errors.New("tree: cannot write onto read only tree")
+		return ErrReadOnly
+	}
+	if uint64(len(k)) > math.MaxUint32 {
+		return ErrKeyTooLarge
+	}
+	if uint64(len(v)) > math.MaxUint32 {
+		return ErrValTooLarge
+	}
+
+	if t.root == 0 {
+		nodes, err := t.set(newNode(t.pageType(LeafPage)), k, v)
+		if err != nil {
+			return err
+		}
+		return t.setRoot(0, nodes)
 	}
-	page, err := t.pager.ReadPage(t.root)
+
+	root, err := t.read(t.root)
 	if err != nil {
 		return fmt.Errorf("tree: failed to read root page: %w", err)
 	}
-	cur := node(page)
 
-	visited := []node{cur}
-	for {
-		i, exists := cur.Search(k)
+	nodes, err := t.set(root, k, v)
+	if err != nil {
+		return err
+	}
+	return t.setRoot(t.root, nodes)
+}
 
-		switch cur.Type() {
-		case PointerPage:
-			ptr := cur.Pointer(i)
-			page, err = t.pager.ReadPage(ptr)
-			if err != nil {
-				return fmt.Errorf("tree: failed to read page: %w", err)
-			}
-			cur = node(page)
-			visited = append(visited, cur)
-			continue
+// Deletes k from t. Like Set, Delete copies every node on the path from the root to the leaf holding
+// k. Nodes falling below a minimum fill level are merged with one of their siblings or, if both
+// don't fit into a single node, the cells of both are redistributed evenly. A root node left with a
+// single child is replaced by that child.
+//
+// Returns ErrKeyNotFound if k does not exist.
+func (t *Tree) Delete(k []byte) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if t.root == 0 {
+		return ErrKeyNotFound
+	}
 
-		case LeafPage:
-			if !exists {
-				return errors.New("key does not exists on leaf node")
-			}
+	root, err := t.read(t.root)
+	if err != nil {
+		return fmt.Errorf("tree: failed to read root page: %w", err)
+	}
 
-			if cur.CanSet(k, v) {
-				newNode := cur.Set(i, k, v)
-				ptr, err := t.pager.Alloc(newNode)
-				if err != nil {
-					return fmt.Errorf("tree: failed to allocate page: %w", err)
-				}
-				// TODO: pass ptr to parent node
-				_ = ptr
-				break
-			}
+	nodes, err := t.delete(root, k)
+	if err != nil {
+		return err
+	}
 
-			// TODO: handle splitting
-			left, right := cur.Split()
-			_, _ = left, right
+	if len(nodes) > 1 {
+		return t.setRoot(t.root, nodes)
+	}
 
-		default:
-			return errors.New("invalid page type")
+	switch n := nodes[0]; {
+	case n.N() == 0:
+		err = t.pager.Free(t.root)
+		t.root = 0
+	case n.Kind() == PointerPage && n.N() == 1:
+		err = t.pager.Free(t.root)
+		t.root = n.Pointer(0)
+	default:
+		return t.setRoot(t.root, nodes)
+	}
+	if err != nil {
+		return fmt.Errorf("tree: failed to free page: %w", err)
+	}
+	return nil
+}
+
+// Returns the nodes replacing n after deleting k from the subtree of n.
+func (t *Tree) delete(n node, k []byte) ([]node, error) {
+	i, exists, err := t.search(&n, k)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Kind() {
+	case PointerPage:
+		i = childIndex(i, exists)
+		child, err := t.read(n.Pointer(i))
+		if err != nil {
+			return nil, err
 		}
-		break
+
+		children, err := t.delete(child, k)
+		if err != nil {
+			return nil, err
+		}
+		if len(children) > 1 || children[0].used() >= minNodeSize || n.N() == 1 {
+			return t.replaceChildren(n, i, 1, children)
+		}
+		return t.rebalance(n, i, children[0])
+
+	case LeafPage:
+		if !exists {
+			return nil, ErrKeyNotFound
+		}
+		if err := t.freeCell(&n, i); err != nil {
+			return nil, err
+		}
+		return []node{n.Delete(i)}, nil
+
+	default:
+		return nil, ErrInvalidPageType
 	}
+}
 
-	for _, n := range slices.Backward(visited) {
-		ptr, err := t.pager.Alloc(n)
+// Returns the nodes replacing the pointer node n after replacing its under-filled i'th child with
+// child and merging it with or redistributing its cells onto one of its siblings.
+func (t *Tree) rebalance(n node, i uint16, child node) ([]node, error) {
+	if i+1 < n.N() {
+		sibling, err := t.read(n.Pointer(i + 1))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// TODO: pass ptr to parent nodes
-		_ = ptr
+		return t.replaceChildren(n, i, 2, balance(child, sibling))
 	}
 
-	return nil
+	sibling, err := t.read(n.Pointer(i - 1))
+	if err != nil {
+		return nil, err
+	}
+	return t.replaceChildren(n, i-1, 2, balance(sibling, child))
 }
 
-func (t *Tree) Delete(k []byte) error {
-	if t.readOnly {
-		return errors.New("tree: cannot write onto read only tree")
+// Returns the nodes replacing n after setting k to v in the subtree of n.
+func (t *Tree) set(n node, k, v []byte) ([]node, error) {
+	i, exists, err := t.search(&n, k)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Kind() {
+	case PointerPage:
+		i = childIndex(i, exists)
+		ptr := n.Pointer(i)
+		child, err := t.read(ptr)
+		if err != nil {
+			return nil, err
+		}
+
+		children, err := t.set(child, k, v)
+		if err != nil {
+			return nil, err
+		}
+		return t.replaceChildren(n, i, 1, children)
+
+	case LeafPage:
+		cell, err := t.leafCell(&n, i, exists, k, v)
+		if err != nil {
+			return nil, err
+		}
+		return insert([]node{n}, i, cell), nil
+
+	default:
+		return nil, ErrInvalidPageType
+	}
+}
+
+// Returns the nodes replacing the pointer node n after replacing its children i to i+m with
+// children. The children are written to newly allocated pages and the pages of the replaced
+// children are freed. Empty children are dropped.
+func (t *Tree) replaceChildren(n node, i, m uint16, children []node) ([]node, error) {
+	for range m {
+		if err := t.pager.Free(n.Pointer(i)); err != nil {
+			return nil, fmt.Errorf("tree: failed to free page: %w", err)
+		}
+		n = n.Delete(i)
+	}
+
+	nodes := []node{n}
+	for _, child := range children {
+		if child.N() == 0 {
+			continue
+		}
+		ptr, err := t.pager.Alloc(child)
+		if err != nil {
+			return nil, fmt.Errorf("tree: failed to allocate page: %w", err)
+		}
+		nodes = insert(nodes, i, t.pointerCell(&child, ptr))
+		i++
 	}
+	return nodes, nil
+}
+
+// Writes nodes as the new root replacing the root page at old. If nodes contains more than one
+// node, new root levels are added until a single root remains.
+func (t *Tree) setRoot(old int64, nodes []node) error {
+	for len(nodes) > 1 {
+		parent := []node{newNode(t.pageType(PointerPage))}
+		for i, n := range nodes {
+			ptr, err := t.pager.Alloc(n)
+			if err != nil {
+				return fmt.Errorf("tree: failed to allocate page: %w", err)
+			}
+			parent = insert(parent, uint16(i), t.pointerCell(&n, ptr)) // #nosec G115
+		}
+		nodes = parent
+	}
+
+	ptr, err := t.pager.Alloc(nodes[0])
+	if err != nil {
+		return fmt.Errorf("tree: failed to allocate page: %w", err)
+	}
+	if old != 0 {
+		if err := t.pager.Free(old); err != nil {
+			return fmt.Errorf("tree: failed to free page: %w", err)
+		}
+	}
+	t.root = ptr
 	return nil
 }
+
+// Returns the cell storing the k-v pair at position i of the leaf n. If k already exists its stored
+// key is reused and the overflow chain of its old value is freed.
+func (t *Tree) leafCell(n *node, i uint16, exists bool, k, v []byte) ([]byte, error) {
+	var (
+		sk        []byte
+		kOverflow bool
+		err       error
+	)
+	if exists {
+		var vOverflow bool
+		sk = n.Key(i)
+		if kOverflow, vOverflow = n.Overflows(i); vOverflow {
+			if err := t.freeOverflow(n.Val(i)); err != nil {
+				return nil, err
+			}
+		}
+	} else if sk, kOverflow, err = t.storeKey(k); err != nil {
+		return nil, err
+	}
+
+	sv, vOverflow, err := t.storeVal(sk, v)
+	if err != nil {
+		return nil, err
+	}
+	return makeFlaggedCell(sk, kOverflow, sv, vOverflow), nil
+}
+
+// Returns the type new nodes of the given kind are created with.
+func (t *Tree) pageType(kind PageType) PageType {
+	switch prefixed := t.prefixCompression && t.bytewise(); {
+	case prefixed && kind == PointerPage:
+		return PrefixPointerPage
+	case prefixed && kind == LeafPage:
+		return PrefixLeafPage
+	default:
+		return kind
+	}
+}
+
+func (t *Tree) read(ptr int64) (node, error) {
+	page, err := t.pager.ReadPage(ptr)
+	if err != nil {
+		return node{}, fmt.Errorf("tree: failed to read page: %w", err)
+	}
+	return node(page), nil
+}
+
+// Returns the index of the child pointer to follow inside a pointer node given the result of
+// searching for a key. Since a pointer cell holds the first key of its child, a key that does not
+// exist belongs to the child left of its insert position.
+func childIndex(i uint16, exists bool) uint16 {
+	if !exists && i > 0 {
+		return i - 1
+	}
+	return i
+}
+
+// Sets cell at position i of the sibling nodes ns, where i counts the cells of all nodes in order.
+// If the node the cell belongs to can't hold it even after vacuuming, it is split in two and the
+// cell is set onto the half it belongs to, which is split again if it still can't hold the cell.
+func insert(ns []node, i uint16, cell []byte) []node {
+	j, pos := 0, i
+	for ; j < len(ns)-1 && pos >= ns[j].N(); j++ {
+		pos -= ns[j].N()
+	}
+	n := ns[j]
+
+	if !n.CanSetCell(cell) {
+		n = n.Vacuum()
+	}
+	if n.CanSetCell(cell) {
+		ns[j] = n.SetCell(pos, cell)
+		return ns
+	}
+
+	left, right := n.Split()
+	return insert(slices.Replace(ns, j, j+1, left, right), i, cell)
+}
+
+// Returns the siblings left and right merged into a single node if they fit into one, otherwise
+// their cells redistributed evenly onto two nodes.
+func balance(left, right node) []node {
+	if left.CanMerge(&right) {
+		return []node{left.Merge(&right)}
+	}
+	l, r := left.Redistribute(&right)
+	return []node{l, r}
+}
@@ -1,12 +1,72 @@
 package tree_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/gkits/pavosql/internal/tree"
 )
 
+// An in memory pager that fails on reading or freeing pages that are not allocated.
+type memPager struct {
+	pages map[int64][tree.PageSize]byte
+	next  int64
+}
+
+func newMemPager() *memPager {
+	return &memPager{pages: make(map[int64][tree.PageSize]byte), next: tree.PageSize}
+}
+
+func (p *memPager) ReadPage(off int64) ([tree.PageSize]byte, error) {
+	page, ok := p.pages[off]
+	if !ok {
+		return page, fmt.Errorf("page %d is not allocated", off)
+	}
+	return page, nil
+}
+
+func (p *memPager) Alloc(page [tree.PageSize]byte) (int64, error) {
+	off := p.next
+	p.next += tree.PageSize
+	p.pages[off] = page
+	return off, nil
+}
+
+func (p *memPager) Free(off int64) error {
+	if _, ok := p.pages[off]; !ok {
+		return fmt.Errorf("page %d is not allocated", off)
+	}
+	delete(p.pages, off)
+	return nil
+}
+
+func (p *memPager) Commit() error { return nil }
+
+func (p *memPager) Abort() error { return nil }
+
+func testKey(i int) []byte { return fmt.Appendf(nil, "key%06d", i) }
+
+func testVal(i int) []byte { return bytes.Repeat(fmt.Appendf(nil, "%d", i), 1+i%50) }
+
+// Returns a tree filled with n keys inserted in a scattered order.
+func testTree(t *testing.T, n int) (*tree.Tree, *memPager) {
+	t.Helper()
+	p := newMemPager()
+	tr := tree.New(p, 0)
+	for i := range n {
+		j := (i * 7919) % n
+		if err := tr.Set(testKey(j), testVal(j)); err != nil {
+			t.Fatalf("Set(%q) failed: %v", testKey(j), err)
+		}
+	}
+	return tr, p
+}
+
 func TestTree_Get(t *testing.T) {
+	tr, _ := testTree(t, 5000)
+
 	tests := []struct {
 		name string // description of this test case
 		// Named input parameters for target function.
@@ -14,11 +74,15 @@ func TestTree_Get(t *testing.T) {
 		want    []byte
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"first key", testKey(0), testVal(0), false},
+		{"middle key", testKey(2500), testVal(2500), false},
+		{"last key", testKey(4999), testVal(4999), false},
+		{"key before first", []byte("a"), nil, true},
+		{"key between keys", append(testKey(42), 0), nil, true},
+		{"key after last", []byte("z"), nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tr := tree.New()
 			got, gotErr := tr.Get(tt.k)
 			if gotErr != nil {
 				if !tt.wantErr {
@@ -29,12 +93,15 @@ func TestTree_Get(t *testing.T) {
 			if tt.wantErr {
 				t.Fatal("Get() succeeded unexpectedly")
 			}
-			// TODO: update the condition below to compare got with tt.want.
-			if true {
+			if !bytes.Equal(got, tt.want) {
 				t.Errorf("Get() = %v, want %v", got, tt.want)
 			}
 		})
 	}
+
+	if _, err := tree.New(newMemPager(), 0).Get(testKey(0)); !errors.Is(err, tree.ErrKeyNotFound) {
+		t.Errorf("Get() on empty tree = %v, want %v", err, tree.ErrKeyNotFound)
+	}
 }
 
 func TestTree_Set(t *testing.T) {
@@ -45,11 +112,16 @@ func TestTree_Set(t *testing.T) {
 		v       []byte
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"new key", []byte("new"), []byte("value"), false},
+		{"existing key", testKey(10), []byte("overwritten"), false},
+		{"new minimum key", []byte("a"), []byte("value"), false},
+		{"empty value", testKey(11), nil, false},
+		{"overflowing key", bytes.Repeat([]byte("k"), 3*tree.PageSize), []byte("value"), false},
+		{"overflowing value", []byte("large"), bytes.Repeat([]byte("v"), 3*tree.PageSize), false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tr := tree.New()
+			tr, _ := testTree(t, 1000)
 			gotErr := tr.Set(tt.k, tt.v)
 			if gotErr != nil {
 				if !tt.wantErr {
@@ -60,10 +132,38 @@ func TestTree_Set(t *testing.T) {
 			if tt.wantErr {
 				t.Fatal("Set() succeeded unexpectedly")
 			}
+			if got, err := tr.Get(tt.k); err != nil || !bytes.Equal(got, tt.v) {
+				t.Errorf("Get() after Set() = %v, %v, want %v", got, err, tt.v)
+			}
 		})
 	}
 }
 
+func TestTree_Set_copyOnWrite(t *testing.T) {
+	tr, p := testTree(t, 3000)
+	for i := range 3000 {
+		got, err := tr.Get(testKey(i))
+		if err != nil || !bytes.Equal(got, testVal(i)) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", testKey(i), got, err, testVal(i))
+		}
+	}
+
+	before := len(p.pages)
+	root := tr.Root()
+	if err := tr.Set(testKey(1500), []byte("overwritten")); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if tr.Root() == root {
+		t.Fatal("want new root after Set()")
+	}
+	if _, ok := p.pages[root]; ok {
+		t.Fatal("want old root to be freed after Set()")
+	}
+	if len(p.pages) != before {
+		t.Fatalf("want %d allocated pages after overwrite, got %d", before, len(p.pages))
+	}
+}
+
 func TestTree_Delete(t *testing.T) {
 	tests := []struct {
 		name string // description of this test case
@@ -71,11 +171,14 @@ func TestTree_Delete(t *testing.T) {
 		k       []byte
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"first key", testKey(0), false},
+		{"middle key", testKey(500), false},
+		{"last key", testKey(999), false},
+		{"missing key", []byte("missing"), true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tr := tree.New()
+			tr, _ := testTree(t, 1000)
 			gotErr := tr.Delete(tt.k)
 			if gotErr != nil {
 				if !tt.wantErr {
@@ -86,6 +189,110 @@ func TestTree_Delete(t *testing.T) {
 			if tt.wantErr {
 				t.Fatal("Delete() succeeded unexpectedly")
 			}
+			if _, err := tr.Get(tt.k); !errors.Is(err, tree.ErrKeyNotFound) {
+				t.Errorf("Get() after Delete() = %v, want %v", err, tree.ErrKeyNotFound)
+			}
 		})
 	}
 }
+
+func TestTree_Delete_rebalance(t *testing.T) {
+	const n = 5000
+	tr, p := testTree(t, n)
+	full := len(p.pages)
+
+	for i := range n {
+		if i%10 == 0 {
+			continue
+		}
+		if err := tr.Delete(testKey(i)); err != nil {
+			t.Fatalf("Delete(%q) failed: %v", testKey(i), err)
+		}
+	}
+	if len(p.pages) > full/5 {
+		t.Errorf("want at most %d pages after deleting 90%% of keys, got %d", full/5, len(p.pages))
+	}
+
+	for i := range n {
+		got, err := tr.Get(testKey(i))
+		if i%10 != 0 {
+			if !errors.Is(err, tree.ErrKeyNotFound) {
+				t.Fatalf("Get(%q) = %v, want %v", testKey(i), err, tree.ErrKeyNotFound)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, testVal(i)) {
+			t.Fatalf("Get(%q) = %q, %v, want %q", testKey(i), got, err, testVal(i))
+		}
+	}
+
+	for i := 0; i < n; i += 10 {
+		if err := tr.Delete(testKey(i)); err != nil {
+			t.Fatalf("Delete(%q) failed: %v", testKey(i), err)
+		}
+	}
+	if tr.Root() != 0 || len(p.pages) != 0 {
+		t.Errorf("want empty tree without pages, got root %d and %d pages", tr.Root(), len(p.pages))
+	}
+}
+
+func TestTree_PrefixCompression(t *testing.T) {
+	const n = 20000
+	key := func(i int) []byte { return fmt.Appendf(nil, "table/0001/index/0002/column/%08d", i) }
+
+	plain, compressed := newMemPager(), newMemPager()
+	trees := []*tree.Tree{tree.New(plain, 0), tree.New(compressed, 0, tree.PrefixCompression())}
+	for _, tr := range trees {
+		for i := range n {
+			j := (i * 7919) % n
+			if err := tr.Set(key(j), []byte("v")); err != nil {
+				t.Fatalf("Set(%q) failed: %v", key(j), err)
+			}
+		}
+	}
+	if len(compressed.pages) >= len(plain.pages)*3/4 {
+		t.Errorf("want prefix compressed tree to be smaller than %d pages, got %d",
+			len(plain.pages)*3/4, len(compressed.pages))
+	}
+
+	tr := trees[1]
+	if r := tr.Check(); !r.OK() {
+		t.Fatalf("want no violations, got %s", r)
+	}
+	for i := range n {
+		if _, err := tr.Get(key(i)); err != nil {
+			t.Fatalf("Get(%q) failed: %v", key(i), err)
+		}
+	}
+	if err := tr.Set([]byte("other"), []byte("v")); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	for i := range n {
+		if err := tr.Delete(key(i)); err != nil {
+			t.Fatalf("Delete(%q) failed: %v", key(i), err)
+		}
+	}
+	if got, err := tr.Get([]byte("other")); err != nil || string(got) != "v" {
+		t.Fatalf("Get() = %q, %v, want %q", got, err, "v")
+	}
+}
+
+func TestTree_Check(t *testing.T) {
+	tr, p := testTree(t, 20000)
+	for i := 0; i < 20000; i += 3 {
+		if err := tr.Delete(testKey(i)); err != nil {
+			t.Fatalf("Delete(%q) failed: %v", testKey(i), err)
+		}
+	}
+	if err := tr.Set(largeKey(1, 3*tree.PageSize), largeKey(2, 3*tree.PageSize)); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	r := tr.Check()
+	if !r.OK() {
+		t.Fatalf("want no violations, got %s", r)
+	}
+	if r.Pages != len(p.pages) || r.Keys != 20000-6667+1 {
+		t.Fatalf("want %d pages and %d keys, got %s", len(p.pages), 20000-6667+1, r)
+	}
+}
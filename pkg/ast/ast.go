@@ -13,3 +13,7 @@ type CreateStmt struct{}
 type UpdateStmt struct{}
 
 type InsertStmt struct{}
+
+// Relocates the pages at the end of the database into free pages and returns the space freed that
+// way to the file system.
+type VacuumStmt struct{}
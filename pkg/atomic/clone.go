@@ -0,0 +1,151 @@
+package atomic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A cloneFile writes into a clone of its target, which replaces the target on commit.
+type cloneFile struct {
+	name string
+	perm fs.FileMode
+	tmp  *os.File
+}
+
+// Returns a cloneFile writing into a new clone of the target called name and reports weither the
+// clone shares its data with the target through a reflink.
+func openClone(name string, perm fs.FileMode) (*cloneFile, bool, error) {
+	tmp, reflinked, err := newClone(name, perm)
+	if err != nil {
+		return nil, false, err
+	}
+	return &cloneFile{name: name, perm: perm, tmp: tmp}, reflinked, nil
+}
+
+// Returns a new clone of the file called src with the permissions perm in the directory of src and
+// reports weither it is reflinked.
+func newClone(src string, perm fs.FileMode) (*os.File, bool, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(src), tempPattern(src))
+	if err != nil {
+		return nil, false, fmt.Errorf("atomic: failed to create temporary file: %w", err)
+	}
+	reflinked, err := cloneInto(tmp, src)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, false, err
+	}
+	return tmp, reflinked, nil
+}
+
+// Returns the pattern of the names of the temporary files of the target called name.
+func tempPattern(name string) string {
+	base := filepath.Base(name)
+	// Clones of a clone are named after the target as well.
+	if i := strings.Index(base, ".tmp-"); i >= 0 {
+		base = base[:i]
+	}
+	return base + ".tmp-*"
+}
+
+// Removes the clones of the target called name left behind by a File that was never closed.
+func removeClones(name string) error {
+	dir := filepath.Dir(name)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("atomic: failed to read directory: %w", err)
+	}
+	prefix := strings.TrimSuffix(tempPattern(name), "*")
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("atomic: failed to remove temporary file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Replaces the clone of c by next and removes the previous one.
+func (c *cloneFile) replace(next *os.File) {
+	c.tmp.Close()
+	os.Remove(c.tmp.Name())
+	c.tmp = next
+}
+
+// Clones the file called name into dst, which is left empty if there is no such file, and reports
+// weither the clone is reflinked.
+func cloneInto(dst *os.File, name string) (bool, error) {
+	src, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("atomic: failed to open target file: %w", err)
+	}
+	defer src.Close()
+
+	if reflink(dst, src) == nil {
+		return true, nil
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		return false, fmt.Errorf("atomic: failed to copy target file: %w", err)
+	}
+	return false, nil
+}
+
+func (c *cloneFile) ReadAt(b []byte, off int64) (int, error) {
+	return c.tmp.ReadAt(b, off)
+}
+
+func (c *cloneFile) WriteAt(b []byte, off int64) (int, error) {
+	return c.tmp.WriteAt(b, off)
+}
+
+func (c *cloneFile) truncate(size int64) error {
+	return c.tmp.Truncate(size)
+}
+
+// Renames the synced clone over the target. The next clone is cloned from the committed one before,
+// so that c keeps its clone if Commit fails.
+func (c *cloneFile) commit() error {
+	if err := c.tmp.Sync(); err != nil {
+		return fmt.Errorf("atomic: failed to sync temporary file: %w", err)
+	}
+	next, _, err := newClone(c.tmp.Name(), c.perm)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(c.tmp.Name(), c.name); err != nil {
+		next.Close()
+		os.Remove(next.Name())
+		return fmt.Errorf("atomic: failed to replace target file: %w", err)
+	}
+
+	// The committed clone is the target now, which must not be removed.
+	c.tmp.Close()
+	c.tmp = next
+	return syncDir(c.name)
+}
+
+func (c *cloneFile) abort() error {
+	next, _, err := newClone(c.name, c.perm)
+	if err != nil {
+		return err
+	}
+	c.replace(next)
+	return nil
+}
+
+func (c *cloneFile) close() error {
+	defer os.Remove(c.tmp.Name())
+	return c.tmp.Close()
+}
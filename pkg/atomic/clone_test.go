@@ -0,0 +1,63 @@
+package atomic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Clones are only chosen by OpenFile with reflinks, but fall back to copies on their own.
+func TestCloneFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "db")
+	if err := os.WriteFile(name, []byte("committed"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	c, _, err := openClone(name, 0o600)
+	if err != nil {
+		t.Fatalf("openClone() failed: %v", err)
+	}
+
+	testTarget := func(want string) {
+		t.Helper()
+		got, err := os.ReadFile(name)
+		if err != nil || string(got) != want {
+			t.Fatalf("want target %q, got %q, %v", want, got, err)
+		}
+	}
+	if _, err := c.WriteAt([]byte("discarded"), 0); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	testTarget("committed")
+	if err := c.abort(); err != nil {
+		t.Fatalf("abort() failed: %v", err)
+	}
+
+	if _, err := c.WriteAt([]byte("pending"), 0); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	got := make([]byte, 9)
+	if _, err := c.ReadAt(got, 0); err != nil || !bytes.Equal(got, []byte("pendinged")) {
+		t.Fatalf("want pending write read, got %q, %v", got, err)
+	}
+	if err := c.commit(); err != nil {
+		t.Fatalf("commit() failed: %v", err)
+	}
+	testTarget("pendinged")
+	if err := c.truncate(7); err != nil {
+		t.Fatalf("truncate() failed: %v", err)
+	}
+	if err := c.commit(); err != nil {
+		t.Fatalf("commit() failed: %v", err)
+	}
+	testTarget("pending")
+
+	if err := c.close(); err != nil {
+		t.Fatalf("close() failed: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("want only target left, got %d files, %v", len(entries), err)
+	}
+}
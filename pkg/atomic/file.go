@@ -1,11 +1,22 @@
 package atomic
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"sync"
 )
 
+var (
+	ErrClosed = errors.New("atomic: file has already been closed")
+	ErrBroken = errors.New("atomic: committed transaction could not be applied, reopen to recover")
+	ErrLocked = errors.New("atomic: target file is opened by another File")
+)
+
+// A ReadWriterAt is written atomically: Writes only become durable once they are committed, while
+// Abort discards all writes since the last commit.
 type ReadWriterAt interface {
 	io.ReaderAt
 	io.WriterAt
@@ -13,74 +24,229 @@ type ReadWriterAt interface {
 	Abort() error
 }
 
+/*
+A File is a ReadWriterAt updating the file it was opened for, its target, atomically. Reads see all
+writes, including the ones not committed yet, while the target only changes by Commit, which either
+applies all writes since the last commit or none of them, even if the process dies while committing.
+
+Files are updated by one of two strategies, which is chosen when the file is opened:
+
+Clones: Where the file system supports reflinks, writes go into a reflinked clone of the target,
+which is a temporary file in the directory of the target sharing its data. Commit syncs the clone,
+renames it over the target and syncs the directory, which makes the rename durable. Following
+writes go into a new clone of the committed one. Clones are only used with reflinks, since copying
+the whole target for every commit is too expensive.
+
+Shadow pages: All other targets are updated in place. The blocks written since the last commit are
+stored in a shadow file next to the target, from which they are read as well. Commit appends an
+index of the blocks to the shadow file and syncs it, which makes the transaction durable, copies
+the blocks into the target, syncs it and empties the shadow file. OpenFile completes a transaction
+whose index is durable and discards all others (see shadow.go). If copying the blocks fails, all
+further writes, commits and aborts return ErrBroken, while the transaction is completed once the
+target is opened again.
+
+A target must not be opened by more than one File at a time, since OpenFile removes the temporary
+files left behind by previous ones. Every File therefore holds an exclusive lock on the lock file
+next to its target, which is called name+".lock" and left on disk, until it is closed.
+*/
 type File struct {
-	original string
-	info     os.FileInfo
-	tmp      *os.File
+	mu     sync.RWMutex
+	name   string
+	perm   fs.FileMode
+	f      strategy
+	lock   *os.File
+	closed bool
+
+	shadow bool
 }
 
-func OpenFile(name string) (*File, error) {
-	tmp, err := os.CreateTemp("/tmp", "")
-	if err != nil {
-		return nil, fmt.Errorf("atomic: failed to create temporary file: %w", err)
+// A strategy updates the target of a File.
+type strategy interface {
+	io.ReaderAt
+	io.WriterAt
+	truncate(size int64) error
+	commit() error
+	abort() error
+	close() error
+}
+
+// An Option configures a File.
+type Option func(*File)
+
+// Updates the target through shadow pages even if it could be cloned by reflinks.
+func ShadowPages() Option {
+	return func(f *File) { f.shadow = true }
+}
+
+// Opens the file called name as target of a new File. A missing target is created with the
+// permissions perm, while existing targets keep theirs. A transaction interrupted while it was
+// committed through shadow pages is completed if it is durable, while clones left behind by a
+// previous File are removed.
+//
+// Returns ErrLocked if the target is opened by another File, including ones of other processes.
+func OpenFile(name string, perm fs.FileMode, opts ...Option) (_ *File, err error) {
+	f := &File{name: name, perm: perm}
+	for _, opt := range opts {
+		opt(f)
 	}
 
-	og, err := os.Open(name)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("atomic: failed to open target file: %w", err)
+	lock, err := openLock(name, perm)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			lock.Close()
+		}
+	}()
+	f.lock = lock
+
+	info, err := os.Stat(name)
+	switch {
+	case err == nil:
+		f.perm = info.Mode().Perm()
+	case errors.Is(err, fs.ErrNotExist):
+		// Missing targets can't be reflinked and are created by openShadow.
+		f.shadow = true
+	default:
+		return nil, fmt.Errorf("atomic: failed to stat target file: %w", err)
 	}
-	defer og.Close()
 
-	if _, err := io.Copy(tmp, og); err != nil {
-		return nil, fmt.Errorf("atomic: failed to copy data into temporary file: %w", err)
+	// Files left behind by a previous File have to be recovered before the target is read.
+	if err := removeClones(name); err != nil {
+		return nil, err
+	}
+	if _, err := recoverShadow(name); err != nil {
+		return nil, err
 	}
 
-	info, err := og.Stat()
-	if err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("atomic: failed to obtain file info of target file: %w", err)
+	if !f.shadow {
+		c, reflinked, err := openClone(name, f.perm)
+		if err != nil {
+			return nil, err
+		}
+		if reflinked {
+			f.f = c
+			return f, nil
+		}
+		if err := c.close(); err != nil {
+			return nil, err
+		}
 	}
 
-	// tmp.Chmod
+	if f.f, err = openShadow(name, f.perm); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
 
-	return &File{
-		original: name,
-		info:     info,
-		tmp:      tmp,
-	}, nil
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return 0, ErrClosed
+	}
+	return f.f.ReadAt(b, off)
 }
 
-func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	return f.tmp.ReadAt(b, off)
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, ErrClosed
+	}
+	return f.f.WriteAt(b, off)
 }
 
-func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	return f.tmp.WriteAt(b, off)
+// Changes the size of f to size, which only changes the size of the target once it is committed.
+func (f *File) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	return f.f.truncate(size)
 }
 
+// Atomically applies all writes since the last commit to the target and makes them durable. f can
+// still be used after Commit returned.
 func (f *File) Commit() error {
-	defer os.Remove(f.tmp.Name())
-	defer f.tmp.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	return f.f.commit()
+}
+
+// Discards all writes since the last commit. f can still be used after Abort returned.
+func (f *File) Abort() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
+	}
+	return f.f.abort()
+}
 
-	if err := f.tmp.Sync(); err != nil {
-		return fmt.Errorf("atomic: failed to flush temporary file: %w", err)
+// Returns the descriptor of the target of f, which holds the committed contents of f and can be
+// memory-mapped for reading them. The target only changes by Commit, which may truncate it. Only
+// Files updated through shadow pages have one, since clones replace the target by every commit.
+//
+// Returns an error wrapping errors.ErrUnsupported if f uses clones.
+func (f *File) TargetFd() (uintptr, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return 0, ErrClosed
 	}
-	if err := f.tmp.Close(); err != nil {
-		return fmt.Errorf("atomic: failed to close temporary file: %w", err)
+	s, ok := f.f.(*shadowFile)
+	if !ok {
+		return 0, fmt.Errorf("atomic: cloned targets can't be mapped: %w", errors.ErrUnsupported)
 	}
+	return s.target.Fd(), nil
+}
 
-	if f.info != nil {
-		if err := f.tmp.Chmod(f.info.Mode()); err != nil {
-			return fmt.Errorf("atomic: failed to set filemode of temporary file: %w", err)
-		}
+// Discards all writes since the last commit and closes f. f can't be used anymore after Close
+// returned.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrClosed
 	}
+	f.closed = true
+	// The lock is only released once the files of the target are closed.
+	return errors.Join(f.f.close(), f.lock.Close())
+}
 
-	if err := replaceFile("", f.original); err != nil {
-		return fmt.Errorf("atomic: failed to replace target file: %w", err)
+// Opens and locks the lock file of the target called name, which is created with the permissions
+// perm if it doesn't exist.
+func openLock(name string, perm fs.FileMode) (*os.File, error) {
+	lock, err := os.OpenFile(name+".lock", os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, fmt.Errorf("atomic: failed to open lock file: %w", err)
 	}
-	return nil
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
 }
 
-func (f *File) Abort() error {
-	defer os.Remove(f.tmp.Name())
-	return f.tmp.Close()
+// Syncs the directory of the file called name, which makes the creation, removal and renaming of
+// files in it durable.
+func syncDir(name string) error {
+	dir, err := openDir(name)
+	if err != nil {
+		return fmt.Errorf("atomic: failed to open directory: %w", err)
+	}
+	if dir == nil {
+		return nil
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("atomic: failed to sync directory: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,252 @@
+package atomic_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+// The strategies a File is tested with.
+var testStrategies = []struct {
+	name string
+	opts []atomic.Option
+}{
+	{"default", nil},
+	{"shadow pages", []atomic.Option{atomic.ShadowPages()}},
+}
+
+func testOpen(t *testing.T, name string, opts []atomic.Option) *atomic.File {
+	t.Helper()
+	f, err := atomic.OpenFile(name, 0o600, opts...)
+	if err != nil {
+		t.Fatalf("OpenFile() failed: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func testWrite(t *testing.T, f *atomic.File, b []byte, off int64) {
+	t.Helper()
+	if _, err := f.WriteAt(b, off); err != nil {
+		t.Fatalf("WriteAt(%d) failed: %v", off, err)
+	}
+}
+
+// Asserts that f reads as want.
+func testContent(t *testing.T, f *atomic.File, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want)+1)
+	n, err := f.ReadAt(got, 0)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("want io.EOF behind %d bytes, got %d, %v", len(want), n, err)
+	}
+	if !bytes.Equal(got[:n], want) {
+		t.Fatalf("want content of %d bytes, got %d different ones", len(want), n)
+	}
+}
+
+// Asserts that the target called name holds want.
+func testTarget(t *testing.T, name string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("want target of %d bytes, got %d different ones", len(want), len(got))
+	}
+}
+
+func TestFile(t *testing.T) {
+	for _, s := range testStrategies {
+		t.Run(s.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "db")
+			f := testOpen(t, name, s.opts)
+			testContent(t, f, nil)
+
+			// Writes spanning several blocks and leaving holes.
+			want := make([]byte, 3*8192+100)
+			copy(want[100:], bytes.Repeat([]byte("a"), 10000))
+			copy(want[3*8192:], bytes.Repeat([]byte("b"), 100))
+			testWrite(t, f, want[100:10100], 100)
+			testWrite(t, f, want[3*8192:], 3*8192)
+			testContent(t, f, want)
+			if err := f.Commit(); err != nil {
+				t.Fatalf("Commit() failed: %v", err)
+			}
+			testTarget(t, name, want)
+
+			// Aborted writes and truncations are discarded.
+			testWrite(t, f, []byte("discarded"), 50)
+			if err := f.Truncate(10); err != nil {
+				t.Fatalf("Truncate() failed: %v", err)
+			}
+			testWrite(t, f, []byte("discarded"), 20000)
+			if err := f.Abort(); err != nil {
+				t.Fatalf("Abort() failed: %v", err)
+			}
+			testContent(t, f, want)
+			testTarget(t, name, want)
+
+			// Shrinking and growing again reads as zeros behind the truncation.
+			if err := f.Truncate(150); err != nil {
+				t.Fatalf("Truncate() failed: %v", err)
+			}
+			if err := f.Truncate(9000); err != nil {
+				t.Fatalf("Truncate() failed: %v", err)
+			}
+			testWrite(t, f, []byte("c"), 8999)
+			want = append(want[:150:150], make([]byte, 9000-150)...)
+			want[8999] = 'c'
+			testContent(t, f, want)
+			if err := f.Commit(); err != nil {
+				t.Fatalf("Commit() failed: %v", err)
+			}
+			testTarget(t, name, want)
+
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+			if _, err := f.ReadAt(make([]byte, 1), 0); !errors.Is(err, atomic.ErrClosed) {
+				t.Fatalf("want ErrClosed, got %v", err)
+			}
+			testContent(t, testOpen(t, name, s.opts), want)
+		})
+	}
+}
+
+// Temporary files are created next to the target, from which they can be renamed, and are removed
+// by Close, which only leaves the lock file behind.
+func TestFile_tempFiles(t *testing.T) {
+	for _, s := range testStrategies {
+		t.Run(s.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := filepath.Join(dir, "db")
+			if err := os.WriteFile(name, []byte("committed"), 0o600); err != nil {
+				t.Fatalf("WriteFile() failed: %v", err)
+			}
+
+			f := testOpen(t, name, s.opts)
+			testWrite(t, f, []byte("pending"), 0)
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("ReadDir() failed: %v", err)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("want target, lock and temporary file in %s, got %d files", dir, len(entries))
+			}
+			if err := f.Commit(); err != nil {
+				t.Fatalf("Commit() failed: %v", err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+
+			entries, err = os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("ReadDir() failed: %v", err)
+			}
+			if len(entries) != 2 || entries[0].Name() != "db" || entries[1].Name() != "db.lock" {
+				t.Fatalf("want only target and lock file in %s, got %d files", dir, len(entries))
+			}
+			testTarget(t, name, []byte("pendinged"))
+		})
+	}
+}
+
+// A target is only opened by one File at a time, which leaves the temporary files of the first one
+// untouched.
+func TestOpenFile_locked(t *testing.T) {
+	for _, s := range testStrategies {
+		t.Run(s.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "db")
+			if err := os.WriteFile(name, []byte("committed"), 0o600); err != nil {
+				t.Fatalf("WriteFile() failed: %v", err)
+			}
+
+			f := testOpen(t, name, s.opts)
+			testWrite(t, f, []byte("pending"), 0)
+			if _, err := atomic.OpenFile(name, 0o600, s.opts...); !errors.Is(err, atomic.ErrLocked) {
+				t.Fatalf("want ErrLocked opening target twice, got %v", err)
+			}
+			if err := f.Commit(); err != nil {
+				t.Fatalf("Commit() failed: %v", err)
+			}
+			testTarget(t, name, []byte("pendinged"))
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+
+			testContent(t, testOpen(t, name, s.opts), []byte("pendinged"))
+		})
+	}
+}
+
+// The target of a File updated through shadow pages only holds committed writes.
+func TestFile_TargetFd(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "db")
+	f := testOpen(t, name, []atomic.Option{atomic.ShadowPages()})
+	testWrite(t, f, []byte("committed"), 0)
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	testWrite(t, f, []byte("pending"), 0)
+
+	if _, err := f.TargetFd(); err != nil {
+		t.Fatalf("TargetFd() failed: %v", err)
+	}
+	testTarget(t, name, []byte("committed"))
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := f.TargetFd(); !errors.Is(err, atomic.ErrClosed) {
+		t.Fatalf("want ErrClosed, got %v", err)
+	}
+}
+
+// Existing targets keep their permissions.
+func TestOpenFile_perm(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "db")
+	if err := os.WriteFile(name, nil, 0o640); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	f := testOpen(t, name, nil)
+	testWrite(t, f, []byte("data"), 0)
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("want permissions %v, got %v", os.FileMode(0o640), info.Mode().Perm())
+	}
+}
+
+// Clones left behind by a File that was never closed are removed by OpenFile.
+func TestOpenFile_staleClones(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "db")
+	for _, file := range []string{"db", "db.tmp-123", "other.tmp-123"} {
+		if err := os.WriteFile(filepath.Join(dir, file), nil, 0o600); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	f := testOpen(t, name, nil)
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "db.tmp-123")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want stale clone removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.tmp-123")); err != nil {
+		t.Fatalf("want clones of other targets kept, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ package atomic
 
 import (
 	"os"
+	"path/filepath"
 )
 
 // replaceFile atomically replaces the destination file or directory with the
@@ -12,3 +13,8 @@ import (
 func replaceFile(source, destination string) error {
 	return os.Rename(source, destination)
 }
+
+// openDir opens the directory of the file called name, so that it can be synced.
+func openDir(name string) (*os.File, error) {
+	return os.Open(filepath.Dir(name))
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package atomic
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Locks f exclusively without waiting for other locks, which are released once their file is
+// closed. Returns ErrLocked if f is locked already.
+func lockFile(f *os.File) error {
+	// #nosec G115 // f is an open file, whose descriptor fits into an int
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return ErrLocked
+		}
+		return fmt.Errorf("atomic: failed to lock file: %w", err)
+	}
+	return nil
+}
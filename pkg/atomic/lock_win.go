@@ -0,0 +1,33 @@
+//go:build windows
+
+package atomic
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	lockfile_fail_immediately = 0x1
+	lockfile_exclusive_lock   = 0x2
+
+	error_lock_violation syscall.Errno = 33
+)
+
+//sys lockFileEx(hFile syscall.Handle, dwFlags uint32, dwReserved uint32, nNumberOfBytesToLockLow uint32, nNumberOfBytesToLockHigh uint32, lpOverlapped *syscall.Overlapped) (err error) = LockFileEx
+
+// Locks f exclusively without waiting for other locks, which are released once their file is
+// closed. Returns ErrLocked if f is locked already.
+func lockFile(f *os.File) error {
+	var overlapped syscall.Overlapped
+	err := lockFileEx(syscall.Handle(f.Fd()), lockfile_fail_immediately|lockfile_exclusive_lock, 0,
+		1, 0, &overlapped)
+	if errors.Is(err, error_lock_violation) {
+		return ErrLocked
+	} else if err != nil {
+		return fmt.Errorf("atomic: failed to lock file: %w", err)
+	}
+	return nil
+}
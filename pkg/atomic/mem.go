@@ -0,0 +1,150 @@
+package atomic
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// The size of the blocks a MemFile holds its pending writes in.
+const memBlock = 4096
+
+// A MemFile is a ReadWriterAt held in memory, which never touches the disk. Like with a File, reads
+// see all writes, while Commit applies all writes since the last commit and Abort discards them.
+// Pending writes are held in blocks of their own, so that aborting doesn't depend on the size of
+// the committed data. Its contents are lost once it is dropped.
+type MemFile struct {
+	mu   sync.RWMutex
+	data []byte
+
+	blocks    map[int64][]byte // Maps block numbers to the blocks written since the last commit.
+	size      int64
+	truncated int64
+}
+
+// Returns a new empty MemFile.
+func NewMemFile() *MemFile {
+	return &MemFile{blocks: make(map[int64][]byte)}
+}
+
+// Returns the committed size of f.
+func (f *MemFile) Size() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return int64(len(f.data))
+}
+
+// Reads the block called no as it is seen by f into b. Bytes behind the size of f are zeroed.
+func (f *MemFile) readBlock(b []byte, no int64) {
+	if blk, ok := f.blocks[no]; ok {
+		copy(b, blk)
+		return
+	}
+	clear(b)
+	// The committed data is discarded behind the truncated size.
+	if off := no * memBlock; off < f.truncated {
+		copy(b, f.data[off:f.truncated])
+	}
+}
+
+func (f *MemFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("atomic: negative offset %d", off)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	end := min(off+int64(len(b)), f.size)
+	block := make([]byte, memBlock)
+	n := 0
+	for pos := off; pos < end; {
+		f.readBlock(block, pos/memBlock)
+		c := copy(b[n:end-off], block[pos%memBlock:])
+		n += c
+		pos += int64(c)
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *MemFile) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("atomic: negative offset %d", off)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for n < len(b) {
+		pos := off + int64(n)
+		no, i := pos/memBlock, pos%memBlock
+		blk, ok := f.blocks[no]
+		if !ok {
+			// Blocks are copied as a whole the first time, which keeps the parts not written.
+			blk = make([]byte, memBlock)
+			f.readBlock(blk, no)
+			f.blocks[no] = blk
+		}
+		n += copy(blk[i:], b[n:])
+	}
+	f.size = max(f.size, off+int64(n))
+	return n, nil
+}
+
+// Changes the size of f to size, which only changes the committed size once it is committed.
+func (f *MemFile) Truncate(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("atomic: negative size %d", size)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if size < f.size {
+		// The tail of a pending block must read as zeros if f grows again.
+		if blk, ok := f.blocks[size/memBlock]; ok {
+			clear(blk[size%memBlock:])
+		}
+		for no := range f.blocks {
+			if no*memBlock >= size {
+				delete(f.blocks, no)
+			}
+		}
+		f.truncated = min(f.truncated, size)
+	}
+	f.size = size
+	return nil
+}
+
+// Applies all writes since the last commit.
+func (f *MemFile) Commit() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// The committed data behind the truncated size is replaced by zeros.
+	data := f.data[:f.truncated]
+	if f.size > f.truncated {
+		data = append(data, make([]byte, f.size-f.truncated)...)
+	}
+	for no, blk := range f.blocks {
+		copy(data[no*memBlock:], blk)
+	}
+	f.data = data
+	f.reset()
+	return nil
+}
+
+// Discards all writes since the last commit.
+func (f *MemFile) Abort() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reset()
+	return nil
+}
+
+func (f *MemFile) reset() {
+	clear(f.blocks)
+	f.size = int64(len(f.data))
+	f.truncated = f.size
+}
@@ -0,0 +1,82 @@
+package atomic_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gkits/pavosql/pkg/atomic"
+)
+
+// Asserts that f reads as want.
+func testMemContent(t *testing.T, f *atomic.MemFile, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want)+1)
+	n, err := f.ReadAt(got, 0)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("want io.EOF behind %d bytes, got %d, %v", len(want), n, err)
+	}
+	if !bytes.Equal(got[:n], want) {
+		t.Fatalf("want content of %d bytes, got %d different ones", len(want), n)
+	}
+}
+
+func TestMemFile(t *testing.T) {
+	f := atomic.NewMemFile()
+	testMemContent(t, f, nil)
+
+	want := make([]byte, 10000)
+	copy(want[100:], bytes.Repeat([]byte("a"), 5000))
+	copy(want[9000:], bytes.Repeat([]byte("b"), 1000))
+	if _, err := f.WriteAt(want[100:5100], 100); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	if _, err := f.WriteAt(want[9000:], 9000); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	testMemContent(t, f, want)
+	if f.Size() != 0 {
+		t.Fatalf("want committed size 0 before Commit, got %d", f.Size())
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	testMemContent(t, f, want)
+
+	// Aborted writes and truncations are discarded.
+	if _, err := f.WriteAt([]byte("discarded"), 50); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	if err := f.Truncate(10); err != nil {
+		t.Fatalf("Truncate() failed: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("discarded"), 20000); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	if err := f.Abort(); err != nil {
+		t.Fatalf("Abort() failed: %v", err)
+	}
+	testMemContent(t, f, want)
+
+	// Shrinking and growing again reads as zeros behind the truncation.
+	if _, err := f.WriteAt([]byte("c"), 120); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	if err := f.Truncate(110); err != nil {
+		t.Fatalf("Truncate() failed: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("d"), 6000); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	want = append(want[:110:110], make([]byte, 6000-110)...)
+	want = append(want, 'd')
+	testMemContent(t, f, want)
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit() failed: %v", err)
+	}
+	testMemContent(t, f, want)
+	if f.Size() != int64(len(want)) {
+		t.Fatalf("want committed size %d, got %d", len(want), f.Size())
+	}
+}
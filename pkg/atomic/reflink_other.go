@@ -0,0 +1,13 @@
+//go:build !linux
+
+package atomic
+
+import (
+	"errors"
+	"os"
+)
+
+// Reflinks are only supported on Linux, where other files are copied instead.
+func reflink(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,414 @@
+package atomic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+)
+
+/*
+The shadow file of a target called name is called name+".shadow". It holds the blocks written since
+the last commit in the order they were first written, followed by the commit record once the
+transaction is committed:
+
+	+--------+-----+--------+----------+-----------+------+------+----------+-------+
+	| Block0 | ... | BlockN | BlockNos | Truncated | Size |  N   | Checksum | Magic |
+	+--------+-----+--------+----------+-----------+------+------+----------+-------+
+	| 4096B  |     | 4096B  |  8B * N  |    8B     |  8B  |  8B  |    4B    |  8B   |
+	+--------+-----+--------+----------+-----------+------+------+----------+-------+
+
+BlockNos holds the number of every block in the target, or -1 for blocks discarded by a
+truncation. Truncated is the smallest size the file had since the last commit, behind which the
+target is discarded, and Size its size. Checksum is the CRC-32C of the blocks and the record up to
+N. A shadow file without a valid commit record is discarded, while a valid record is applied to the
+target.
+
+Once the commit record is durable, the transaction must not be lost anymore. If applying it fails,
+the shadowFile is broken: It refuses all further writes, commits and aborts and leaves the shadow
+file on disk, so that the transaction is applied by the next OpenFile.
+*/
+
+// The size of the blocks stored in shadow files.
+const shadowBlock = 4096
+
+var (
+	shadowMagic = [8]byte{'p', 'a', 'v', 'o', 's', 'h', 'd', 0}
+	castagnoli  = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// A shadowFile writes into a shadow file, from which the blocks are copied into its target on
+// commit.
+type shadowFile struct {
+	name   string
+	target *os.File
+	shadow *os.File
+
+	blocks    map[int64]int64 // Maps block numbers to their slots in the shadow file.
+	order     []int64         // Holds the block numbers by slot.
+	size      int64
+	truncated int64
+	// Set once a durable transaction could not be applied to the target.
+	broken error
+}
+
+// Returns the name of the shadow file of the target called name.
+func shadowName(name string) string {
+	return name + ".shadow"
+}
+
+// Returns a shadowFile for the target called name, which is created with the permissions perm if
+// it doesn't exist. The shadow file must have been recovered before.
+func openShadow(name string, perm fs.FileMode) (*shadowFile, error) {
+	target, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, fmt.Errorf("atomic: failed to open target file: %w", err)
+	}
+	shadow, err := os.OpenFile(shadowName(name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		target.Close()
+		return nil, fmt.Errorf("atomic: failed to open shadow file: %w", err)
+	}
+	s := &shadowFile{name: name, target: target, shadow: shadow}
+	if err := s.reset(); err != nil {
+		s.close()
+		return nil, err
+	}
+	// Commit records of a shadow file whose creation isn't durable would be lost.
+	if err := syncDir(name); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Discards all blocks and takes the size from the target.
+func (s *shadowFile) reset() error {
+	info, err := s.target.Stat()
+	if err != nil {
+		return fmt.Errorf("atomic: failed to stat target file: %w", err)
+	}
+	s.blocks = make(map[int64]int64)
+	s.order = s.order[:0]
+	s.size = info.Size()
+	s.truncated = s.size
+	return nil
+}
+
+// Reads the block called no as it is seen by s into b. Bytes behind the size of s are zeroed.
+func (s *shadowFile) readBlock(b []byte, no int64) error {
+	clear(b)
+	if slot, ok := s.blocks[no]; ok {
+		_, err := s.shadow.ReadAt(b, slot*shadowBlock)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("atomic: failed to read shadow file: %w", err)
+		}
+		return nil
+	}
+
+	off := no * shadowBlock
+	if off >= s.truncated {
+		return nil
+	}
+	// The target is discarded behind the truncated size, even if it is still larger.
+	n := min(int64(len(b)), s.truncated-off)
+	_, err := s.target.ReadAt(b[:n], off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("atomic: failed to read target file: %w", err)
+	}
+	return nil
+}
+
+func (s *shadowFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("atomic: negative offset %d", off)
+	}
+	end := min(off+int64(len(b)), s.size)
+	block := make([]byte, shadowBlock)
+	n := 0
+	for pos := off; pos < end; {
+		no, i := pos/shadowBlock, pos%shadowBlock
+		if err := s.readBlock(block, no); err != nil {
+			return n, err
+		}
+		c := copy(b[n:end-off], block[i:])
+		n += c
+		pos += int64(c)
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *shadowFile) WriteAt(b []byte, off int64) (int, error) {
+	if s.broken != nil {
+		return 0, s.broken
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("atomic: negative offset %d", off)
+	}
+	block := make([]byte, shadowBlock)
+	n := 0
+	for n < len(b) {
+		pos := off + int64(n)
+		no, i := pos/shadowBlock, pos%shadowBlock
+		c := min(len(b)-n, shadowBlock-int(i))
+
+		slot, ok := s.blocks[no]
+		if ok {
+			if _, err := s.shadow.WriteAt(b[n:n+c], slot*shadowBlock+i); err != nil {
+				return n, fmt.Errorf("atomic: failed to write shadow file: %w", err)
+			}
+		} else {
+			// Blocks are written as a whole the first time, which keeps the parts not written.
+			if err := s.readBlock(block, no); err != nil {
+				return n, err
+			}
+			copy(block[i:], b[n:n+c])
+			slot = int64(len(s.order))
+			if _, err := s.shadow.WriteAt(block, slot*shadowBlock); err != nil {
+				return n, fmt.Errorf("atomic: failed to write shadow file: %w", err)
+			}
+			s.blocks[no] = slot
+			s.order = append(s.order, no)
+		}
+		n += c
+	}
+	s.size = max(s.size, off+int64(n))
+	return n, nil
+}
+
+func (s *shadowFile) truncate(size int64) error {
+	if s.broken != nil {
+		return s.broken
+	}
+	if size < 0 {
+		return fmt.Errorf("atomic: negative size %d", size)
+	}
+	if size < s.size {
+		// The tail of a shadowed block must read as zeros if the file grows again.
+		last := size / shadowBlock
+		if slot, ok := s.blocks[last]; ok && size%shadowBlock != 0 {
+			tail := make([]byte, shadowBlock-size%shadowBlock)
+			if _, err := s.shadow.WriteAt(tail, slot*shadowBlock+size%shadowBlock); err != nil {
+				return fmt.Errorf("atomic: failed to write shadow file: %w", err)
+			}
+		}
+		for no := range s.blocks {
+			if no*shadowBlock >= size {
+				delete(s.blocks, no)
+			}
+		}
+		s.truncated = min(s.truncated, size)
+	}
+	s.size = size
+	return nil
+}
+
+// Returns the commit record of the blocks in the slots up to n, which are read to compute its
+// checksum.
+func (s *shadowFile) record(n int) ([]byte, error) {
+	h := crc32.New(castagnoli)
+	if _, err := io.Copy(h, io.NewSectionReader(s.shadow, 0, int64(n)*shadowBlock)); err != nil {
+		return nil, fmt.Errorf("atomic: failed to read shadow file: %w", err)
+	}
+
+	rec := make([]byte, 8*n+24, 8*n+36)
+	for slot, no := range s.order[:n] {
+		// Blocks deleted by a truncation keep their slot, but aren't applied.
+		if cur, ok := s.blocks[no]; !ok || cur != int64(slot) {
+			no = -1
+		}
+		binary.LittleEndian.PutUint64(rec[8*slot:], uint64(no)) // #nosec G115
+	}
+	binary.LittleEndian.PutUint64(rec[8*n:], uint64(s.truncated)) // #nosec G115
+	binary.LittleEndian.PutUint64(rec[8*n+8:], uint64(s.size))    // #nosec G115
+	binary.LittleEndian.PutUint64(rec[8*n+16:], uint64(n))        // #nosec G115
+	h.Write(rec)
+	rec = binary.LittleEndian.AppendUint32(rec, h.Sum32())
+	return append(rec, shadowMagic[:]...), nil
+}
+
+func (s *shadowFile) commit() error {
+	if s.broken != nil {
+		return s.broken
+	}
+	info, err := s.target.Stat()
+	if err != nil {
+		return fmt.Errorf("atomic: failed to stat target file: %w", err)
+	}
+	if len(s.order) == 0 && s.truncated == info.Size() && s.size == info.Size() {
+		return nil
+	}
+
+	n := len(s.order)
+	rec, err := s.record(n)
+	if err != nil {
+		return err
+	}
+	if _, err := s.shadow.WriteAt(rec, int64(n)*shadowBlock); err != nil {
+		return fmt.Errorf("atomic: failed to write shadow file: %w", err)
+	}
+	if err := s.shadow.Sync(); err != nil {
+		return fmt.Errorf("atomic: failed to sync shadow file: %w", err)
+	}
+
+	// The transaction is durable now and left to the next OpenFile if it can't be completed.
+	if err := applyShadow(s.target, s.shadow, rec[:len(rec)-12]); err != nil {
+		return s.fail(err)
+	}
+	if err := s.shadow.Truncate(0); err != nil {
+		return s.fail(fmt.Errorf("atomic: failed to truncate shadow file: %w", err))
+	}
+	if err := s.shadow.Sync(); err != nil {
+		return s.fail(fmt.Errorf("atomic: failed to sync shadow file: %w", err))
+	}
+	return s.reset()
+}
+
+// Marks s as broken by err, which occurred while completing a durable transaction, and returns the
+// error returned by all further writes and commits.
+func (s *shadowFile) fail(err error) error {
+	s.broken = fmt.Errorf("%w: %w", ErrBroken, err)
+	return s.broken
+}
+
+func (s *shadowFile) abort() error {
+	if s.broken != nil {
+		return s.broken
+	}
+	if err := s.shadow.Truncate(0); err != nil {
+		return fmt.Errorf("atomic: failed to truncate shadow file: %w", err)
+	}
+	return s.reset()
+}
+
+func (s *shadowFile) close() error {
+	err := errors.Join(s.target.Close(), s.shadow.Close())
+	if s.broken != nil {
+		return err
+	}
+	if rmErr := os.Remove(shadowName(s.name)); rmErr != nil && err == nil {
+		err = fmt.Errorf("atomic: failed to remove shadow file: %w", rmErr)
+	}
+	return err
+}
+
+// Applies the commit record rec without its checksum and magic to target, which is synced
+// afterwards. Applying a record more than once has the same effect as applying it once.
+func applyShadow(target, shadow *os.File, rec []byte) error {
+	n := len(rec)/8 - 3
+	truncated := int64(binary.LittleEndian.Uint64(rec[8*n:])) // #nosec G115
+	size := int64(binary.LittleEndian.Uint64(rec[8*n+8:]))    // #nosec G115
+
+	info, err := target.Stat()
+	if err != nil {
+		return fmt.Errorf("atomic: failed to stat target file: %w", err)
+	}
+	if truncated < info.Size() {
+		if err := target.Truncate(truncated); err != nil {
+			return fmt.Errorf("atomic: failed to truncate target file: %w", err)
+		}
+	}
+
+	block := make([]byte, shadowBlock)
+	for slot := range n {
+		no := int64(binary.LittleEndian.Uint64(rec[8*slot:])) // #nosec G115
+		if no < 0 {
+			continue
+		}
+		if _, err := shadow.ReadAt(block, int64(slot)*shadowBlock); err != nil {
+			return fmt.Errorf("atomic: failed to read shadow file: %w", err)
+		}
+		if _, err := target.WriteAt(block, no*shadowBlock); err != nil {
+			return fmt.Errorf("atomic: failed to write target file: %w", err)
+		}
+	}
+
+	// Blocks reaching over the end of the file are cut off by the final size.
+	if err := target.Truncate(size); err != nil {
+		return fmt.Errorf("atomic: failed to truncate target file: %w", err)
+	}
+	if err := target.Sync(); err != nil {
+		return fmt.Errorf("atomic: failed to sync target file: %w", err)
+	}
+	return nil
+}
+
+// Recovers the shadow file of the target called name, if there is one. A transaction with a valid
+// commit record is applied to the target, while the shadow file is removed in any case. Reports
+// weither a transaction was applied.
+func recoverShadow(name string) (bool, error) {
+	shadow, err := os.Open(shadowName(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("atomic: failed to open shadow file: %w", err)
+	}
+	defer shadow.Close()
+
+	rec, err := readShadowRecord(shadow)
+	if err != nil {
+		return false, err
+	}
+	if rec != nil {
+		target, err := os.OpenFile(name, os.O_RDWR, 0)
+		if err != nil {
+			return false, fmt.Errorf("atomic: failed to open target file: %w", err)
+		}
+		err = applyShadow(target, shadow, rec)
+		if closeErr := target.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := os.Remove(shadowName(name)); err != nil {
+		return false, fmt.Errorf("atomic: failed to remove shadow file: %w", err)
+	}
+	return rec != nil, syncDir(name)
+}
+
+// Returns the commit record of shadow without its checksum and magic, or nil if it has no valid
+// one.
+func readShadowRecord(shadow *os.File) ([]byte, error) {
+	info, err := shadow.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("atomic: failed to stat shadow file: %w", err)
+	}
+	size := info.Size()
+	if size < 36 {
+		return nil, nil
+	}
+
+	trailer := make([]byte, 20)
+	if _, err := shadow.ReadAt(trailer, size-20); err != nil {
+		return nil, fmt.Errorf("atomic: failed to read shadow file: %w", err)
+	}
+	n := binary.LittleEndian.Uint64(trailer)
+	if !bytes.Equal(trailer[12:], shadowMagic[:]) || n > uint64(size)/shadowBlock || // #nosec G115
+		size != int64(n)*(shadowBlock+8)+36 { // #nosec G115
+		return nil, nil
+	}
+
+	h := crc32.New(castagnoli)
+	if _, err := io.Copy(h, io.NewSectionReader(shadow, 0, size-12)); err != nil {
+		return nil, fmt.Errorf("atomic: failed to read shadow file: %w", err)
+	}
+	if h.Sum32() != binary.LittleEndian.Uint32(trailer[8:]) {
+		return nil, nil
+	}
+
+	rec := make([]byte, 8*n+24)
+	if _, err := shadow.ReadAt(rec, int64(n)*shadowBlock); err != nil { // #nosec G115
+		return nil, fmt.Errorf("atomic: failed to read shadow file: %w", err)
+	}
+	return rec, nil
+}
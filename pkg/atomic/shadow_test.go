@@ -0,0 +1,154 @@
+package atomic
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writes the commit record of s without applying it, like a process dying while committing.
+func testCrash(t *testing.T, s *shadowFile) {
+	t.Helper()
+	rec, err := s.record(len(s.order))
+	if err != nil {
+		t.Fatalf("record() failed: %v", err)
+	}
+	if _, err := s.shadow.WriteAt(rec, int64(len(s.order))*shadowBlock); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+	s.target.Close()
+	s.shadow.Close()
+}
+
+func TestRecoverShadow(t *testing.T) {
+	tests := []struct {
+		name    string
+		tear    int64
+		corrupt bool
+		applied bool
+	}{
+		{"durable", 0, false, true},
+		{"torn record", 1, false, false},
+		{"corrupt block", 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "db")
+			committed := bytes.Repeat([]byte("a"), 3*shadowBlock)
+			if err := os.WriteFile(name, committed, 0o600); err != nil {
+				t.Fatalf("WriteFile() failed: %v", err)
+			}
+
+			s, err := openShadow(name, 0o600)
+			if err != nil {
+				t.Fatalf("openShadow() failed: %v", err)
+			}
+			if err := s.truncate(shadowBlock + 10); err != nil {
+				t.Fatalf("truncate() failed: %v", err)
+			}
+			if _, err := s.WriteAt([]byte("bb"), shadowBlock+100); err != nil {
+				t.Fatalf("WriteAt() failed: %v", err)
+			}
+			want := append(committed[:shadowBlock+10:shadowBlock+10], make([]byte, 90)...)
+			want = append(want, "bb"...)
+			testCrash(t, s)
+
+			if tt.tear > 0 {
+				info, err := os.Stat(shadowName(name))
+				if err != nil {
+					t.Fatalf("Stat() failed: %v", err)
+				}
+				if err := os.Truncate(shadowName(name), info.Size()-tt.tear); err != nil {
+					t.Fatalf("Truncate() failed: %v", err)
+				}
+			}
+
+			if tt.corrupt {
+				f, err := os.OpenFile(shadowName(name), os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatalf("OpenFile() failed: %v", err)
+				}
+				if _, err := f.WriteAt([]byte("c"), 100); err != nil {
+					t.Fatalf("WriteAt() failed: %v", err)
+				}
+				f.Close()
+			}
+
+			applied, err := recoverShadow(name)
+			if err != nil {
+				t.Fatalf("recoverShadow() failed: %v", err)
+			}
+			if applied != tt.applied {
+				t.Fatalf("want applied %v, got %v", tt.applied, applied)
+			}
+			if _, err := os.Stat(shadowName(name)); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("want shadow file removed, got %v", err)
+			}
+			if !tt.applied {
+				want = committed
+			}
+			got, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("ReadFile() failed: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("want target of %d bytes, got %d different ones", len(want), len(got))
+			}
+		})
+	}
+}
+
+// A transaction whose record is durable but can't be applied is kept for the next OpenFile.
+func TestShadowFile_applyFailure(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "db")
+	committed := bytes.Repeat([]byte("a"), 2*shadowBlock)
+	if err := os.WriteFile(name, committed, 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	s, err := openShadow(name, 0o600)
+	if err != nil {
+		t.Fatalf("openShadow() failed: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("bb"), 10); err != nil {
+		t.Fatalf("WriteAt() failed: %v", err)
+	}
+
+	// Writes into a read-only target fail.
+	s.target.Close()
+	if s.target, err = os.Open(name); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if err := s.commit(); !errors.Is(err, ErrBroken) {
+		t.Fatalf("want ErrBroken, got %v", err)
+	}
+	if _, err := s.WriteAt([]byte("c"), 0); !errors.Is(err, ErrBroken) {
+		t.Fatalf("want ErrBroken from WriteAt, got %v", err)
+	}
+	if err := s.abort(); !errors.Is(err, ErrBroken) {
+		t.Fatalf("want ErrBroken from abort, got %v", err)
+	}
+	if err := s.commit(); !errors.Is(err, ErrBroken) {
+		t.Fatalf("want ErrBroken from commit, got %v", err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatalf("close() failed: %v", err)
+	}
+
+	f, err := OpenFile(name, 0o600)
+	if err != nil {
+		t.Fatalf("OpenFile() failed: %v", err)
+	}
+	defer f.Close()
+	want := bytes.Clone(committed)
+	copy(want[10:], "bb")
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("want durable transaction applied by OpenFile, got %q", got[:12])
+	}
+}
@@ -11,6 +11,7 @@ var (
 	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
 
 	procMoveFileExW = modkernel32.NewProc("MoveFileExW")
+	procLockFileEx  = modkernel32.NewProc("LockFileEx")
 )
 
 func moveFileEx(lpExistingFileName *uint16, lpNewFileName *uint16, dwFlags uint32) (err error) {
@@ -30,3 +31,30 @@ func moveFileEx(lpExistingFileName *uint16, lpNewFileName *uint16, dwFlags uint3
 	}
 	return
 }
+
+func lockFileEx(
+	hFile syscall.Handle,
+	dwFlags uint32,
+	dwReserved uint32,
+	nNumberOfBytesToLockLow uint32,
+	nNumberOfBytesToLockHigh uint32,
+	lpOverlapped *syscall.Overlapped,
+) (err error) {
+	r1, _, e1 := syscall.SyscallN(
+		procLockFileEx.Addr(),
+		uintptr(hFile),
+		uintptr(dwFlags),
+		uintptr(dwReserved),
+		uintptr(nNumberOfBytesToLockLow),
+		uintptr(nNumberOfBytesToLockHigh),
+		uintptr(unsafe.Pointer(lpOverlapped)),
+	)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
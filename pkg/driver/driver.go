@@ -1 +1,62 @@
 package driver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gkits/pavosql/internal/db"
+)
+
+var ErrInvalidDSN = errors.New("driver: invalid dsn")
+
+// A Config holds the settings of a DSN, which has the form
+//
+//	name
+//
+// where name is the name of the database file, optionally prefixed by "file:", or ":memory:" for an
+// in-memory database.
+type Config struct {
+	Name string
+}
+
+// Parses the DSN dsn. Returns ErrInvalidDSN if dsn has no name or any options.
+func ParseDSN(dsn string) (Config, error) {
+	name, query, hasQuery := strings.Cut(dsn, "?")
+	c := Config{Name: strings.TrimPrefix(name, "file:")}
+	if c.Name == "" {
+		return Config{}, fmt.Errorf("%w: missing name", ErrInvalidDSN)
+	}
+	if hasQuery {
+		return Config{}, fmt.Errorf("%w: unknown options %q", ErrInvalidDSN, query)
+	}
+	return c, nil
+}
+
+// Reports weither c describes an in-memory database.
+func (c Config) Memory() bool {
+	return c.Name == db.Memory
+}
+
+// A DB is a database opened by Open.
+type DB struct {
+	db *db.DB
+}
+
+// Opens the database described by the DSN dsn. Every in-memory database is a new, empty one.
+func Open(dsn string) (*DB, error) {
+	c, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	d, err := db.Open(c.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: d}, nil
+}
+
+// Closes d, which discards an in-memory database. d can't be used anymore after Close returned.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
@@ -0,0 +1,65 @@
+package driver_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gkits/pavosql/pkg/driver"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want driver.Config
+		err  error
+	}{
+		{":memory:", driver.Config{Name: ":memory:"}, nil},
+		{"file::memory:", driver.Config{Name: ":memory:"}, nil},
+		{"data/test.db", driver.Config{Name: "data/test.db"}, nil},
+		{"file:test.db", driver.Config{Name: "test.db"}, nil},
+		{"", driver.Config{}, driver.ErrInvalidDSN},
+		{"file:", driver.Config{}, driver.ErrInvalidDSN},
+		{"test.db?cache=10", driver.Config{}, driver.ErrInvalidDSN},
+		{"test.db?", driver.Config{}, driver.ErrInvalidDSN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			got, err := driver.ParseDSN(tt.dsn)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want error %v, got %v", tt.err, err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	d, err := driver.Open("file:" + name)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("database file wasn't created: %v", err)
+	}
+}
+
+func TestOpen_memory(t *testing.T) {
+	d, err := driver.Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := os.Stat(":memory:"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("in-memory database touched the disk: %v", err)
+	}
+}
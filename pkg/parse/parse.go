@@ -1,11 +1,15 @@
 package parse
 
 import (
+	"errors"
+	"fmt"
 	"io"
 
 	"github.com/gkits/pavosql/pkg/ast"
 )
 
+var ErrUnexpectedToken = errors.New("parse: unexpected token")
+
 func Parse(r io.Reader) ([]ast.Stmnt, error) {
 	var (
 		stmt ast.Stmnt
@@ -13,7 +17,10 @@ func Parse(r io.Reader) ([]ast.Stmnt, error) {
 	)
 
 	stmts := []ast.Stmnt{}
-	toks := readTokens(r)
+	// Stops the tokenizer if parsing ends before all tokens were read.
+	done := make(chan struct{})
+	defer close(done)
+	toks := readTokens(r, done)
 	for tok := range toks {
 		switch tok.Type {
 		case Select:
@@ -25,6 +32,13 @@ func Parse(r io.Reader) ([]ast.Stmnt, error) {
 		case Create:
 		case Update:
 		case Insert:
+		case Vacuum:
+			stmt, err = parseVacuumStmt(toks)
+			if err != nil {
+				return nil, err
+			}
+		case Semicolon:
+			continue
 		default:
 		}
 		stmts = append(stmts, stmt)
@@ -51,17 +65,34 @@ func parseUpdateStmt() {}
 
 func parseInsertStmt() {}
 
+// Parses the rest of a VACUUM statement, which has no arguments and ends with a semicolon or the
+// end of the input.
+func parseVacuumStmt(toks <-chan Token) (ast.VacuumStmt, error) {
+	tok, ok := <-toks
+	if ok && tok.Type != Semicolon {
+		return ast.VacuumStmt{}, fmt.Errorf("%w: %q at %d:%d", ErrUnexpectedToken, tok.Val, tok.Line,
+			tok.Column)
+	}
+	return ast.VacuumStmt{}, nil
+}
+
 func parseFieldSelectList(toks <-chan Token) {
 	for tok := range toks {
 		_ = tok
 	}
 }
 
-func readTokens(r io.Reader) <-chan Token {
+// Returns the tokens of r, which are read until all of them have been received or done is closed.
+func readTokens(r io.Reader, done <-chan struct{}) <-chan Token {
 	toks := make(chan Token)
 	go func() {
+		defer close(toks)
 		for _, tok := range tokenize(r) {
-			toks <- tok
+			select {
+			case toks <- tok:
+			case <-done:
+				return
+			}
 		}
 	}()
 	return toks
@@ -1,8 +1,13 @@
 package parse_test
 
 import (
+	"errors"
 	"io"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gkits/pavosql/pkg/ast"
 	"github.com/gkits/pavosql/pkg/parse"
@@ -16,7 +21,11 @@ func TestParse(t *testing.T) {
 		want    []ast.Stmnt
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"vacuum", strings.NewReader("VACUUM"), []ast.Stmnt{ast.VacuumStmt{}}, false},
+		{"vacuum with semicolon", strings.NewReader("vacuum;"), []ast.Stmnt{ast.VacuumStmt{}}, false},
+		{"vacuum twice", strings.NewReader("VACUUM; VACUUM;"),
+			[]ast.Stmnt{ast.VacuumStmt{}, ast.VacuumStmt{}}, false},
+		{"vacuum with argument", strings.NewReader("VACUUM users;"), nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -30,10 +39,29 @@ func TestParse(t *testing.T) {
 			if tt.wantErr {
 				t.Fatal("Parse() succeeded unexpectedly")
 			}
-			// TODO: update the condition below to compare got with tt.want.
-			if true {
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Parse() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestParse_error(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for range 100 {
+		// The statements behind the malformed one are never read.
+		r := strings.NewReader("VACUUM users;" + strings.Repeat(" VACUUM;", 100))
+		if _, err := parse.Parse(r); !errors.Is(err, parse.ErrUnexpectedToken) {
+			t.Fatalf("want ErrUnexpectedToken, got %v", err)
+		}
+	}
+
+	// The tokenizers of the failed calls stop asynchronously.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d goroutines after failed Parse(), got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -50,6 +50,7 @@ const (
 	Not
 	And
 	Or
+	Vacuum
 )
 
 var keywords map[string]TokenType = map[string]TokenType{
@@ -69,6 +70,7 @@ var keywords map[string]TokenType = map[string]TokenType{
 	"not":    Not,
 	"and":    And,
 	"or":     Or,
+	"vacuum": Vacuum,
 }
 
 var specialChars map[string]TokenType = map[string]TokenType{